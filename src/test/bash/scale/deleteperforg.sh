@@ -3,6 +3,7 @@
 # Completely cleans up after a node/agbot performance test run by deleting the org used for that.
 # This can not be done inside the scripts running the perf test, because if multiple instances are run on multiple machines they can't know when
 # they are all done with the org.
+# To delete only the resources of 1 run (by name prefix), or to list what is left in the org, use the go cleanup command instead.
 
 #if [[ -z $1 ]]; then
 #	echo "Usage: $0 <perf-org>"
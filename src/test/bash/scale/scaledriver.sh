@@ -16,6 +16,9 @@ Drives the overall process of scale testing the Exchange by doing these main ste
 - Uses pslurp to copy the output from the hosts $EX_PERF_REPORT_DIR dir to the same dir on this machine (separated into hostname subdirs)
 - Inspects all of the error files to report how many errors occurred
 
+As an alternative that does not need parallel-ssh, start the node and agbot binaries in worker mode on each scale node
+(e.g. node --worker :8510) and run src/test/go/coordinator with a plan file instead of this script.
+
 This script is dependent on the parallel-ssh suite of commands, see https://www.cyberciti.biz/cloud-computing/how-to-use-pssh-parallel-ssh-program-on-linux-unix/
 for installing and using them.
 
@@ -35,6 +35,22 @@ trapHandler() {
 #done
 rm -rf $EX_PERF_REPORT_DIR/*
 
+# If EX_PERF_BARRIER is set, have the Go driver instances wait for each other to finish their setup before starting their timed phase (see
+# WaitForStartBarrier() in perfutils). The bash drivers (*.sh) do not wait at the barrier, so they are not counted.
+if [[ -n "$EX_PERF_BARRIER" && -z "$EX_PERF_START_AT" && -z "$EX_PERF_BARRIER_DIR" ]]; then
+    totalInstances=0
+    args=("${@:2}")
+    for (( a=0 ; a<${#args[@]} ; a+=2 )) ; do
+        if [[ "${args[$a+1]}" != *.sh ]]; then
+            totalInstances=$(( totalInstances + ${args[$a]} ))
+        fi
+    done
+    if [[ $totalInstances -gt 0 ]]; then
+        export EX_PERF_BARRIER_DIR="$EX_PERF_REPORT_DIR/barrier-$namebase"
+        export EX_PERF_BARRIER_COUNT=$totalInstances
+    fi
+fi
+
 # Loop thru arg pairs (this 1st shift gets rid of namebase)
 while shift; do
     if [[ -z "$1" ]]; then break; fi   # we are done
@@ -1,7 +1,8 @@
-// Performance test simulating an agbot making calls to the exchange. For scale testing, run several instances of this using wrapper.sh
-package main
+// The agbot driver of exchperf: a performance test simulating an agbot making calls to the exchange. For scale testing, run several instances of it using wrapper.sh or the coordinator
+package agbot
 
 import (
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,17 +12,18 @@ import (
 	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
 )
 
-func Usage(exitCode int) {
-	fmt.Printf("Usage: %s <name base> [short-hostname]\n", perfutils.GetShortBinaryName())
-	os.Exit(exitCode)
+func Usage(prog string, flags *flag.FlagSet) {
+	fmt.Printf("Usage: %s [flags] <name base> [short-hostname]\n", prog)
+	fmt.Printf("       %s [flags] --worker <listen-address>    (wait for the coordinator to assign the name base, hostname, and settings)\n", prog)
+	perfutils.PrintSettingsUsage(perfutils.DRIVER_AGBOT, flags)
 }
 
 // Response for getting the patterns from the exchange
 type ExchangePatterns struct {
-	Patterns map[string]interface{} `json:"patterns"`
+	Patterns map[string]ExchangePattern `json:"patterns"`
 }
 
-// Response from the exchange for searching for nodes using a pattern
+// Response from the exchange for searching for nodes using a pattern (the business policy search returns the same structure)
 type PatternSearchNodes struct {
 	Id string `json:"id"`
 }
@@ -29,38 +31,85 @@ type ExchangePatternSearch struct {
 	Nodes []PatternSearchNodes `json:"nodes"`
 }
 
-func main() {
-	if len(os.Args) <= 1 {
-		Usage(1)
+// Response for getting the business policies from the exchange
+type ExchangeBusinessPolicies struct {
+	BusinessPolicy map[string]ExchangeBusinessPolicy `json:"businessPolicy"`
+}
+
+// searchNodes runs a pattern or business policy search, and returns the ids of the nodes it found (with the org prepended), and false if it failed
+func searchNodes(url string, auth perfutils.Credentials, body string) ([]string, bool) {
+	perfutils.Verbose("Running POST (%s) %s", auth, url)
+	var nodeResp ExchangePatternSearch
+	httpCode := perfutils.ExchangeP(http.MethodPost, url, auth, []int{404, 400, 409}, body, &nodeResp, true)
+	if httpCode != 201 && httpCode != 404 { // even with 404 we get a valid response structure
+		return nil, false
+	}
+	var ids []string
+	for _, n := range nodeResp.Nodes {
+		ids = append(ids, n.Id)
+	}
+	return ids, true
+}
+
+// nodeLastUpdated gets when the node (with the org prepended) last changed, and false if it no longer exists
+func nodeLastUpdated(nodeId string, auth perfutils.Credentials) (time.Time, bool) {
+	var nodeDetails perfutils.ExchangeNodes
+	if httpCode := perfutils.ExchangeGet("orgs/"+nodeId, auth, []int{404}, &nodeDetails); httpCode != 200 {
+		return time.Time{}, false
+	}
+	for _, n := range nodeDetails.Nodes {
+		if t, err := perfutils.ParseExchangeTime(n.LastUpdated); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Main runs the agbot driver with the command line args. prog is how it was invoked (e.g. "exchperf agbot"), for the usage.
+func Main(prog string, args []string) {
+	flags := flag.NewFlagSet(prog, flag.ExitOnError)
+	flags.Usage = func() { Usage(prog, flags) }
+	workerAddress := flags.String("worker", "", "run in worker mode, listening on this address")
+	args = perfutils.ParseConfig(perfutils.DRIVER_AGBOT, flags, args)
+
+	// In worker mode the coordinator gives us our name base
+	if *workerAddress != "" {
+		perfutils.RunWorker(*workerAddress, runAgbotTest)
+		return
+	}
+	if len(args) == 0 {
+		Usage(prog, flags)
+		os.Exit(1)
 	}
 
-	scriptName := perfutils.GetShortBinaryName()
-	namebase := os.Args[1] + "-agbot"
 	/* currently this doesn't need the hostname...
 	var hostname = "" // this is for exchange resources that should only be created 1 per host
-	if len(os.Args) >= 3 {
-		hostname = os.Args[2]
+	if len(args) >= 2 {
+		hostname = args[1]
 	} */
+	runAgbotTest(args[0], "")
+}
+
+// runAgbotTest runs the whole agbot simulation: setup, the agreement check loop, and clean up
+func runAgbotTest(namebaseArg, hostname string) {
+	scriptName := perfutils.GetDriverName()
+	namebase := namebaseArg + "-agbot"
 
-	rootauth := "root/root:" + perfutils.GetRequiredEnvVar("EXCHANGE_ROOTPW")
-	EXCHANGE_IAM_KEY := perfutils.GetRequiredEnvVar("EXCHANGE_IAM_KEY")
-	EXCHANGE_IAM_EMAIL := perfutils.GetRequiredEnvVar("EXCHANGE_IAM_EMAIL")
+	rootauth := perfutils.GetRootCredentials()
 	HZN_EXCHANGE_URL := perfutils.GetRequiredEnvVar("HZN_EXCHANGE_URL")
-	// Setting EXCHANGE_IAM_ACCOUNT_ID (id of your cloud account) distinguishes this as an ibm public cloud environment, instead of ICP
-	EXCHANGE_IAM_ACCOUNT_ID := os.Getenv("EXCHANGE_IAM_ACCOUNT_ID")
 
 	// default of where to write the summary or error msgs. Can be overridden
-	EX_PERF_REPORT_DIR := perfutils.GetEnvVarWithDefault("EX_PERF_REPORT_DIR", "/tmp/exchangePerf")
+	EX_PERF_REPORT_DIR := perfutils.ConfigString("EX_PERF_REPORT_DIR")
 	reportDir := EX_PERF_REPORT_DIR + "/" + scriptName
 	// this file holds the summary stats, and any errors that may have occurred along the way
 	perfutils.EX_PERF_REPORT_FILE = perfutils.GetEnvVarWithDefault("EX_PERF_REPORT_FILE", reportDir+"/"+namebase+".summary")
 
 	// The length of the performance test, measured in the number of times each agbot checks for agreements (by default 10 sec each)
-	numAgrChecks := perfutils.GetEnvVarIntWithDefault("EX_PERF_NUM_AGR_CHECKS", 90)
+	numAgrChecks := perfutils.ConfigInt("EX_PERF_NUM_AGR_CHECKS")
 	// How many agbots this instance should simulate
-	numAgbots := perfutils.GetEnvVarIntWithDefault("EX_PERF_NUM_AGBOTS", 1)
+	numAgbots := perfutils.ConfigInt("EX_PERF_NUM_AGBOTS")
 	// How many msgs should be created for each agbot (to simulate agreement negotiation)
-	numMsgs := perfutils.GetEnvVarIntWithDefault("EX_PERF_NUM_MSGS", 50)
+	numMsgs := perfutils.ConfigInt("EX_PERF_NUM_MSGS")
 
 	/* These defaults are taken from /etc/horizon/anax.json
 	"NewContractIntervalS": 10, (gets patterns and policies, and do both /search apis)
@@ -71,46 +120,40 @@ func main() {
 	AgreementBot.CheckUpdatedPolicyS: 15 (check for updated policies)
 	AgreementTimeoutS
 	*/
-	newAgreementInterval := perfutils.GetEnvVarIntWithDefault("EX_AGBOT_NEW_AGR_INTERVAL", 10)
+	newAgreementInterval := perfutils.ConfigInt("EX_AGBOT_NEW_AGR_INTERVAL")
 	// Note: the default value of newAgreementInterval and processGovInterval are the same, so for now we assume they are the same value
-	//processGovInterval := perfutils.GetEnvVarIntWithDefault("EX_AGBOT_PROC_GOV_INTERVAL", 10)
-	agbotHbInterval := perfutils.GetEnvVarIntWithDefault("EX_AGBOT_HB_INTERVAL", 60)
-	versionCheckInterval := perfutils.GetEnvVarIntWithDefault("EX_AGBOT_VERSION_CHECK_INTERVAL", 60)
+	//processGovInterval := perfutils.ConfigInt("EX_AGBOT_PROC_GOV_INTERVAL")
+	agbotHbInterval := perfutils.ConfigInt("EX_AGBOT_HB_INTERVAL")
+	versionCheckInterval := perfutils.ConfigInt("EX_AGBOT_VERSION_CHECK_INTERVAL")
 
-	shortCircuitChkInterval := perfutils.GetEnvVarIntWithDefault("EX_AGBOT_SHORT_CIRCUIT_CHK_INTERVAL", 7)
-	requiredEmptyIntervals := perfutils.GetEnvVarIntWithDefault("EX_AGBOT_SHORT_CIRCUIT_EMPTY_INTERVALS", 3)
+	// EX_AGBOT_NUM_NODE_INSTANCES is the min number of node.go instances to wait for. We stop when all of the node.go instances we have seen are done, and there are at least that many.
+	// EX_AGBOT_NO_NODE_TRACKING can be set to always run all of the agreement checks, instead of stopping when the node.go instances are done
 	// EX_AGBOT_NO_SLEEP can be set to disable sleeping if it finishes an interval early
 	// EX_AGBOT_CREATE_SERVICE can be set to have this script create 1 service, so the nodes finds something
 	// EX_AGBOT_CREATE_PATTERN can be set to have this script create 1 pattern, so it finds something even if node.go is not running
 	var createPattern bool = false
-	if os.Getenv("EX_AGBOT_CREATE_PATTERN") != "" && os.Getenv("EX_AGBOT_CREATE_SERVICE") != "" {
+	if perfutils.ConfigBool("EX_AGBOT_CREATE_PATTERN") && perfutils.ConfigBool("EX_AGBOT_CREATE_SERVICE") {
 		createPattern = true // can only create a pattern if the service exists
 	}
 
 	// CURL_CA_BUNDLE can be exported in our parent if a self-signed cert is needed.
 
 	// This script will create just 1 org and put everything else under that. If you use wrapper.sh, all instances of this script and agbot.sh should use the same org.
-	org := perfutils.GetEnvVarWithDefault("EX_PERF_ORG", "performancenodeagbot")
-
-	// Determine whether we are using the public cloud or ICP
-	var userauth string
-	if EXCHANGE_IAM_ACCOUNT_ID != "" {
-		userauth = org + "/iamapikey:" + EXCHANGE_IAM_KEY
-	} else {
-		// for ICP we can't play the game of associating our own org with another account, so we have to create/use a local exchange user
-		userauth = org + "/" + EXCHANGE_IAM_EMAIL + ":" + EXCHANGE_IAM_KEY
-	}
+	org := perfutils.ConfigString("EX_PERF_ORG")
+
+	// EX_PERF_AUTH_MODE can be set to how the exchange user authenticates: basic, iamapikey, or bearer. The default depends on whether we are using the public cloud or ICP
+	userauth := perfutils.GetUserCredentials(org)
 
 	nodebase := namebase + "-n"
 	nodeid := nodebase + "1"
 	nodetoken := "abc123"
-	nodeauth := org + "/" + nodeid + ":" + nodetoken
+	nodeauth := perfutils.NodeToken{Org: org, NodeId: nodeid, Token: nodetoken}
 
 	// this agbot id can not conflict with the agbots that agbot.go creates
 	agbotbase := namebase + "-a"
 	agbottoken := "abcdef"
 
-	// The svcurl value must match what node.go is using
+	// The svcurl value must match what node.go is using. The version is the 1st one, node.go can roll out newer ones (EX_PERF_ROLLOUT_HB).
 	svcurl := "nodeagbotsvc"
 	svcversion := "1.2.3"
 	svcarch := "amd64"
@@ -126,54 +169,56 @@ func main() {
 
 	// =========== Initialization =================================================
 
+	perfutils.SetPhase("setup")
 	fmt.Printf("Initializing agbot test for %s, with %d agreement checks:\n", namebase, numAgrChecks)
 	fmt.Println("Using exchange " + HZN_EXCHANGE_URL)
 
 	// Prepare the output dir
 	perfutils.MakeDir(reportDir)
 	perfutils.RemoveFile(perfutils.EX_PERF_REPORT_FILE)
+	// Keep the effective config with the results, so this run can be reproduced by passing it to --config
+	perfutils.WriteEffectiveConfig(reportDir + "/" + namebase + ".config.json")
+
+	// Check for (and create if necessary) each resource we need, recording them in our manifest so a crashed run can be resumed or torn down
+	manifest := perfutils.LoadSetupManifest(namebase, org)
 
 	// Can not delete the org in case other instances of this script are using it. Whoever calls this script must delete it afterward.
-	// So this is tolerant of the org already existing
-	if EXCHANGE_IAM_ACCOUNT_ID != "" {
-		// Using the public cloud
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org, rootauth, []int{403}, `{ "label": "perf test org", "description": "blah blah", "tags": { "ibmcloud_id": "`+EXCHANGE_IAM_ACCOUNT_ID+`" } }`, nil, false)
-		// normally the exchange would automatically create this the 1st time it is used. But until issue 176 is fixed we need to explicitly create it. We'll get 400 if it was already created by another instance
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/users/"+EXCHANGE_IAM_EMAIL, rootauth, []int{400}, `{"password": "foobar", "admin": false, "email": "`+EXCHANGE_IAM_EMAIL+`"}`, nil, false)
-		perfutils.ExchangeGet("orgs/"+org+"/users/iamapikey", userauth, nil, nil)
-	} else {
-		// Using ICP
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org, rootauth, []int{403}, `{ "label": "perf test org", "description": "blah blah" }`, nil, false)
-		// for ICP we can't play the game of associating our own org with another account, so we have to create/use a local exchange user. We'll get 400 if it was already created by another instance
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/users/"+EXCHANGE_IAM_EMAIL, rootauth, []int{400}, `{"password": "`+EXCHANGE_IAM_KEY+`", "admin": false, "email": "`+EXCHANGE_IAM_EMAIL+`"}`, nil, false)
-		perfutils.ExchangeGet("orgs/"+org+"/users/"+EXCHANGE_IAM_EMAIL, userauth, nil, nil)
+	// So the org and user are shared
+	if userId := manifest.EnsurePerfOrgAndUser(org, rootauth, userauth); userId != "" {
+		perfutils.ExchangeGet("orgs/"+org+"/users/"+userId, userauth, nil, nil)
 	}
 
 	// let node.go create most of the services, patterns, and business policies
 
-	// Create the agbots and configure them to watch the patterns
+	// Create the agbots and configure them to watch the patterns. The exchange makes the id of an agbot pattern from its 3 fields
 	for a := 1; a <= numAgbots; a++ {
 		myagbotid := agbotbase + strconv.Itoa(a)
-		perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/agbots/"+myagbotid, userauth, nil, `{"token": "`+agbottoken+`", "name": "agbot", "publicKey": "ABC"}`, nil, false)
+		manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + org + "/agbots/" + myagbotid, CreateMethod: http.MethodPut, UpdateMethod: http.MethodPut, Auth: userauth, Ignore: []string{"token"},
+			Body: `{"token": "` + agbottoken + `", "name": "agbot", "publicKey": "ABC"}`})
 
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/agbots/"+myagbotid+"/patterns", userauth, nil, `{"patternOrgid": "`+org+`", "pattern": "*", "nodeOrgid": "`+org+`"}`, nil, false)
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/agbots/"+myagbotid+"/patterns", userauth, nil, `{"patternOrgid": "IBM", "pattern": "*", "nodeOrgid": "`+org+`"}`, nil, false)
+		for _, patternOrg := range []string{org, "IBM"} {
+			manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + org + "/agbots/" + myagbotid + "/patterns/" + patternOrg + "_*_" + org, CreateMethod: http.MethodPost, CreatePath: "orgs/" + org + "/agbots/" + myagbotid + "/patterns", Auth: userauth,
+				Body: `{"patternOrgid": "` + patternOrg + `", "pattern": "*", "nodeOrgid": "` + org + `"}`})
+		}
 	}
 
-	if os.Getenv("EX_AGBOT_CREATE_SERVICE") != "" {
-		// Create 1 svc so the nodes find at least 1 svc
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/services", userauth, []int{403}, `{"label": "svc", "public": true, "url": "`+svcurl+`", "version": "`+svcversion+`", "sharable": "singleton",
-		  "deployment": "{\"services\":{\"svc\":{\"image\":\"openhorizon/gps:1.2.3\"}}}", "deploymentSignature": "a", "arch": "`+svcarch+`" }`, nil, false)
+	if perfutils.ConfigBool("EX_AGBOT_CREATE_SERVICE") {
+		// Create 1 svc so the nodes find at least 1 svc. This is the same svc node.go creates, so it is shared
+		manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + org + "/services/" + svcid, CreateMethod: http.MethodPost, CreatePath: "orgs/" + org + "/services", UpdateMethod: http.MethodPut, Auth: userauth, Shared: true,
+			Body: `{"label": "svc", "public": true, "url": "` + svcurl + `", "version": "` + svcversion + `", "sharable": "singleton",
+		  "deployment": "{\"services\":{\"svc\":{\"image\":\"openhorizon/gps:1.2.3\"}}}", "deploymentSignature": "a", "arch": "` + svcarch + `" }`})
 	}
 
+	// Create 1 node to be able to create agbot msgs
+	nodePattern := ""
 	if createPattern {
-		// Create 1 svc, pattern, and node to be able to create agbot msgs, and to have pattern search return at least 1 node
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/patterns/"+patternid, userauth, nil, `{"label": "pat", "public": false, "services": [{ "serviceUrl": "`+svcurl+`", "serviceOrgid": "`+org+`", "serviceArch": "`+svcarch+`", "serviceVersions": [{ "version": "`+svcversion+`" }] }] }`, nil, false)
-		perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+nodeid, userauth, nil, `{"token": "`+nodetoken+`", "name": "pi", "pattern": "`+org+`/`+patternid+`", "arch": "`+svcarch+`", "publicKey": "ABC"}`, nil, false)
-	} else {
-		// Create 1 node to be able to create agbot msgs
-		perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+nodeid, userauth, nil, `{"token": "`+nodetoken+`", "name": "pi", "pattern": "", "arch": "`+svcarch+`", "publicKey": "ABC"}`, nil, false)
+		// Also create 1 pattern for the node, to have pattern search return at least 1 node
+		manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + org + "/patterns/" + patternid, CreateMethod: http.MethodPost, UpdateMethod: http.MethodPut, Auth: userauth,
+			Body: `{"label": "pat", "public": false, "services": [{ "serviceUrl": "` + svcurl + `", "serviceOrgid": "` + org + `", "serviceArch": "` + svcarch + `", "serviceVersions": [{ "version": "` + svcversion + `" }] }] }`})
+		nodePattern = org + "/" + patternid
 	}
+	manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + org + "/nodes/" + nodeid, CreateMethod: http.MethodPut, UpdateMethod: http.MethodPut, Auth: userauth, Ignore: []string{"token"},
+		Body: `{"token": "` + nodetoken + `", "name": "pi", "pattern": "` + nodePattern + `", "arch": "` + svcarch + `", "publicKey": "ABC"}`})
 
 	// Create agbot msgs
 	for a := 1; a <= numAgbots; a++ {
@@ -182,12 +227,19 @@ func main() {
 			perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/agbots/"+myagbotid+"/msgs", nodeauth, nil, `{"message": "hey there", "ttl": 8640000}`, nil, true) // ttl is 2400 hours - make sure they are there for the life of the test
 		}
 	}
+	manifest.MarkSetupDone()
 	//todo: add policy objects and use them below
 
 	// =========== Loop thru repeated exchange calls =================================================
 
+	// Wait for all of the other instances to finish their setup, so the timed phases all start together
+	perfutils.WaitForStartBarrier(namebase)
+
 	// start timing now
-	perfutils.TotalOps = 0
+	perfutils.SetPhase("run")
+	perfutils.ResetTotalOps()
+	perfutils.ResetConnStats()
+	perfutils.ResetRouteStats()
 	t1 := time.Now()
 
 	fmt.Printf("\nRunning %d agreement checks for %d agbots:\n", numAgrChecks, numAgbots)
@@ -198,10 +250,20 @@ func main() {
 	nodesMinProcessed := 100000
 	nodesMaxProcessed := 0
 	nodesLastProcessed := 0
+	suspendedSkipped := 0                   // candidate nodes we did not negotiate with because their service is suspended
+	suspendedNodes := map[string]struct{}{} // the distinct nodes we have seen suspended
+	staleness := newStalenessChecker()
+	pager := newSearchPager()
+	fleet := newFleetChecker()
+	renegotiation := newRenegotiator(svcversion, 2*newAgreementInterval)
 	var iterDeltaTotal time.Duration = 0
 	var sleepTotal time.Duration = 0
-	shortCircuit := false // detected that all node.go instances don't have any nodes w/o agreements any more, so we should stop sleeping
-	emptyIntervals := 0   // how many intervals we have had with no patterns found
+	numChecksDone := 0
+	// The node.go instances publish when they are done simulating their nodes, so we can stop then
+	var nodeTracker *perfutils.NodeDriverTracker
+	if !perfutils.ConfigBool("EX_AGBOT_NO_NODE_TRACKING") {
+		nodeTracker = perfutils.NewNodeDriverTracker(org, userauth)
+	}
 
 	for h := 1; h <= numAgrChecks; h++ {
 		fmt.Printf("Agbot agreement check %d of %d\n", h, numAgrChecks)
@@ -212,7 +274,42 @@ func main() {
 
 		for a := 1; a <= numAgbots; a++ {
 			myagbotid := agbotbase + strconv.Itoa(a)
-			myagbotauth := org + "/" + myagbotid + ":" + agbottoken
+			myagbotauth := perfutils.AgbotToken{Org: org, AgbotId: myagbotid, Token: agbottoken}
+			// negotiate simulates agreement negotiation with a node (with the org prepended) a search found, by posting some short-lived
+			// msgs to it, unless its service is suspended or is not for its arch
+			negotiate := func(search, id string, arches []string) {
+				nid := perfutils.TrimOrg(id) // the node ids are returned to us with the org prepended
+				perfutils.Verbose("Node %s", nid)
+				// the acceptable 404 http codes below handle the case in which the node was deleted between the time of the search and now
+				var nodeDetails perfutils.ExchangeNodes
+				if httpCode := perfutils.ExchangeGet("orgs/"+org+"/nodes/"+nid, myagbotauth, []int{404}, &nodeDetails); httpCode == 200 {
+					for _, n := range nodeDetails.Nodes {
+						renegotiation.nodeArches[id] = n.Arch
+						if !fleet.check(search, id, n, arches) {
+							return
+						}
+					}
+				}
+				if nodeDetails.ServiceSuspended(org + "/" + svcurl) {
+					suspendedSkipped++
+					suspendedNodes[nid] = struct{}{}
+					return
+				}
+				for i := 1; i <= 2; i++ {
+					perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/nodes/"+nid+"/msgs", myagbotauth, []int{404}, `{"message": "hey there", "ttl": 5}`, nil, true)
+				}
+				// we query our own msgs below, so don't have to do that here
+			}
+			// renegotiate proposes an agreement for the version (that its pattern or business policy deploys now) to a node (with the org
+			// prepended) that nodehealth returned with an agreement, if that agreement is for another version. The node replies in our msgs.
+			renegotiate := func(id, version string) {
+				if !renegotiation.needsProposal(myagbotid, id, version) {
+					return
+				}
+				perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/nodes/"+perfutils.TrimOrg(id)+"/msgs", myagbotauth, []int{404},
+					`{"message": "`+perfutils.AgreementMsg(perfutils.MSG_PROPOSAL, version)+`", "ttl": `+strconv.Itoa(renegotiation.ttl)+`}`, nil, true)
+				renegotiation.proposed(myagbotid, id)
+			}
 
 			// we don't actually use this info, but the agbots query it, so we should
 			perfutils.ExchangeGet("orgs/"+org+"/agbots/"+myagbotid+"/patterns", myagbotauth, nil, nil)
@@ -229,31 +326,57 @@ func main() {
 				//perfutils.Debug("patterns: %v", patResp)
 				numPatterns := len(patResp.Patterns)
 				fmt.Printf("Agbot %d processing %d patterns\n", a, numPatterns)
-				if numPatterns == 0 || (createPattern && numPatterns == 1) {
-					// this will only be the case when all of the nodes have unregistered
-					emptyIntervals++
-				} else {
-					emptyIntervals = 0 // reset it
-				}
 				patsMaxProcessed = perfutils.MaxInt(patsMaxProcessed, numPatterns)
 				numAgrChkNodes := 0
 				// Loop thru the patterns this agbot is serving
-				for p := range patResp.Patterns {
+				for p, patDetails := range patResp.Patterns {
 					pat := perfutils.TrimOrg(p) // the pattern ids are returned to us with the org prepended
 
-					// run nodehealth for this pattern. Not sure yet what to do with this result yet
-					perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/patterns/"+pat+"/nodehealth", myagbotauth, []int{404}, `{ "lastTime": "" }`, nil, true) // empty string for lastTime will return all nodes
+					// run nodehealth for this pattern. Unless we are verifying staleness, lastTime is the empty string, which will return all nodes.
+					// The nodes with an agreement for another version than the pattern deploys now (after a rollout) are renegotiated.
+					lastTime := staleness.lastTime(time.Now())
+					version := patDetails.version(org + "/" + svcurl)
+					var healthResp perfutils.ExchangeNodes
+					if httpCode := perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/patterns/"+pat+"/nodehealth", myagbotauth, []int{404}, `{ "lastTime": "`+lastTime+`" }`, &healthResp, true); httpCode == 201 {
+						if staleness.enabled() {
+							staleness.checkHealth(healthResp, lastTime)
+						}
+						for id, n := range healthResp.Nodes {
+							if len(n.Agreements) > 0 {
+								renegotiate(id, version)
+							}
+						}
+					}
+					patSvcid := svcid
+					if arches := patDetails.arches(org + "/" + svcurl); version != "" && len(arches) > 0 {
+						patSvcid = svcurl + "_" + version + "_" + arches[0]
+					}
 					perfutils.ExchangeGet("orgs/"+org+"/services", myagbotauth, []int{404}, nil)
-					perfutils.ExchangeGet("orgs/"+org+"/services/"+svcid, myagbotauth, []int{404}, nil) // not sure why both of these are called, but they are
+					perfutils.ExchangeGet("orgs/"+org+"/services/"+patSvcid, myagbotauth, []int{404}, nil) // not sure why both of these are called, but they are
 
-					// Search for nodes with this pattern
+					// Search for nodes with this pattern. The exchange does not page this search, but we still ask it to, to verify that.
 					url := "orgs/" + org + "/patterns/" + pat + "/search"
-					perfutils.Verbose("Running POST (%s) %s", myagbotauth, url)
-					var nodeResp ExchangePatternSearch
-					httpCode := perfutils.ExchangeP(http.MethodPost, url, myagbotauth, []int{404, 400}, `{ "serviceUrl": "`+org+`/`+svcurl+`", "secondsStale": 0, "startIndex": 0, "numEntries": 0 }`, &nodeResp, true)
-					if httpCode == 201 || httpCode == 404 { // even with 404 we get a valid response structure
-						//perfutils.Debug("pattern search: %v", nodeResp)
-						numNodes := len(nodeResp.Nodes)
+					searchBody := func(startIndex, numEntries int) string {
+						return `{ "serviceUrl": "` + org + `/` + svcurl + `", "secondsStale": ` + strconv.Itoa(staleness.secondsStale) + `, "startIndex": ` + strconv.Itoa(startIndex) + `, "numEntries": ` + strconv.Itoa(numEntries) + ` }`
+					}
+					var ids []string
+					ok := true
+					if pager.enabled() {
+						ids = pager.pass("pattern "+pat+" search",
+							func(page int) []string {
+								pageIds, _ := searchNodes(url, myagbotauth, searchBody(page*pager.pageSize, pager.pageSize))
+								return pageIds
+							},
+							func() []string { allIds, _ := searchNodes(url, myagbotauth, searchBody(0, 0)); return allIds },
+							func(nodeId string) (time.Time, bool) { return nodeLastUpdated(nodeId, myagbotauth) })
+					} else {
+						ids, ok = searchNodes(url, myagbotauth, searchBody(0, 0))
+					}
+					if ok {
+						if staleness.enabled() {
+							staleness.checkSearch(ids, time.Now())
+						}
+						numNodes := len(ids)
 						fmt.Printf("pattern %s search found %d nodes", pat, numNodes) // was Debug()
 						nodesProcessed += numNodes
 						numAgrChkNodes += numNodes
@@ -261,26 +384,88 @@ func main() {
 						nodesMinProcessed = perfutils.MinInt(nodesMinProcessed, numNodes)
 						nodesLastProcessed = numNodes
 						// Loop thru the nodes that are candidates to make agreement with for this pattern
-						for _, n := range nodeResp.Nodes {
-							nid := perfutils.TrimOrg(n.Id) // the node ids are returned to us with the org prepended
-							perfutils.Verbose("Node %s", nid)
-
-							// Simulate agreement negotiation by posting some short-lived msgs to the node
-							// the acceptable 404 http codes below handle the case in which the node was deleted between the time of the search and now
-							perfutils.ExchangeGet("orgs/"+org+"/nodes/"+nid, myagbotauth, []int{404}, nil)
-							for i := 1; i <= 2; i++ {
-								perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/nodes/"+nid+"/msgs", myagbotauth, []int{404}, `{"message": "hey there", "ttl": 5}`, nil, true)
-							}
-							// we query our own msgs below, so don't have to do that here
+						for _, id := range ids {
+							negotiate("pattern "+pat+" search", id, patDetails.arches(org+"/"+svcurl))
 						}
 					}
 				} // end of for patterns
-				//todo: query agbot businesspols orgs and business policies and do a search for each, and query service policy
+
+				// Get the business policies in the org and do a search for each one. With paging, each pass has its own session, which the
+				// exchange uses to keep the agbots that serve the same policy on the same pages.
+				//todo: query agbot businesspols orgs, and query service policy
+				var polResp ExchangeBusinessPolicies
+				if httpCode := perfutils.ExchangeGet("orgs/"+org+"/business/policies", myagbotauth, []int{404}, &polResp); httpCode == 200 {
+					for bp, polDetails := range polResp.BusinessPolicy {
+						pol := perfutils.TrimOrg(bp)
+						url := "orgs/" + org + "/business/policies/" + pol + "/search"
+						var ids []string
+						ok := true
+						if pager.enabled() {
+							session := myagbotid + "-" + strconv.Itoa(h) + "-" + pol
+							ids = pager.pass("business policy "+pol+" search",
+								func(page int) []string {
+									pageIds, _ := searchNodes(url, myagbotauth, `{ "changedSince": 0, "numEntries": `+strconv.Itoa(pager.pageSize)+`, "session": "`+session+`" }`)
+									return pageIds
+								},
+								func() []string { allIds, _ := searchNodes(url, myagbotauth, `{ "changedSince": 0 }`); return allIds },
+								func(nodeId string) (time.Time, bool) { return nodeLastUpdated(nodeId, myagbotauth) })
+						} else {
+							ids, ok = searchNodes(url, myagbotauth, `{ "changedSince": 0 }`)
+						}
+						if ok {
+							fmt.Printf("business policy %s search found %d nodes", pol, len(ids)) // was Debug()
+							nodesProcessed += len(ids)
+							numAgrChkNodes += len(ids)
+							for _, id := range ids {
+								negotiate("business policy "+pol+" search", id, []string{polDetails.Service.Arch})
+							}
+						}
+					}
+
+					// Renegotiate the agreements of the nodes without a pattern that are for another version than their business policy (the
+					// 1 for their arch) deploys now. Their arch is only looked up once.
+					if len(polResp.BusinessPolicy) > 0 {
+						polVersions := map[string]string{}
+						for _, polDetails := range polResp.BusinessPolicy {
+							polVersions[polDetails.Service.Arch] = polDetails.version()
+						}
+						var healthResp perfutils.ExchangeNodes
+						if httpCode := perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/search/nodehealth", myagbotauth, []int{404}, `{ "lastTime": "`+staleness.lastTime(time.Now())+`" }`, &healthResp, true); httpCode == 201 {
+							for id, n := range healthResp.Nodes {
+								if len(n.Agreements) == 0 {
+									continue
+								}
+								arch, ok := renegotiation.nodeArches[id]
+								if !ok {
+									var nodeDetails perfutils.ExchangeNodes
+									if httpCode := perfutils.ExchangeGet("orgs/"+org+"/nodes/"+perfutils.TrimOrg(id), myagbotauth, []int{404}, &nodeDetails); httpCode != 200 {
+										continue
+									}
+									for _, nd := range nodeDetails.Nodes {
+										arch = nd.Arch
+									}
+									renegotiation.nodeArches[id] = arch
+								}
+								renegotiate(id, polVersions[arch])
+							}
+						}
+					}
+				}
 				fmt.Printf("Agbot %d processed %d nodes\n", a, numAgrChkNodes)
+				if staleness.enabled() {
+					staleness.endCheck(time.Now())
+				}
 			} // end of 200 or 404 from GET patterns
 
-			// Get my agbot msgs
-			perfutils.ExchangeGet("orgs/"+org+"/agbots/"+myagbotid+"/msgs", myagbotauth, nil, nil)
+			// Get my agbot msgs, and remove the replies of the nodes to our proposals from them
+			var msgResp perfutils.ExchangeMsgs
+			perfutils.ExchangeGet("orgs/"+org+"/agbots/"+myagbotid+"/msgs", myagbotauth, nil, &msgResp)
+			for _, m := range msgResp.Messages {
+				if kind, version, ok := perfutils.ParseAgreementMsg(m.Message); ok && kind != perfutils.MSG_PROPOSAL {
+					renegotiation.replied(myagbotid, m.NodeId, kind, version)
+					perfutils.ExchangeDelete("orgs/"+org+"/agbots/"+myagbotid+"/msgs/"+strconv.Itoa(m.MsgId), myagbotauth, []int{404})
+				}
+			}
 
 			// If it is time to heartbeat, do that
 			if agbotHbCount >= agbotHbInterval {
@@ -307,12 +492,12 @@ func main() {
 		iterTime := time.Since(startIteration)
 		iterDelta := perfutils.Seconds2Duration(newAgreementInterval) - iterTime
 		iterDeltaTotal += iterDelta
-		if !shortCircuit && h >= shortCircuitChkInterval && emptyIntervals >= requiredEmptyIntervals {
-			// stop sleeping if we have done more than 10 intervals and the last 3 intervals have had 0 patterns
-			fmt.Printf("Found no patterns for %d agbot agreement checks, not sleeping for the rest of the run\n", emptyIntervals)
-			shortCircuit = true
+		numChecksDone = h
+		if nodeTracker != nil && nodeTracker.AllDone() {
+			fmt.Printf("All of the node driver instances are done, ending the agbot test after %d of %d agreement checks\n", h, numAgrChecks)
+			break
 		}
-		if iterDelta > 0 && os.Getenv("EX_AGBOT_NO_SLEEP") == "" && !shortCircuit {
+		if iterDelta > 0 && !perfutils.ConfigBool("EX_AGBOT_NO_SLEEP") {
 			fmt.Printf("Sleeping for %f seconds at the end of agbot agreement check %d of %d because loop iteration finished early\n", iterDelta.Seconds(), h, numAgrChecks)
 			sleepTotal += iterDelta
 			time.Sleep(iterDelta)
@@ -321,6 +506,7 @@ func main() {
 
 	// =========== Clean up ===========================================
 
+	perfutils.SetPhase("cleanup")
 	fmt.Println("\nCleaning up from agbot test:")
 
 	// Don't need to delete the msgs, they'll get deleted with the agbot
@@ -336,7 +522,7 @@ func main() {
 		perfutils.ExchangeDelete("orgs/"+org+"/patterns/"+patternid, userauth, nil)
 	}
 
-	if os.Getenv("EX_AGBOT_CREATE_SERVICE") != "" {
+	if perfutils.ConfigBool("EX_AGBOT_CREATE_SERVICE") {
 		perfutils.ExchangeDelete("orgs/"+org+"/services/"+svcid, userauth, []int{404})
 	}
 
@@ -344,18 +530,33 @@ func main() {
 	perfutils.ExchangeDelete("orgs/"+org+"/nodes/"+nodeid, userauth, nil)
 
 	// Can not delete the org in case other instances of this script are still using it. Whoever calls this script must delete it
+	manifest.Remove() // we cleaned up everything we created, so there is nothing to resume or tear down
 
 	// Note: need to do all of the time calculations in Durations (int64 nanaseconds), and only convert to float64 seconds to display
-	iterDeltaAvg := iterDeltaTotal / time.Duration(numAgrChecks)
-	nodesProcAvg := float64(nodesProcessed) / float64(numAgrChecks)
+	iterDeltaAvg := iterDeltaTotal / time.Duration(numChecksDone)
+	nodesProcAvg := float64(nodesProcessed) / float64(numChecksDone)
 	t2 := time.Now()
 	tDelta := t2.Sub(t1) // this is a Duration
 	activeTime := tDelta - sleepTotal
 	activeTimeSecs := activeTime.Seconds() // this is float64
-	opsAvg := activeTimeSecs / float64(perfutils.TotalOps)
+	opsAvg := activeTimeSecs / float64(perfutils.GetTotalOps())
 
 	sumMsg := fmt.Sprintf("Simulated %d agbots for %d agreement-checks\nMax patterns=%d, total nodes=%d, avg=%f nodes/agr-chk\nMax nodes=%d, min nodes=%d, last nodes=%d\nStart time: %s, End time: %s, wall clock duration=%f s\nOverall: active time: %f s, num ops=%d, avg=%f s/op, avg iteration delta=%f s",
-		numAgbots, numAgrChecks, patsMaxProcessed, nodesProcessed, nodesProcAvg, nodesMaxProcessed, nodesMinProcessed, nodesLastProcessed, t1.Format("2006.01.02 15:04:05"), t2.Format("2006.01.02 15:04:05"), tDelta.Seconds(), activeTimeSecs, perfutils.TotalOps, opsAvg, iterDeltaAvg.Seconds())
+		numAgbots, numChecksDone, patsMaxProcessed, nodesProcessed, nodesProcAvg, nodesMaxProcessed, nodesMinProcessed, nodesLastProcessed, t1.Format("2006.01.02 15:04:05"), t2.Format("2006.01.02 15:04:05"), tDelta.Seconds(), activeTimeSecs, perfutils.GetTotalOps(), opsAvg, iterDeltaAvg.Seconds())
+	if suspendedSkipped > 0 {
+		sumMsg += fmt.Sprintf("\nConfig state: skipped %d candidates because their service was suspended (%d distinct nodes)", suspendedSkipped, len(suspendedNodes))
+	}
+	if staleness.enabled() {
+		sumMsg += "\n" + staleness.String()
+	}
+	if pager.enabled() {
+		sumMsg += "\n" + pager.String()
+	}
+	sumMsg += "\n" + fleet.String()
+	if renegotiation.proposals > 0 {
+		sumMsg += "\n" + renegotiation.String()
+	}
+	sumMsg += "\n" + perfutils.GetConnStats().String() + "\n" + perfutils.RouteReport()
 
 	perfutils.Append2File(perfutils.EX_PERF_REPORT_FILE, sumMsg+"\n")
 	fmt.Println("\n" + sumMsg)
@@ -0,0 +1,110 @@
+package agbot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+// The versions of a service in a pattern or business policy
+type serviceVersions []struct {
+	Version string `json:"version"`
+}
+
+// A pattern, with the fields the agbot needs to know which arches and version of the service it can deploy
+type ExchangePattern struct {
+	Services []struct {
+		ServiceUrl      string          `json:"serviceUrl"`
+		ServiceOrgid    string          `json:"serviceOrgid"`
+		ServiceArch     string          `json:"serviceArch"`
+		ServiceVersions serviceVersions `json:"serviceVersions"`
+	} `json:"services"`
+}
+
+// arches returns the arches of the service (org/url) in the pattern
+func (p ExchangePattern) arches(svcUrl string) []string {
+	var arches []string
+	for _, s := range p.Services {
+		if s.ServiceOrgid+"/"+s.ServiceUrl == svcUrl {
+			arches = append(arches, s.ServiceArch)
+		}
+	}
+	return arches
+}
+
+// version returns the version of the service (org/url) the pattern deploys, or "" if it does not have the service
+func (p ExchangePattern) version(svcUrl string) string {
+	for _, s := range p.Services {
+		if s.ServiceOrgid+"/"+s.ServiceUrl == svcUrl && len(s.ServiceVersions) > 0 {
+			return s.ServiceVersions[0].Version
+		}
+	}
+	return ""
+}
+
+// A business policy, with the fields the agbot needs to know which arch and version of the service it can deploy
+type ExchangeBusinessPolicy struct {
+	Service struct {
+		Name            string          `json:"name"`
+		Org             string          `json:"org"`
+		Arch            string          `json:"arch"`
+		ServiceVersions serviceVersions `json:"serviceVersions"`
+	} `json:"service"`
+}
+
+// version returns the version of its service the business policy deploys
+func (p ExchangeBusinessPolicy) version() string {
+	if len(p.Service.ServiceVersions) == 0 {
+		return ""
+	}
+	return p.Service.ServiceVersions[0].Version
+}
+
+// fleetChecker verifies that the searches only return nodes whose arch the pattern or business policy has the service for, and counts
+// the candidate nodes by arch and node type, to show the composition of the fleet the searches saw
+type fleetChecker struct {
+	byArch       map[string]int
+	byType       map[string]int
+	incompatible int // candidates whose arch the pattern or business policy does not have the service for
+}
+
+func newFleetChecker() *fleetChecker {
+	return &fleetChecker{byArch: map[string]int{}, byType: map[string]int{}}
+}
+
+// check records a candidate node the search returned, and returns false if the service can not be deployed to its arch. An empty arch or "*"
+// means any arch.
+func (f *fleetChecker) check(search, nodeId string, node perfutils.ExchangeNode, arches []string) bool {
+	f.byArch[node.Arch]++
+	nodeType := node.NodeType
+	if nodeType == "" {
+		nodeType = perfutils.NODE_TYPE_DEVICE // what the exchange defaults it to
+	}
+	f.byType[nodeType]++
+	for _, arch := range arches {
+		if arch == "" || arch == "*" || arch == node.Arch {
+			return true
+		}
+	}
+	f.incompatible++
+	perfutils.Error("fleet: %s returned node %s with arch %s, but the service is only for %s", search, nodeId, node.Arch, strings.Join(arches, ", "))
+	return false
+}
+
+func (f *fleetChecker) String() string {
+	counts := func(m map[string]int) string {
+		var keys []string
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var strs []string
+		for _, k := range keys {
+			strs = append(strs, fmt.Sprintf("%s=%d", k, m[k]))
+		}
+		return strings.Join(strs, ", ")
+	}
+	return fmt.Sprintf("Fleet: candidates by arch: %s; by node type: %s; incompatible arch=%d", counts(f.byArch), counts(f.byType), f.incompatible)
+}
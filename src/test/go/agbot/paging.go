@@ -0,0 +1,123 @@
+package agbot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+// The agbot and exchange clocks can differ by this much when deciding if a node changed during a pass through the pages
+const pagingClockTolerance = time.Second
+
+// searchPager pages through the results of a search (numEntries at a time), and verifies the pages do not overlap or skip nodes, even
+// while the nodes churn. A node may be returned again at the start of the next page (the exchange resumes from the lastUpdated of the
+// last node of the previous page, inclusive), or if it changed during the pass, but not otherwise.
+type searchPager struct {
+	pageSize int
+
+	passes          int
+	pages           int
+	maxPages        int
+	boundaryRepeats int // nodes returned again at the start of the next page
+	changedRepeats  int // nodes returned again because they changed during the pass
+	overlaps        int // nodes returned again for no reason
+	skips           int // nodes the unpaged search returned, that none of the pages did
+	changedSkips    int // nodes that were not in the pages because they changed after the last one
+	stuck           int // passes in which a full page had no new nodes, so the exchange was not going to get past them
+	unpaged         int // passes in which the exchange returned more than numEntries, i.e. ignored it
+	passTimes       []time.Duration
+}
+
+func newSearchPager() *searchPager {
+	return &searchPager{pageSize: perfutils.ConfigInt("EX_AGBOT_SEARCH_PAGE_SIZE")}
+}
+
+func (p *searchPager) enabled() bool {
+	return p.pageSize > 0
+}
+
+// pass pages through 1 search and returns the nodes it found. fetchPage gets the next page (numbered from 0), fetchAll gets all of the
+// results without paging (to find the skipped nodes), and lastUpdated gets when a node last changed (false if it no longer exists).
+func (p *searchPager) pass(search string, fetchPage func(page int) []string, fetchAll func() []string, lastUpdated func(nodeId string) (time.Time, bool)) []string {
+	p.passes++
+	start := time.Now()
+	changedSince := func(nodeId string, t time.Time) bool {
+		updated, ok := lastUpdated(nodeId)
+		return ok && !updated.Before(t.Add(-pagingClockTolerance))
+	}
+
+	var nodes []string
+	seen := map[string]int{} // the page each node was 1st returned in
+	var prevPage map[string]bool
+	var lastPageStart time.Time
+	ignored := false
+	page := 0
+	for ; ; page++ {
+		lastPageStart = time.Now()
+		ids := fetchPage(page)
+		p.pages++
+		if len(ids) > p.pageSize {
+			ignored = true
+			p.unpaged++
+			perfutils.Verbose("paging: %s returned %d nodes for numEntries %d", search, len(ids), p.pageSize)
+			for _, id := range ids {
+				if _, ok := seen[id]; !ok {
+					seen[id] = page
+					nodes = append(nodes, id)
+				}
+			}
+			break
+		}
+		newNodes := 0
+		thisPage := map[string]bool{}
+		for _, id := range ids {
+			thisPage[id] = true
+			firstPage, ok := seen[id]
+			if !ok {
+				seen[id] = page
+				nodes = append(nodes, id)
+				newNodes++
+			} else if prevPage[id] {
+				p.boundaryRepeats++
+			} else if changedSince(id, start) {
+				p.changedRepeats++
+			} else {
+				p.overlaps++
+				perfutils.Error("paging: %s returned node %s in page %d, after it was already returned in page %d", search, id, page, firstPage)
+			}
+		}
+		prevPage = thisPage
+		if len(ids) < p.pageSize {
+			break // exhausted
+		}
+		if newNodes == 0 {
+			p.stuck++
+			perfutils.Error("paging: page %d of %s had no new nodes, so paging would never get past them (more than %d nodes with the same lastUpdated?)", page, search, p.pageSize)
+			break
+		}
+	}
+	p.maxPages = perfutils.MaxInt(p.maxPages, page+1)
+	p.passTimes = append(p.passTimes, time.Since(start))
+
+	if ignored {
+		return nodes // the last page had all of the rest of the results, so there is nothing to compare
+	}
+	for _, id := range fetchAll() {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		if changedSince(id, lastPageStart) {
+			p.changedSkips++
+			continue
+		}
+		p.skips++
+		perfutils.Error("paging: %s skipped node %s: none of the %d pages returned it, but the unpaged search did", search, id, page+1)
+	}
+	return nodes
+}
+
+func (p *searchPager) String() string {
+	return fmt.Sprintf("Search paging: numEntries=%d, passes=%d, pages=%d (max %d per pass), ignored numEntries=%d, overlaps=%d, skips=%d, stuck=%d, repeated at page boundaries=%d, repeated or missed because they changed=%d\nSearch paging per pass: %s",
+		p.pageSize, p.passes, p.pages, p.maxPages, p.unpaged, p.overlaps, p.skips, p.stuck, p.boundaryRepeats, p.changedRepeats+p.changedSkips, perfutils.ComputeLatencyStats(p.passTimes))
+}
@@ -0,0 +1,78 @@
+package agbot
+
+import (
+	"testing"
+	"time"
+)
+
+// The counts of a searchPager that a pass changes
+type pagerCounts struct {
+	pages, boundaryRepeats, changedRepeats, overlaps, skips, changedSkips, stuck, unpaged int
+}
+
+func countsOf(p *searchPager) pagerCounts {
+	return pagerCounts{p.pages, p.boundaryRepeats, p.changedRepeats, p.overlaps, p.skips, p.changedSkips, p.stuck, p.unpaged}
+}
+
+func TestSearchPagerPass(t *testing.T) {
+	tests := []struct {
+		name    string
+		pages   [][]string // what the exchange returns for each page (and an empty page after the last one)
+		all     []string   // what the unpaged search returns
+		changed []string   // the nodes that changed during the pass
+		want    searchPager
+		wantIds int
+	}{
+		{name: "exact page boundary", pages: [][]string{{"a", "b", "c"}, {"d", "e", "f"}, {}}, all: []string{"a", "b", "c", "d", "e", "f"},
+			want: searchPager{pages: 3}, wantIds: 6},
+		{name: "repeat at page boundary", pages: [][]string{{"a", "b", "c"}, {"c", "d", "e"}, {"e", "f"}}, all: []string{"a", "b", "c", "d", "e", "f"},
+			want: searchPager{pages: 3, boundaryRepeats: 2}, wantIds: 6},
+		{name: "changed node", pages: [][]string{{"a", "b", "c"}, {"d", "e", "f"}, {"a", "g"}}, all: []string{"a", "b", "c", "d", "e", "f", "g"}, changed: []string{"a"},
+			want: searchPager{pages: 3, changedRepeats: 1}, wantIds: 7},
+		{name: "overlap", pages: [][]string{{"a", "b", "c"}, {"d", "e", "f"}, {"a", "g"}}, all: []string{"a", "b", "c", "d", "e", "f", "g"},
+			want: searchPager{pages: 3, overlaps: 1}, wantIds: 7},
+		{name: "skipped node", pages: [][]string{{"a", "b", "c"}, {"d"}}, all: []string{"a", "b", "c", "d", "e"},
+			want: searchPager{pages: 2, skips: 1}, wantIds: 4},
+		{name: "node changed after the last page", pages: [][]string{{"a", "b", "c"}, {"d"}}, all: []string{"a", "b", "c", "d", "e"}, changed: []string{"e"},
+			want: searchPager{pages: 2, changedSkips: 1}, wantIds: 4},
+		{name: "stuck", pages: [][]string{{"a", "b", "c"}, {"a", "b", "c"}}, all: []string{"a", "b", "c", "d"},
+			want: searchPager{pages: 2, boundaryRepeats: 3, stuck: 1, skips: 1}, wantIds: 3},
+		{name: "ignored numEntries", pages: [][]string{{"a", "b", "c", "d", "e"}}, all: []string{"a", "b", "c", "d", "e"},
+			want: searchPager{pages: 1, unpaged: 1}, wantIds: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &searchPager{pageSize: 3}
+			fetchedAll := false
+			fetchPage := func(page int) []string {
+				if page >= len(tt.pages) {
+					t.Fatalf("fetched page %d, but there are only %d", page, len(tt.pages))
+				}
+				return tt.pages[page]
+			}
+			fetchAll := func() []string { fetchedAll = true; return tt.all }
+			lastUpdated := func(nodeId string) (time.Time, bool) {
+				for _, id := range tt.changed {
+					if id == nodeId {
+						return time.Now(), true
+					}
+				}
+				return time.Now().Add(-time.Hour), true
+			}
+
+			ids := p.pass("test search", fetchPage, fetchAll, lastUpdated)
+			if len(ids) != tt.wantIds {
+				t.Errorf("pass returned %d nodes %v, want %d", len(ids), ids, tt.wantIds)
+			}
+			if got, want := countsOf(p), countsOf(&tt.want); got != want {
+				t.Errorf("pager counts = %+v, want %+v", got, want)
+			}
+			if fetchedAll == (tt.want.unpaged > 0) {
+				t.Errorf("fetched the unpaged search = %v, want %v", fetchedAll, tt.want.unpaged == 0)
+			}
+			if p.passes != 1 || p.maxPages != len(tt.pages) {
+				t.Errorf("passes = %d, max pages = %d, want 1 and %d", p.passes, p.maxPages, len(tt.pages))
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package agbot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+// renegotiator makes the agbots renegotiate the agreements of the nodes when the publisher rolls out a new version of the service (see
+// node/rollout.go). When nodehealth returns a node with an agreement for another version than its pattern or business policy deploys now,
+// the agbot proposes an agreement for that version in a msg to the node, until the node replies that it agreed to it or declined it.
+type renegotiator struct {
+	baseVersion string               // the version the node driver makes the 1st agreements of its nodes for
+	ttl         int                  // seconds a proposal lives. If the node did not reply by then (e.g. it was offline), it is proposed again.
+	versions    map[string]string    // the version each node last replied about to each agbot (key agbot/node), if it is not baseVersion
+	proposedAt  map[string]time.Time // when each agbot last proposed to each node
+	nodeArches  map[string]string    // the arch of each node (with the org prepended), to know which business policy it is for
+
+	proposals, agreed, declined int
+}
+
+func newRenegotiator(baseVersion string, ttl int) *renegotiator {
+	return &renegotiator{baseVersion: baseVersion, ttl: ttl, versions: map[string]string{}, proposedAt: map[string]time.Time{}, nodeArches: map[string]string{}}
+}
+
+func renegotiationKey(agbotId, nodeId string) string {
+	return agbotId + "/" + nodeId
+}
+
+// needsProposal returns true if the agbot should propose an agreement for the version (that the pattern or business policy deploys now) to
+// the node (with the org prepended), because the node's agreement is for another version and there is no live proposal for it
+func (r *renegotiator) needsProposal(agbotId, nodeId, version string) bool {
+	key := renegotiationKey(agbotId, nodeId)
+	current, ok := r.versions[key]
+	if !ok {
+		current = r.baseVersion
+	}
+	if version == "" || version == current {
+		return false
+	}
+	at, ok := r.proposedAt[key]
+	return !ok || time.Since(at) >= perfutils.Seconds2Duration(r.ttl)
+}
+
+// proposed records that the agbot proposed an agreement to the node
+func (r *renegotiator) proposed(agbotId, nodeId string) {
+	r.proposedAt[renegotiationKey(agbotId, nodeId)] = time.Now()
+	r.proposals++
+}
+
+// replied records the reply (MSG_AGREED or MSG_DECLINED) of the node to a proposal of the agbot for the version
+func (r *renegotiator) replied(agbotId, nodeId, kind, version string) {
+	key := renegotiationKey(agbotId, nodeId)
+	r.versions[key] = version
+	delete(r.proposedAt, key)
+	if kind == perfutils.MSG_AGREED {
+		r.agreed++
+	} else {
+		r.declined++
+	}
+}
+
+func (r *renegotiator) String() string {
+	return fmt.Sprintf("Renegotiation: %d agreements proposed for rolled out versions, the nodes agreed to %d and declined %d", r.proposals, r.agreed, r.declined)
+}
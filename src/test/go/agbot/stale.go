@@ -0,0 +1,123 @@
+package agbot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+// stalenessChecker verifies that the nodes that stopped heartbeating drop out of the nodehealth and pattern search results within
+// secondsStale (plus a tolerance for the clocks and the time of the calls), and that no node older than that is returned
+type stalenessChecker struct {
+	secondsStale int
+	tolerance    time.Duration
+	stalePct     int // the node driver stops the heartbeats of this percent of its nodes, picked the same way (PICK_STALE_NODES)
+
+	lastSeen    map[string]time.Time // the newest lastHeartbeat nodehealth returned for each node
+	inHealth    map[string]bool      // the nodes nodehealth returned in the previous agreement check of any of the agbots (they all serve the same patterns)
+	nowInHealth map[string]bool      // the nodes nodehealth returned in the current agreement check of an agbot
+	dropped     map[string]bool      // the stale nodes that dropped out of nodehealth (their lag is recorded once)
+	dropLags    []time.Duration      // how long after their lastHeartbeat + secondsStale the stale nodes dropped out of nodehealth
+
+	healthChecked  int // nodes nodehealth returned
+	searchChecked  int // nodes the pattern search returned
+	healthTooOld   int // nodes nodehealth returned whose lastHeartbeat is older than lastTime
+	searchTooOld   int // stale nodes the pattern search returned after they should have dropped out
+	unparsableTime int
+}
+
+func newStalenessChecker() *stalenessChecker {
+	return &stalenessChecker{
+		secondsStale: perfutils.ConfigInt("EX_AGBOT_SECONDS_STALE"),
+		tolerance:    perfutils.Seconds2Duration(perfutils.ConfigInt("EX_AGBOT_STALE_TOLERANCE_S")),
+		stalePct:     perfutils.ConfigInt("EX_PERF_STALE_PCT"),
+		lastSeen:     map[string]time.Time{},
+		inHealth:     map[string]bool{},
+		nowInHealth:  map[string]bool{},
+		dropped:      map[string]bool{},
+	}
+}
+
+func (s *stalenessChecker) enabled() bool {
+	return s.secondsStale > 0
+}
+
+func (s *stalenessChecker) staleAfter() time.Duration {
+	return perfutils.Seconds2Duration(s.secondsStale)
+}
+
+// isStaleNode returns true if the node driver stops the heartbeats of this node
+func (s *stalenessChecker) isStaleNode(nodeId string) bool {
+	n, ok := perfutils.NodeNumber(nodeId)
+	return ok && perfutils.SpreadEvenly(perfutils.PICK_STALE_NODES, n, s.stalePct)
+}
+
+// lastTime returns the lastTime for nodehealth, so it only returns the nodes that heartbeated in the last secondsStale seconds
+func (s *stalenessChecker) lastTime(now time.Time) string {
+	if !s.enabled() {
+		return "" // all nodes
+	}
+	return perfutils.FormatExchangeTime(now.Add(-s.staleAfter()))
+}
+
+// checkHealth checks the nodes nodehealth returned for lastTime, and remembers when each of them last heartbeated
+func (s *stalenessChecker) checkHealth(resp perfutils.ExchangeNodes, lastTime string) {
+	cutoff, _ := perfutils.ParseExchangeTime(lastTime)
+	for id, n := range resp.Nodes {
+		s.healthChecked++
+		s.nowInHealth[id] = true
+		hb, err := perfutils.ParseExchangeTime(n.LastHeartbeat)
+		if err != nil {
+			s.unparsableTime++
+			perfutils.Error("staleness: node %s has lastHeartbeat %s that can not be parsed: %v", id, n.LastHeartbeat, err)
+			continue
+		}
+		if hb.After(s.lastSeen[id]) {
+			s.lastSeen[id] = hb
+		}
+		if hb.Before(cutoff.Add(-s.tolerance)) {
+			s.healthTooOld++
+			perfutils.Error("staleness: nodehealth returned node %s with lastHeartbeat %s, older than lastTime %s", id, n.LastHeartbeat, lastTime)
+		}
+	}
+}
+
+// checkSearch checks that the pattern search with secondsStale did not return a stale node that should have dropped out of it
+func (s *stalenessChecker) checkSearch(nodeIds []string, now time.Time) {
+	for _, id := range nodeIds {
+		s.searchChecked++
+		hb, ok := s.lastSeen[id]
+		if !ok || !s.isStaleNode(id) {
+			continue // only the stale nodes are sure to not heartbeat between our nodehealth and the search
+		}
+		if late := now.Sub(hb.Add(s.staleAfter())); late > s.tolerance {
+			s.searchTooOld++
+			perfutils.Error("staleness: pattern search with secondsStale %d returned stale node %s %.3f s after it should have dropped out", s.secondsStale, id, late.Seconds())
+		}
+	}
+}
+
+// endCheck is called at the end of each agreement check of an agbot, to find the stale nodes that dropped out of nodehealth during it
+func (s *stalenessChecker) endCheck(now time.Time) {
+	for id := range s.inHealth {
+		if s.nowInHealth[id] || s.dropped[id] || !s.isStaleNode(id) {
+			continue
+		}
+		lag := now.Sub(s.lastSeen[id].Add(s.staleAfter()))
+		if lag < -s.tolerance {
+			continue // it dropped out before it was stale, so it was probably deleted
+		}
+		if lag < 0 {
+			lag = 0 // within the tolerance
+		}
+		s.dropped[id] = true
+		s.dropLags = append(s.dropLags, lag)
+	}
+	s.inHealth, s.nowInHealth = s.nowInHealth, map[string]bool{}
+}
+
+func (s *stalenessChecker) String() string {
+	return fmt.Sprintf("Staleness: secondsStale=%d, nodehealth returned %d nodes (%d older than lastTime), pattern search returned %d nodes (%d stale nodes that should have dropped out), unparsable lastHeartbeats=%d\nStale nodes dropped out of nodehealth (%d) after lastHeartbeat + secondsStale: %s",
+		s.secondsStale, s.healthChecked, s.healthTooOld, s.searchChecked, s.searchTooOld, s.unparsableTime, len(s.dropLags), perfutils.ComputeLatencyStats(s.dropLags))
+}
@@ -0,0 +1,68 @@
+// The catalog command of exchperf: creates (or deletes) a synthetic catalog of services and patterns with production-like cardinality
+// (see perfutils/catalog.go), e.g. before running the node, agbot, or small drivers, so the service and pattern apis have a lot to go thru
+package catalog
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+func Usage(prog string, flags *flag.FlagSet) {
+	fmt.Printf(`Usage: %s [flags] <name base>
+
+Creates a synthetic catalog of services and patterns in org EX_PERF_ORG, shaped by the EX_PERF_CATALOG_* settings, with ids that start
+with <name base>-cat. The services that already exist are left as is, so it can be rerun to add to a catalog (or finish 1 that failed).
+The catalog stays after the command ends: delete it with --delete (using the same settings), or with "exchperf cleanup <name base>-cat".
+`, prog)
+	perfutils.PrintSettingsUsage(perfutils.DRIVER_CATALOG, flags)
+}
+
+// Main runs the catalog command with the command line args. prog is how it was invoked (e.g. "exchperf catalog"), for the usage.
+func Main(prog string, args []string) {
+	flags := flag.NewFlagSet(prog, flag.ExitOnError)
+	flags.Usage = func() { Usage(prog, flags) }
+	var deleteCatalog, printCatalog bool
+	flags.BoolVar(&deleteCatalog, "delete", false, "delete the catalog instead of creating it")
+	flags.BoolVar(&printCatalog, "print", false, "only print the services and patterns of the catalog as json")
+	args = perfutils.ParseConfig(perfutils.DRIVER_CATALOG, flags, args)
+	if len(args) != 1 {
+		Usage(prog, flags)
+		os.Exit(1)
+	}
+	if perfutils.ConfigInt("EX_PERF_CATALOG_SVCS") == 0 {
+		perfutils.Fatal(perfutils.CLI_INPUT_ERROR, "EX_PERF_CATALOG_SVCS must be set to the number of service urls to generate")
+	}
+
+	namebase := args[0] + "-cat"
+	org := perfutils.ConfigString("EX_PERF_ORG")
+	catalog := perfutils.GenerateCatalog(perfutils.CatalogSpecFromConfig(), org, namebase)
+	if printCatalog {
+		fmt.Println(perfutils.MarshalIndent(map[string]interface{}{"services": catalog.Services, "patterns": catalog.Patterns}, "catalog"))
+		return
+	}
+
+	rootauth := perfutils.GetRootCredentials()
+	userauth := perfutils.GetUserCredentials(org)
+	fmt.Printf("Catalog %s in org %s of exchange %s: %s\n", namebase, org, perfutils.GetExchangeUrl(), catalog)
+
+	t1 := time.Now()
+	manifest := perfutils.LoadSetupManifest(namebase, org)
+	if deleteCatalog {
+		catalog.Delete(userauth, []int{404})
+		manifest.Remove()
+		fmt.Printf("Deleted the catalog in %.1f s\n", time.Since(t1).Seconds())
+	} else {
+		manifest.EnsurePerfOrgAndUser(org, rootauth, userauth)
+		catalog.Ensure(manifest, userauth, false)
+		manifest.MarkSetupDone() // the manifest is kept, so "cleanup --manifest" can tear the catalog down too
+		fmt.Printf("Created the catalog in %.1f s (%d api calls)\n", time.Since(t1).Seconds(), perfutils.GetTotalOps())
+	}
+	if perfutils.GetErrorCount() > 0 {
+		fmt.Printf("%d errors occurred\n", perfutils.GetErrorCount())
+		os.Exit(perfutils.HTTP_ERROR)
+	}
+}
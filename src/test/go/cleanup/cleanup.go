@@ -0,0 +1,221 @@
+// The cleanup command of exchperf: cleans up the exchange resources left behind by the perf/scale drivers. The drivers can not delete the org
+// or user, because other instances might still be using them, so run this after all of the instances are done (or after a crashed run).
+package cleanup
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+func Usage(prog string, flags *flag.FlagSet) {
+	fmt.Printf(`Usage: %s [flags] [<name-prefix>]
+       %s [flags] --manifest <manifest-file>
+
+Deletes the nodes, agbots, business policies, patterns, services, and users in org $EX_PERF_ORG whose id starts with name-prefix
+(or all of them if name-prefix is not specified). The msgs and agreements of the nodes and agbots are deleted along with them.
+With --dry-run, it only lists what would be deleted.
+
+With --manifest, it tears down 1 driver instance of a run that did not finish: it deletes the resources whose id starts with the
+instance's namebase, and the resources the manifest says the instance created (except the ones shared with other instances).
+The manifests are in $EX_PERF_MANIFEST_DIR (default: /tmp/exchangePerfManifests).
+`, prog, prog)
+	perfutils.PrintSettingsUsage(perfutils.DRIVER_CLEANUP, flags)
+}
+
+// resourceType describes how to list and delete 1 kind of exchange resource in the org
+type resourceType struct {
+	name     string // used in the report
+	path     string // relative to orgs/{orgid}
+	listKey  string // the top level key in the GET response
+	children []resourceType
+}
+
+// The order matters: delete the nodes and agbots before the patterns, policies, and services they use
+var resourceTypes = []resourceType{
+	{name: "nodes", path: "nodes", listKey: "nodes", children: []resourceType{
+		{name: "node msgs", path: "msgs", listKey: "messages"},
+		{name: "node agreements", path: "agreements", listKey: "agreements"},
+	}},
+	{name: "agbots", path: "agbots", listKey: "agbots", children: []resourceType{
+		{name: "agbot msgs", path: "msgs", listKey: "messages"},
+		{name: "agbot agreements", path: "agreements", listKey: "agreements"},
+	}},
+	{name: "business policies", path: "business/policies", listKey: "businessPolicy"},
+	{name: "patterns", path: "patterns", listKey: "patterns"},
+	{name: "services", path: "services", listKey: "services"},
+	{name: "users", path: "users", listKey: "users"},
+}
+
+// Main runs the cleanup with the command line args. prog is how it was invoked (e.g. "exchperf cleanup"), for the usage.
+func Main(prog string, args []string) {
+	flags := flag.NewFlagSet(prog, flag.ExitOnError)
+	flags.Usage = func() { Usage(prog, flags) }
+	var dryRun bool
+	flags.BoolVar(&dryRun, "dry-run", false, "only list what would be deleted")
+	manifestFile := flags.String("manifest", "", "tear down the driver instance of this manifest file")
+	args = perfutils.ParseConfig(perfutils.DRIVER_CLEANUP, flags, args)
+	if len(args) > 1 || (len(args) == 1 && *manifestFile != "") {
+		Usage(prog, flags)
+		os.Exit(1)
+	}
+	prefix := ""
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+
+	rootauth := perfutils.GetRootCredentials()
+	HZN_EXCHANGE_URL := perfutils.GetExchangeUrl()
+	org := perfutils.ConfigString("EX_PERF_ORG")
+	concurrency := perfutils.ConfigInt("EX_PERF_CLEANUP_CONCURRENCY")
+
+	reportDir := perfutils.ConfigString("EX_PERF_REPORT_DIR")
+	perfutils.MakeDir(reportDir)
+	perfutils.EX_PERF_REPORT_FILE = reportDir + "/" + perfutils.GetDriverName() + ".summary"
+	perfutils.RemoveFile(perfutils.EX_PERF_REPORT_FILE)
+
+	var manifest *perfutils.SetupManifest
+	if *manifestFile != "" {
+		manifest = perfutils.ReadSetupManifest(*manifestFile, true)
+		org = manifest.Org
+		prefix = manifest.Namebase
+	}
+
+	fmt.Printf("Cleaning up resources in org %s starting with '%s' in exchange %s\n", org, prefix, HZN_EXCHANGE_URL)
+	perfutils.GetHTTPClient() // create the global client before the goroutines use it
+
+	var report []string
+	for _, rt := range resourceTypes {
+		ids := listIds("orgs/"+org+"/"+rt.path, rt.listKey, rootauth, prefix)
+		if len(ids) == 0 {
+			continue
+		}
+
+		// List the children just so we can report them, they are deleted with their parent
+		var childReports []string
+		for _, child := range rt.children {
+			numChildren := 0
+			for _, id := range ids {
+				numChildren += len(listIds("orgs/"+org+"/"+rt.path+"/"+id+"/"+child.path, child.listKey, rootauth, ""))
+			}
+			childReports = append(childReports, fmt.Sprintf("%d %s", numChildren, child.name))
+		}
+
+		verb := "Deleted"
+		if dryRun {
+			verb = "Would delete"
+			for _, id := range ids {
+				fmt.Printf("  %s/%s\n", rt.path, id)
+			}
+		} else {
+			deleteAll("orgs/"+org+"/"+rt.path, ids, rootauth, concurrency)
+		}
+		msg := fmt.Sprintf("%s %d %s", verb, len(ids), rt.name)
+		if len(childReports) > 0 {
+			msg += " (with " + strings.Join(childReports, ", ") + ")"
+		}
+		fmt.Println(msg)
+		report = append(report, msg)
+	}
+
+	if manifest != nil {
+		verb := "Deleted"
+		if dryRun {
+			verb = "Would delete"
+		}
+		msg := fmt.Sprintf("%s %d other resources created by %s %s", verb, teardownManifest(manifest, rootauth, dryRun), manifest.Driver, manifest.Namebase)
+		fmt.Println(msg)
+		report = append(report, msg)
+		if !dryRun && perfutils.GetErrorCount() == 0 {
+			manifest.Remove()
+		}
+	}
+
+	if len(report) == 0 {
+		report = append(report, "Found nothing to clean up")
+		fmt.Println(report[0])
+	}
+	perfutils.Append2File(perfutils.EX_PERF_REPORT_FILE, strings.Join(report, "\n")+"\n")
+	if perfutils.GetErrorCount() > 0 {
+		fmt.Printf("%d errors occurred, see %s\n", perfutils.GetErrorCount(), perfutils.EX_PERF_REPORT_FILE)
+		os.Exit(perfutils.HTTP_ERROR)
+	}
+}
+
+// listIds returns the sorted ids (without the org) of the resources at urlSuffix whose id starts with prefix.
+// The exchange returns most lists as a map whose keys are the ids, but msgs are returned as an array of objects with a msgId.
+func listIds(urlSuffix, listKey string, auth perfutils.Credentials, prefix string) []string {
+	var resp map[string]json.RawMessage
+	httpCode := perfutils.ExchangeGet(urlSuffix, auth, []int{404}, &resp)
+	if httpCode != 200 || resp[listKey] == nil {
+		return nil
+	}
+
+	var ids []string
+	var resources map[string]json.RawMessage
+	if err := json.Unmarshal(resp[listKey], &resources); err == nil {
+		for id := range resources {
+			ids = append(ids, perfutils.TrimOrg(id))
+		}
+	} else {
+		var msgs []struct {
+			MsgId int `json:"msgId"`
+		}
+		perfutils.Unmarshal(resp[listKey], &msgs, "GET "+urlSuffix)
+		for _, m := range msgs {
+			ids = append(ids, fmt.Sprintf("%d", m.MsgId))
+		}
+	}
+
+	var matching []string
+	for _, id := range ids {
+		if strings.HasPrefix(id, prefix) {
+			matching = append(matching, id)
+		}
+	}
+	sort.Strings(matching)
+	return matching
+}
+
+// deleteAll deletes urlSuffix/id for every id, running at most concurrency deletes at the same time
+func deleteAll(urlSuffix string, ids []string, auth perfutils.Credentials, concurrency int) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+	for _, id := range ids {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(id string) {
+			defer wg.Done()
+			perfutils.ExchangeDelete(urlSuffix+"/"+id, auth, []int{404}) // 404 means someone else already deleted it
+			<-slots
+		}(id)
+	}
+	wg.Wait()
+}
+
+// teardownManifest deletes the resources that the manifest says the driver instance created, in the reverse order they were set up.
+// Shared resources are left alone, because other instances may still be using them, and the ones whose id starts with the namebase
+// were already deleted above.
+func teardownManifest(m *perfutils.SetupManifest, auth perfutils.Credentials, dryRun bool) int {
+	numDeleted := 0
+	for i := len(m.Resources) - 1; i >= 0; i-- {
+		r := m.Resources[i]
+		if !r.Created || r.Shared || strings.HasPrefix(path.Base(r.Path), m.Namebase) {
+			continue
+		}
+		if dryRun {
+			fmt.Printf("  %s\n", r.Path)
+		} else {
+			perfutils.ExchangeDelete(r.Path, auth, []int{404})
+		}
+		numDeleted++
+	}
+	return numDeleted
+}
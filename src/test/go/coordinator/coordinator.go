@@ -0,0 +1,296 @@
+// Coordinates a distributed scale test of the exchange. Each scale node runs instances of node.go and/or agbot.go in worker mode
+// (e.g. "node --worker :8510"), and this connects to all of them, assigns each a namebase and scenario, starts them all at the same time,
+// shows their live metrics, and collects their summaries. This replaces the pssh-based scaledriver.sh.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+func Usage(exitCode int) {
+	fmt.Printf(`Usage: %s <plan-file>
+
+Connects to the workers listed in the plan file, assigns each one a namebase and scenario, starts them all at the same time,
+shows their combined metrics while they run, and writes each worker's summary to $EX_PERF_REPORT_DIR/<host>/<driver>/.
+
+The plan file is json, for example:
+{
+  "namebase": "perf1",
+  "startDelayS": 5,
+  "runDelayS": 60,
+  "metricsIntervalS": 10,
+  "env": { "EX_PERF_NUM_HEARTBEATS": "5" },
+  "scenarios": {
+    "nodes": { "EX_PERF_NUM_NODES": "50" },
+    "agbots": { "EX_PERF_NUM_AGBOTS": "1", "EX_AGBOT_NUM_NODE_INSTANCES": "2" }
+  },
+  "workers": [
+    { "address": "localhost:8510", "scenario": "nodes" },
+    { "address": "localhost:8511", "scenario": "nodes" },
+    { "address": "localhost:8520", "scenario": "agbots" }
+  ]
+}
+
+The env settings are applied to every worker, and then the settings of the worker's scenario. A worker's namebase defaults to
+<namebase>-<n> and its hostname (used to share services and patterns between instances) defaults to <namebase>-<host>.
+If runDelayS is set, all of the workers will start their timed phase that many seconds after they start their setup,
+so their results are comparable. It must be long enough for the slowest worker to finish its setup.
+Set EX_AGBOT_NUM_NODE_INSTANCES for the agbots to the number of node workers, so they wait for all of them.
+Each worker must already be running, e.g.: node --worker :8510
+If you want to start from a clean org, run deleteperforg.sh or cleanup first.
+`, perfutils.GetShortBinaryName())
+	os.Exit(exitCode)
+}
+
+// Plan describes the whole distributed test
+type Plan struct {
+	Namebase         string                       `json:"namebase"`
+	StartDelayS      int                          `json:"startDelayS"`
+	RunDelayS        int                          `json:"runDelayS"`
+	MetricsIntervalS int                          `json:"metricsIntervalS"`
+	Env              map[string]string            `json:"env"`
+	Scenarios        map[string]map[string]string `json:"scenarios"`
+	Workers          []PlanWorker                 `json:"workers"`
+}
+
+// PlanWorker is 1 worker in the plan
+type PlanWorker struct {
+	Address  string `json:"address"`
+	Scenario string `json:"scenario"`
+	Namebase string `json:"namebase"` // optional
+	Hostname string `json:"hostname"` // optional
+}
+
+// workerEvent is a msg (or error) received from 1 of the workers
+type workerEvent struct {
+	index int
+	msg   perfutils.WorkerMsg
+	err   error
+}
+
+// workerState is what we know about each worker while the test is running
+type workerState struct {
+	conn     net.Conn
+	host     string
+	latest   perfutils.WorkerMetrics
+	result   *perfutils.WorkerMsg
+	finished bool
+}
+
+func main() {
+	if len(os.Args) <= 1 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		Usage(1)
+	}
+
+	reportDir := perfutils.GetEnvVarWithDefault("EX_PERF_REPORT_DIR", "/tmp/exchangePerf")
+	perfutils.MakeDir(reportDir)
+	perfutils.EX_PERF_REPORT_FILE = reportDir + "/" + perfutils.GetShortBinaryName() + ".summary"
+	perfutils.RemoveFile(perfutils.EX_PERF_REPORT_FILE)
+
+	plan := readPlan(os.Args[1])
+
+	// Connect to all of the workers before assigning anything, so we don't start a partial test
+	fmt.Printf("Connecting to %d workers...\n", len(plan.Workers))
+	workers := make([]*workerState, len(plan.Workers))
+	for i, pw := range plan.Workers {
+		conn, err := net.DialTimeout("tcp", pw.Address, 10*time.Second)
+		if err != nil {
+			perfutils.Fatal(perfutils.HTTP_ERROR, "could not connect to worker %s: %v", pw.Address, err)
+		}
+		host, _, err := net.SplitHostPort(pw.Address)
+		if err != nil {
+			perfutils.Fatal(perfutils.CLI_INPUT_ERROR, "invalid worker address %s: %v", pw.Address, err)
+		}
+		workers[i] = &workerState{conn: conn, host: host}
+	}
+
+	// Send the assignments, all with the same start time
+	startAt := time.Now().Add(perfutils.Seconds2Duration(plan.StartDelayS))
+	for i, pw := range plan.Workers {
+		assignment := perfutils.WorkerAssignment{
+			Namebase:         pw.Namebase,
+			Hostname:         pw.Hostname,
+			Scenario:         pw.Scenario,
+			Env:              map[string]string{},
+			StartAt:          startAt,
+			MetricsIntervalS: plan.MetricsIntervalS,
+		}
+		if plan.RunDelayS > 0 {
+			assignment.RunAt = startAt.Add(perfutils.Seconds2Duration(plan.RunDelayS))
+		}
+		if assignment.Namebase == "" {
+			assignment.Namebase = plan.Namebase + "-" + strconv.Itoa(i+1)
+		}
+		if assignment.Hostname == "" {
+			assignment.Hostname = plan.Namebase + "-" + strings.Replace(workers[i].host, ".", "-", -1)
+		}
+		for name, value := range plan.Env {
+			assignment.Env[name] = value
+		}
+		for name, value := range plan.Scenarios[pw.Scenario] {
+			assignment.Env[name] = value
+		}
+		assignment.SentAt = time.Now()
+		if err := json.NewEncoder(workers[i].conn).Encode(assignment); err != nil {
+			perfutils.Fatal(perfutils.HTTP_ERROR, "could not send assignment to worker %s: %v", pw.Address, err)
+		}
+		fmt.Printf("Assigned %s: namebase %s, scenario '%s'\n", pw.Address, assignment.Namebase, pw.Scenario)
+	}
+	fmt.Printf("All workers will start at %s\n", startAt.Format("2006.01.02 15:04:05"))
+
+	// Read the msgs from all of the workers
+	events := make(chan workerEvent)
+	for i := range workers {
+		go readWorker(i, workers[i].conn, events)
+	}
+
+	ticker := time.NewTicker(perfutils.Seconds2Duration(plan.MetricsIntervalS))
+	defer ticker.Stop()
+	lastOps := 0
+	lastTime := time.Now()
+	numFinished := 0
+	for numFinished < len(workers) {
+		select {
+		case e := <-events:
+			w := workers[e.index]
+			if w.finished {
+				continue
+			}
+			if e.err != nil {
+				perfutils.Error("worker %s disconnected before sending its results: %v", plan.Workers[e.index].Address, e.err)
+			} else {
+				w.latest = e.msg.Metrics
+				if e.msg.Type != perfutils.WORKER_MSG_RESULT {
+					continue
+				}
+				w.result = &e.msg
+				fmt.Printf("Worker %s (%s %s) finished with exit code %d\n", plan.Workers[e.index].Address, e.msg.Driver, e.msg.Namebase, e.msg.ExitCode)
+			}
+			w.finished = true
+			w.conn.Close()
+			numFinished++
+		case <-ticker.C:
+			lastOps, lastTime = showLiveMetrics(workers, lastOps, lastTime)
+		}
+	}
+	showLiveMetrics(workers, lastOps, lastTime)
+
+	// Write the summaries in the same layout scaledriver.sh used, so summarize.sh still works
+	fmt.Printf("\nWriting the worker summaries to %s...\n", reportDir)
+	var errorFiles []string
+	numFailed := 0
+	for i, w := range workers {
+		if w.result == nil {
+			numFailed++
+			continue
+		}
+		dir := filepath.Join(reportDir, w.host, w.result.Driver)
+		perfutils.MakeDir(dir)
+		file := filepath.Join(dir, w.result.Namebase+"-"+w.result.Driver+".summary")
+		if err := ioutil.WriteFile(file, []byte(w.result.Summary), 0644); err != nil {
+			perfutils.Fatal(perfutils.FILE_IO_ERROR, "could not write %s: %v", file, err)
+		}
+		if w.result.ExitCode != 0 || strings.Contains(w.result.Summary, "Error:==") {
+			errorFiles = append(errorFiles, file)
+		}
+		perfutils.Verbose("wrote summary of worker %s to %s", plan.Workers[i].Address, file)
+	}
+
+	if numFailed == 0 && len(errorFiles) == 0 {
+		fmt.Println("Scale run was 100% successful!")
+		fmt.Printf("Scale node summaries can be viewed with: head -n 100 %s/*/*/*.summary\n", reportDir)
+		return
+	}
+	if numFailed > 0 {
+		fmt.Printf("%d workers did not return results, see %s\n", numFailed, perfutils.EX_PERF_REPORT_FILE)
+	}
+	if len(errorFiles) > 0 {
+		fmt.Printf("Errors occurred in the scale run, see files:\n%s\n", strings.Join(errorFiles, "\n"))
+	}
+	os.Exit(perfutils.CLI_GENERAL_ERROR)
+}
+
+// readPlan reads the plan file and fills in the defaults
+func readPlan(planFile string) Plan {
+	planBytes, err := ioutil.ReadFile(planFile)
+	if err != nil {
+		perfutils.Fatal(perfutils.FILE_IO_ERROR, "could not read %s: %v", planFile, err)
+	}
+	var plan Plan
+	perfutils.Unmarshal(planBytes, &plan, planFile)
+	if len(plan.Workers) == 0 {
+		perfutils.Fatal(perfutils.CLI_INPUT_ERROR, "no workers specified in %s", planFile)
+	}
+	if plan.Namebase == "" {
+		plan.Namebase = "perf"
+	}
+	if plan.StartDelayS <= 0 {
+		plan.StartDelayS = 5
+	}
+	if plan.MetricsIntervalS <= 0 {
+		plan.MetricsIntervalS = perfutils.DefaultMetricsIntervalS
+	}
+	for _, pw := range plan.Workers {
+		if _, ok := plan.Scenarios[pw.Scenario]; pw.Scenario != "" && !ok {
+			perfutils.Fatal(perfutils.CLI_INPUT_ERROR, "worker %s refers to scenario '%s', which is not in %s", pw.Address, pw.Scenario, planFile)
+		}
+	}
+	return plan
+}
+
+// readWorker sends every msg from this worker to the events channel, until the result msg or an error
+func readWorker(index int, conn net.Conn, events chan<- workerEvent) {
+	decoder := json.NewDecoder(conn)
+	for {
+		var msg perfutils.WorkerMsg
+		if err := decoder.Decode(&msg); err != nil {
+			events <- workerEvent{index: index, err: err}
+			return
+		}
+		events <- workerEvent{index: index, msg: msg}
+		if msg.Type == perfutils.WORKER_MSG_RESULT {
+			return
+		}
+	}
+}
+
+// showLiveMetrics displays the combined metrics of all of the workers, and returns the total ops and time, to calculate the rate next time
+func showLiveMetrics(workers []*workerState, lastOps int, lastTime time.Time) (int, time.Time) {
+	ops := 0
+	errors := 0
+	phases := map[string]int{}
+	for _, w := range workers {
+		ops += w.latest.Ops
+		errors += w.latest.Errors
+		if w.finished {
+			phases["finished"]++
+		} else if w.latest.Phase != "" {
+			phases[w.latest.Phase]++
+		} else {
+			phases["waiting"]++
+		}
+	}
+	now := time.Now()
+	rate := float64(ops-lastOps) / now.Sub(lastTime).Seconds()
+	if rate < 0 {
+		rate = 0 // the workers reset their op counts when the timed part of the test starts
+	}
+	var phaseStrs []string
+	for _, p := range []string{"waiting", "setup", "ready", "run", "cleanup", "finished"} {
+		if phases[p] > 0 {
+			phaseStrs = append(phaseStrs, fmt.Sprintf("%s=%d", p, phases[p]))
+		}
+	}
+	fmt.Printf("%s workers: %s, ops=%d (%.1f ops/s), errors=%d\n", now.Format("15:04:05"), strings.Join(phaseStrs, " "), ops, rate, errors)
+	return ops, now
+}
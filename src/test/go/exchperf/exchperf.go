@@ -0,0 +1,84 @@
+// The exchange perf/scale test CLI. It runs the drivers (node, agbot, small, replay, cleanup) as subcommands, and reports on and compares their
+// results. It can also be installed (copied or linked) under the name of a driver (e.g. node), and then runs that driver, like the separate
+// binaries used to (this is how the Makefile still builds node, agbot, cleanup, and smalltest).
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/open-horizon/exchange-api/src/test/go/agbot"
+	"github.com/open-horizon/exchange-api/src/test/go/catalog"
+	"github.com/open-horizon/exchange-api/src/test/go/cleanup"
+	"github.com/open-horizon/exchange-api/src/test/go/node"
+	"github.com/open-horizon/exchange-api/src/test/go/record"
+	"github.com/open-horizon/exchange-api/src/test/go/replay"
+	"github.com/open-horizon/exchange-api/src/test/go/smalltest"
+)
+
+// command is 1 subcommand. run gets the args after the subcommand name, and prog is how to show the command in its usage.
+type command struct {
+	name        string
+	aliases     []string // other names it can be invoked as, e.g. the names of the old binaries
+	description string
+	run         func(prog string, args []string)
+}
+
+var commands = []command{
+	{name: "node", description: "simulate many nodes making calls to the exchange", run: node.Main},
+	{name: "agbot", description: "simulate agbots making agreements with the simulated nodes", run: agbot.Main},
+	{name: "small", aliases: []string{"smalltest"}, description: "micro-benchmark individual exchange apis", run: smalltest.Main},
+	{name: "record", description: "record the api calls of real agents, with a proxy in front of the exchange", run: record.Main},
+	{name: "replay", description: "replay a recorded trace as many synthetic nodes and agbots", run: replay.Main},
+	{name: "catalog", description: "create a synthetic catalog of services and patterns", run: catalog.Main},
+	{name: "cleanup", description: "delete the exchange resources left behind by the drivers", run: cleanup.Main},
+	{name: "report", description: "summarize the results of a run", run: reportMain},
+	{name: "compare", description: "compare the results of 2 runs, to find regressions", run: compareMain},
+}
+
+func Usage(exitCode int) {
+	fmt.Printf("Usage: %s <command> [flags] [args]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, c := range commands {
+		fmt.Printf("  %-8s %s\n", c.name, c.description)
+	}
+	fmt.Printf("\nRun '%s <command> --help' for the flags and settings of each command.\n", filepath.Base(os.Args[0]))
+	os.Exit(exitCode)
+}
+
+// lookupCommand returns the command with this name or alias, or nil
+func lookupCommand(name string) *command {
+	for i, c := range commands {
+		if c.name == name {
+			return &commands[i]
+		}
+		for _, alias := range c.aliases {
+			if alias == name {
+				return &commands[i]
+			}
+		}
+	}
+	return nil
+}
+
+func main() {
+	// If we were installed under the name of a command, run it
+	binaryName := filepath.Base(os.Args[0])
+	if c := lookupCommand(binaryName); c != nil {
+		c.run(binaryName, os.Args[1:])
+		return
+	}
+
+	if len(os.Args) <= 1 {
+		Usage(1)
+	}
+	if os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		Usage(0)
+	}
+	c := lookupCommand(os.Args[1])
+	if c == nil {
+		fmt.Printf("Error: unknown command '%s'\n\n", os.Args[1])
+		Usage(1)
+	}
+	c.run(binaryName+" "+c.name, os.Args[2:])
+}
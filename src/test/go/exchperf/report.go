@@ -0,0 +1,209 @@
+// The report and compare commands, which read the summary files the drivers wrote in a report dir
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+// driverTotals are the totals of all of the instances of 1 driver in a run
+type driverTotals struct {
+	instances   int
+	ops         int
+	errors      int
+	activeTimeS float64
+}
+
+// avgMs returns the avg time of the api calls of the driver, in ms
+func (d *driverTotals) avgMs() float64 {
+	if d.ops == 0 {
+		return 0
+	}
+	return 1000 * d.activeTimeS / float64(d.ops)
+}
+
+// runResults are the results of all of the summaries in a report dir. The route counts are the totals of all of the instances, and the
+// percentiles are the worst of any instance (the percentiles of the instances can not be combined exactly).
+type runResults struct {
+	dir       string
+	summaries int
+	drivers   map[string]*driverTotals
+	routes    map[string]*perfutils.RouteSummary
+}
+
+// readRun reads all of the summaries in the report dir
+func readRun(dir string) *runResults {
+	summaries, err := perfutils.ReadSummaries(dir)
+	if err != nil {
+		perfutils.Fatal(perfutils.FILE_IO_ERROR, "could not read the summaries in %s: %v", dir, err)
+	}
+	if len(summaries) == 0 {
+		perfutils.Fatal(perfutils.NOT_FOUND, "no summary files found in %s", dir)
+	}
+
+	r := &runResults{dir: dir, summaries: len(summaries), drivers: map[string]*driverTotals{}, routes: map[string]*perfutils.RouteSummary{}}
+	for _, s := range summaries {
+		d := r.drivers[s.Driver]
+		if d == nil {
+			d = &driverTotals{}
+			r.drivers[s.Driver] = d
+		}
+		d.instances++
+		d.ops += s.Ops
+		d.errors += s.Errors
+		d.activeTimeS += s.ActiveTimeS
+
+		for _, rs := range s.Routes {
+			total := r.routes[rs.Route]
+			if total == nil {
+				total = &perfutils.RouteSummary{Route: rs.Route}
+				r.routes[rs.Route] = total
+			}
+			total.Count += rs.Count
+			total.P50 = maxFloat(total.P50, rs.P50)
+			total.P95 = maxFloat(total.P95, rs.P95)
+			total.P99 = maxFloat(total.P99, rs.P99)
+			total.Max = maxFloat(total.Max, rs.Max)
+		}
+	}
+	return r
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// driverNames returns the names of the drivers in the run, sorted
+func (r *runResults) driverNames() []string {
+	var names []string
+	for name := range r.drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// routeNames returns the routes in the run, sorted
+func (r *runResults) routeNames() []string {
+	var routes []string
+	for route := range r.routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// reportMain summarizes the results of a run
+func reportMain(prog string, args []string) {
+	flags := flag.NewFlagSet(prog, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`Usage: %s [<report-dir>]
+
+Summarizes all of the driver summaries in report-dir (default: $EX_PERF_REPORT_DIR, or /tmp/exchangePerf): the totals of each driver,
+and the latency of each api route. The route percentiles shown are the worst of any driver instance.
+`, prog)
+	}
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	dir := perfutils.ConfigString("EX_PERF_REPORT_DIR")
+	if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+
+	r := readRun(dir)
+	fmt.Printf("Results in %s (%d summaries):\n", r.dir, r.summaries)
+	fmt.Printf("%-12s %9s %10s %13s %10s %7s\n", "driver", "instances", "ops", "active time s", "avg ms/op", "errors")
+	for _, name := range r.driverNames() {
+		d := r.drivers[name]
+		fmt.Printf("%-12s %9d %10d %13.3f %10.3f %7d\n", name, d.instances, d.ops, d.activeTimeS, d.avgMs(), d.errors)
+	}
+	if len(r.routes) > 0 {
+		fmt.Println("Per-route latency (ms):")
+		for _, route := range r.routeNames() {
+			rs := r.routes[route]
+			fmt.Printf("  %s: n=%d p50=%.2f p95=%.2f p99=%.2f max=%.2f\n", rs.Route, rs.Count, rs.P50, rs.P95, rs.P99, rs.Max)
+		}
+	}
+}
+
+// compareMain compares the results of 2 runs
+func compareMain(prog string, args []string) {
+	flags := flag.NewFlagSet(prog, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`Usage: %s [flags] <baseline-report-dir> <report-dir>
+
+Compares the results in report-dir to the ones in baseline-report-dir: the avg time of the api calls of each driver, and the p95 latency
+of each api route. A positive change means slower.
+Flags:
+`, prog)
+		flags.SetOutput(os.Stdout)
+		flags.PrintDefaults()
+	}
+	threshold := flags.Float64("threshold", 0, "exit with an error if any driver avg or route p95 is more than this percent slower (0 means never)")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *threshold < 0 {
+		perfutils.Fatal(perfutils.CLI_INPUT_ERROR, "--threshold must not be negative")
+	}
+
+	base, run := readRun(flags.Arg(0)), readRun(flags.Arg(1))
+	var regressions []string
+	// checkChange prints the change from before to after, and records it as a regression if it is over the threshold
+	checkChange := func(name string, before, after float64) string {
+		if before == 0 {
+			return "n/a"
+		}
+		change := 100 * (after - before) / before
+		if *threshold > 0 && change > *threshold {
+			regressions = append(regressions, fmt.Sprintf("%s is %.1f%% slower", name, change))
+		}
+		return fmt.Sprintf("%+.1f%%", change)
+	}
+
+	fmt.Printf("Comparing %s to the baseline %s:\n", run.dir, base.dir)
+	fmt.Printf("%-12s %15s %15s %8s\n", "driver", "baseline ms/op", "ms/op", "change")
+	for _, name := range run.driverNames() {
+		d := run.drivers[name]
+		if b := base.drivers[name]; b != nil {
+			fmt.Printf("%-12s %15.3f %15.3f %8s\n", name, b.avgMs(), d.avgMs(), checkChange(name+" avg", b.avgMs(), d.avgMs()))
+		} else {
+			fmt.Printf("%-12s %15s %15.3f\n", name, "-", d.avgMs())
+		}
+	}
+
+	fmt.Println("Per-route p95 latency (ms):")
+	for _, route := range run.routeNames() {
+		rs := run.routes[route]
+		if b := base.routes[route]; b != nil {
+			fmt.Printf("  %s: %.2f -> %.2f (%s)\n", route, b.P95, rs.P95, checkChange(route+" p95", b.P95, rs.P95))
+		} else {
+			fmt.Printf("  %s: %.2f (not in the baseline)\n", route, rs.P95)
+		}
+	}
+	for _, route := range base.routeNames() {
+		if run.routes[route] == nil {
+			fmt.Printf("  %s: only in the baseline\n", route)
+		}
+	}
+
+	if len(regressions) > 0 {
+		fmt.Printf("\n%d regressions over the threshold of %.1f%%:\n", len(regressions), *threshold)
+		for _, r := range regressions {
+			fmt.Println("  " + r)
+		}
+		os.Exit(perfutils.CLI_GENERAL_ERROR)
+	}
+}
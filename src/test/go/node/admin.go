@@ -0,0 +1,207 @@
+package node
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+// adminActor simulates an org admin (or the management console) running the org-wide node queries while the nodes run, so we can
+// measure the cost of those scans as the number of nodes grows (and their impact on the latency the agents see), and suspending and
+// resuming the service on some of the nodes. It runs in its own goroutines between start() and stop().
+type adminActor struct {
+	ops                 int64 // the api calls it made (counted by its credentials), so they can be left out of the node stats. Must be 1st for atomic alignment
+	consoleQueries      int64
+	org                 string
+	auth                perfutils.Credentials
+	hubAdminAuth        perfutils.Credentials // for the queries across all of the orgs
+	nodebase            string
+	svcurl              string
+	svcversion          string
+	svcarch             string
+	errorInterval       time.Duration // how often to run the error searches (0 means never)
+	configStateInterval time.Duration // how often to suspend or resume the service on some nodes (0 means never)
+	configStateNodes    int           // how many nodes to change each time
+	configStates        *configStateTracker
+	consoleUsers        int           // how many management console users run the node queries concurrently
+	consoleThink        time.Duration // how long each of them waits between queries
+	rng                 *rand.Rand
+	stopChan            chan struct{}
+	wg                  sync.WaitGroup
+
+	errorSearches  int
+	errorNodesLast int // the number of nodes with errors that the last search found
+	errorNodesMax  int
+}
+
+func newAdminActor(org string, auth, hubAdminAuth perfutils.Credentials, nodebase, svcurl, svcversion, svcarch string, numNodes int) *adminActor {
+	a := &adminActor{org: org, nodebase: nodebase, svcurl: svcurl, svcversion: svcversion, svcarch: svcarch,
+		errorInterval:       perfutils.Seconds2Duration(perfutils.ConfigInt("EX_PERF_ADMIN_ERROR_SEARCH_S")),
+		configStateInterval: perfutils.Seconds2Duration(perfutils.ConfigInt("EX_PERF_CONFIGSTATE_S")),
+		configStateNodes:    perfutils.MaxInt(numNodes*perfutils.ConfigInt("EX_PERF_CONFIGSTATE_PCT")/100, 1),
+		consoleUsers:        perfutils.ConfigInt("EX_PERF_CONSOLE_USERS"),
+		consoleThink:        time.Duration(perfutils.ConfigInt("EX_PERF_CONSOLE_THINK_MS")) * time.Millisecond,
+		rng:                 rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	a.auth = perfutils.CountedCredentials{Credentials: auth, Ops: &a.ops}
+	a.hubAdminAuth = perfutils.CountedCredentials{Credentials: hubAdminAuth, Ops: &a.ops}
+	if a.configStateInterval > 0 {
+		a.configStates = newConfigStateTracker(numNodes)
+	}
+	return a
+}
+
+// enabled returns true if the admin has anything to do
+func (a *adminActor) enabled() bool {
+	return a.errorInterval > 0 || a.configStateInterval > 0 || a.consoleUsers > 0
+}
+
+// start runs the admin and the console users in goroutines, if they are enabled
+func (a *adminActor) start() {
+	if !a.enabled() {
+		return
+	}
+	a.stopChan = make(chan struct{})
+	if a.errorInterval > 0 || a.configStateInterval > 0 {
+		a.wg.Add(1)
+		go a.run()
+	}
+	for u := 0; u < a.consoleUsers; u++ {
+		a.wg.Add(1)
+		go a.runConsoleUser(u)
+	}
+}
+
+// stop stops the admin and the console users, and waits for them to finish their current queries
+func (a *adminActor) stop() {
+	if a.stopChan == nil {
+		return
+	}
+	close(a.stopChan)
+	a.wg.Wait()
+	a.stopChan = nil
+}
+
+func (a *adminActor) run() {
+	defer a.wg.Done()
+	errorTick, stopErrorTicker := tickerChan(a.errorInterval)
+	defer stopErrorTicker()
+	configStateTick := a.nextConfigStateTick()
+	for {
+		select {
+		case <-a.stopChan:
+			return
+		case <-errorTick:
+			a.searchErrors()
+		case <-configStateTick:
+			a.changeConfigStates()
+			configStateTick = a.nextConfigStateTick()
+		}
+	}
+}
+
+// tickerChan returns the channel of a ticker with the interval and the func to stop it, or a nil channel (which never fires) if the interval is 0
+func tickerChan(interval time.Duration) (<-chan time.Time, func()) {
+	if interval <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(interval)
+	return ticker.C, ticker.Stop
+}
+
+// nextConfigStateTick returns the channel for the next config state change, or nil if there are none. The changes are made at random times
+// (every configStateInterval on average), so they are not in step with the node heartbeats, which would skew the propagation times.
+func (a *adminActor) nextConfigStateTick() <-chan time.Time {
+	if a.configStateInterval <= 0 {
+		return nil
+	}
+	return time.After(a.configStateInterval/2 + time.Duration(a.rng.Int63n(int64(a.configStateInterval))))
+}
+
+// runConsoleUser runs the queries of the management console's node views, 1 after another with the think time between them. Each user
+// starts with a different query, so they are spread over all of them.
+func (a *adminActor) runConsoleUser(u int) {
+	defer a.wg.Done()
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(u)))
+	queries := []func(){
+		func() { perfutils.ExchangeGet("orgs/"+a.org+"/node-details", a.auth, []int{404}, nil) },
+		func() {
+			perfutils.ExchangeP(http.MethodPost, "orgs/"+a.org+"/search/nodehealth", a.auth, []int{404}, `{"lastTime": ""}`, nil, true) // empty string for lastTime will return all nodes
+		},
+		func() {
+			perfutils.ExchangeP(http.MethodPost, "orgs/"+a.org+"/search/nodes/service", a.auth, []int{404}, `{"orgid": "`+a.org+`", "serviceURL": "`+a.svcurl+`", "serviceVersion": "`+a.svcversion+`", "serviceArch": "`+a.svcarch+`"}`, nil, true)
+		},
+		func() { perfutils.ExchangeGet("admin/orgstatus", a.hubAdminAuth, nil, nil) },
+	}
+	for q := u; ; q++ {
+		// Think for 0.5 to 1.5 times the think time, so the users do not run in step
+		think := a.consoleThink/2 + time.Duration(rng.Int63n(int64(a.consoleThink)+1))
+		select {
+		case <-a.stopChan:
+			return
+		case <-time.After(think):
+		}
+		queries[q%len(queries)]()
+		atomic.AddInt64(&a.consoleQueries, 1)
+	}
+}
+
+// searchErrors runs both searches for the nodes with errors: the list of their ids, and all of their errors
+func (a *adminActor) searchErrors() {
+	var errorNodes struct {
+		Nodes []string `json:"nodes"`
+	}
+	perfutils.ExchangeP(http.MethodPost, "orgs/"+a.org+"/search/nodes/error", a.auth, []int{404}, `{}`, &errorNodes, true)
+	var allErrors struct {
+		NodeErrors []struct{} `json:"nodeErrors"`
+	}
+	perfutils.ExchangeGet("orgs/"+a.org+"/search/nodes/error/all", a.auth, []int{404}, &allErrors)
+
+	a.errorSearches++
+	a.errorNodesLast = len(errorNodes.Nodes)
+	a.errorNodesMax = perfutils.MaxInt(a.errorNodesMax, a.errorNodesLast)
+	perfutils.Verbose("admin error search %d: %d nodes with errors, %d error lists", a.errorSearches, len(errorNodes.Nodes), len(allErrors.NodeErrors))
+}
+
+// changeConfigStates suspends or resumes (whichever it is not) the service on a random subset of the nodes, like "hzn service configstate" does.
+// The nodes notice it the next time they get their node resource.
+func (a *adminActor) changeConfigStates() {
+	for _, n := range a.configStates.pick(a.rng, a.configStateNodes) {
+		suspend := !a.configStates.isSuspended(n)
+		state := perfutils.CONFIG_STATE_SUSPENDED
+		if !suspend {
+			state = perfutils.CONFIG_STATE_ACTIVE
+		}
+		a.configStates.change(n, suspend) // before the api call, because the node can notice it as soon as it is made
+		httpCode := perfutils.ExchangeP(http.MethodPost, "orgs/"+a.org+"/nodes/"+a.nodebase+strconv.Itoa(n)+"/services_configstate", a.auth, []int{404}, `{"org": "`+a.org+`", "url": "`+a.svcurl+`", "configState": "`+state+`"}`, nil, true)
+		if httpCode != 201 { // e.g. the node is churning
+			a.configStates.cancel(n)
+		}
+	}
+}
+
+// getOps returns the number of api calls the admin and the console users made, including the retries
+func (a *adminActor) getOps() int {
+	return int(atomic.LoadInt64(&a.ops))
+}
+
+// String returns the summary of what the admin did. Only call it after stop().
+func (a *adminActor) String() string {
+	str := fmt.Sprintf("Admin: num ops=%d", a.getOps())
+	if a.errorInterval > 0 {
+		str += fmt.Sprintf(", error searches=%d (every %.0f s), nodes with errors: last search=%d, max=%d", a.errorSearches, a.errorInterval.Seconds(), a.errorNodesLast, a.errorNodesMax)
+	}
+	if a.consoleUsers > 0 {
+		str += fmt.Sprintf(", console users=%d, console queries=%d (think time %d ms)", a.consoleUsers, atomic.LoadInt64(&a.consoleQueries), a.consoleThink/time.Millisecond)
+	}
+	if a.configStates != nil {
+		str += "\n" + a.configStates.String()
+	}
+	return str
+}
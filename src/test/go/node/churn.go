@@ -0,0 +1,60 @@
+package node
+
+import (
+	"fmt"
+)
+
+// What a churning node does
+const (
+	churnReregister = iota // unregister (delete its agreement and the node) and register again, with a new token
+	churnNewToken          // register again with a new token, without unregistering first
+	churnSwitch            // switch to another pattern (if there is more than 1) and change its node policy
+	numChurnActions
+)
+
+type churnAction struct {
+	node   int
+	action int
+}
+
+// churner picks the nodes that churn each heartbeat interval. The nodes take turns, and so do the actions, so every node and action is
+// exercised evenly over the run. The nodes that are offline (in an outage or stale) are passed over, because they can not churn.
+type churner struct {
+	numNodes   int
+	perHb      int
+	nextNode   int
+	nextAction int
+	counts     [numChurnActions]int
+	skipped    int // turns of nodes that were offline
+}
+
+func newChurner(numNodes, churnPct int) *churner {
+	c := &churner{numNodes: numNodes, perHb: numNodes * churnPct / 100, nextNode: 1}
+	if churnPct > 0 && c.perHb == 0 {
+		c.perHb = 1
+	}
+	return c
+}
+
+// next returns the nodes to churn this heartbeat interval, and what each of them does. It passes over the nodes that are offline, but
+// tries each node at most once.
+func (c *churner) next(offline func(n int) bool) []churnAction {
+	var actions []churnAction
+	for tried := 0; len(actions) < c.perHb && tried < c.numNodes; tried++ {
+		n := c.nextNode
+		c.nextNode = c.nextNode%c.numNodes + 1
+		if offline(n) {
+			c.skipped++
+			continue
+		}
+		actions = append(actions, churnAction{node: n, action: c.nextAction})
+		c.counts[c.nextAction]++
+		c.nextAction = (c.nextAction + 1) % numChurnActions
+	}
+	return actions
+}
+
+func (c *churner) String() string {
+	return fmt.Sprintf("Churn: %d nodes per heartbeat, unregistered and registered again=%d, registered with a new token=%d, switched pattern/policy=%d, passed over because offline=%d",
+		c.perHb, c.counts[churnReregister], c.counts[churnNewToken], c.counts[churnSwitch], c.skipped)
+}
@@ -0,0 +1,106 @@
+package node
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+// configStateTracker keeps the config state the admin set for the service on each node, and the time of each change until the node notices
+// it, so we can measure how long a suspend or resume takes to propagate to the nodes. It is shared by the admin and the heartbeat loop.
+type configStateTracker struct {
+	lock      sync.Mutex
+	suspended []bool      // the state the admin set, by node number
+	changed   []time.Time // when the admin changed it, or zero if the node has noticed the change
+	suspends  int
+	resumes   int
+	latencies []time.Duration
+}
+
+func newConfigStateTracker(numNodes int) *configStateTracker {
+	return &configStateTracker{suspended: make([]bool, numNodes+1), changed: make([]time.Time, numNodes+1)}
+}
+
+// pick returns num random nodes whose last change the nodes have already noticed, so each change can be timed
+func (t *configStateTracker) pick(rng *rand.Rand, num int) []int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var nodes []int
+	for _, i := range rng.Perm(len(t.suspended) - 1) {
+		if len(nodes) >= num {
+			break
+		}
+		if t.changed[i+1].IsZero() {
+			nodes = append(nodes, i+1)
+		}
+	}
+	return nodes
+}
+
+// isSuspended returns the state the admin last set for node n
+func (t *configStateTracker) isSuspended(n int) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.suspended[n]
+}
+
+// change records that the admin set the state of node n
+func (t *configStateTracker) change(n int, suspended bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.suspended[n] = suspended
+	t.changed[n] = time.Now()
+	if suspended {
+		t.suspends++
+	} else {
+		t.resumes++
+	}
+}
+
+// cancel undoes the last change of node n, because the api call failed
+func (t *configStateTracker) cancel(n int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.suspended[n] = !t.suspended[n]
+	t.changed[n] = time.Time{}
+	if t.suspended[n] {
+		t.resumes--
+	} else {
+		t.suspends--
+	}
+}
+
+// reset records that node n registered again, which made its service active without the admin changing it
+func (t *configStateTracker) reset(n int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.suspended[n] = false
+	t.changed[n] = time.Time{}
+}
+
+// notice records that node n saw the given state in the exchange. It is called every time the node gets its node resource, so a change
+// is timed when the node 1st sees it.
+func (t *configStateTracker) notice(n int, suspended bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.changed[n].IsZero() && t.suspended[n] == suspended {
+		t.latencies = append(t.latencies, time.Since(t.changed[n]))
+		t.changed[n] = time.Time{}
+	}
+}
+
+func (t *configStateTracker) String() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	pending := 0
+	for _, c := range t.changed {
+		if !c.IsZero() {
+			pending++
+		}
+	}
+	return fmt.Sprintf("Config state: suspends=%d, resumes=%d, not noticed by the end=%d, propagation to the nodes: %s",
+		t.suspends, t.resumes, pending, perfutils.ComputeLatencyStats(append([]time.Duration(nil), t.latencies...)))
+}
@@ -0,0 +1,37 @@
+package node
+
+import (
+	"fmt"
+	"time"
+)
+
+// nodeError is 1 of the errors the agent reports when a service fails to deploy
+type nodeError struct {
+	eventCode string
+	message   string
+}
+
+// The kinds of errors anax reports most often, so the error lists look like the real ones
+var nodeErrorKinds = []nodeError{
+	{"error_image_load", "Error loading image openhorizon/gps:%s: manifest for openhorizon/gps:%s not found: manifest unknown"},
+	{"error_start_container", "Error starting containers: API error (500): failed to create endpoint gps on network bridge: port %s is already allocated"},
+	{"error_in_deployment_config", "Error in deployment configuration: service %s has no image for version %s"},
+	{"error_cancel_agreement", "Agreement %s is cancelled because the service containers failed to start"},
+}
+
+// nodeErrorsBody returns the body of PUT nodes/{id}/errors that node n sends after its service failed to deploy: 1 to 3 errors, like anax
+// reports them (with the newest last)
+func nodeErrorsBody(n int, org, svcurl, svcversion, agreementid string) string {
+	args := [][]interface{}{{svcversion, svcversion}, {"8080"}, {svcurl, svcversion}, {agreementid}}
+	numErrors := n%3 + 1
+	body := `{"errors": [`
+	for e := 0; e < numErrors; e++ {
+		k := (n + e) % len(nodeErrorKinds)
+		if e > 0 {
+			body += ", "
+		}
+		body += fmt.Sprintf(`{"record_id": "%d", "message": "%s", "event_code": "%s", "hidden": false, "workload": {"url": "%s/%s"}, "timestamp": "%s"}`,
+			e+1, fmt.Sprintf(nodeErrorKinds[k].message, args[k]...), nodeErrorKinds[k].eventCode, org, svcurl, time.Now().UTC().Format(time.RFC3339))
+	}
+	return body + `]}`
+}
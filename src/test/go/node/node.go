@@ -1,79 +1,94 @@
-// Performance test simulating many nodes making calls to the exchange. For scale testing, run many instances of this using wrapper.sh
-package main
+// The node driver of exchperf: a performance test simulating many nodes making calls to the exchange. For scale testing, run many instances of it using wrapper.sh or the coordinator
+package node
 
 import (
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
 )
 
-func Usage(exitCode int) {
-	fmt.Printf("Usage: %s <name base> [short-hostname]\n", perfutils.GetShortBinaryName())
-	os.Exit(exitCode)
+func Usage(prog string, flags *flag.FlagSet) {
+	fmt.Printf("Usage: %s [flags] <name base> [short-hostname]\n", prog)
+	fmt.Printf("       %s [flags] --worker <listen-address>    (wait for the coordinator to assign the name base, hostname, and settings)\n", prog)
+	perfutils.PrintSettingsUsage(perfutils.DRIVER_NODE, flags)
 }
 
-func main() {
-	if len(os.Args) <= 1 {
-		Usage(1)
+// Main runs the node driver with the command line args. prog is how it was invoked (e.g. "exchperf node"), for the usage.
+func Main(prog string, args []string) {
+	flags := flag.NewFlagSet(prog, flag.ExitOnError)
+	flags.Usage = func() { Usage(prog, flags) }
+	workerAddress := flags.String("worker", "", "run in worker mode, listening on this address")
+	args = perfutils.ParseConfig(perfutils.DRIVER_NODE, flags, args)
+
+	// In worker mode the coordinator gives us our name base and hostname
+	if *workerAddress != "" {
+		perfutils.RunWorker(*workerAddress, runNodeTest)
+		return
+	}
+	if len(args) == 0 {
+		Usage(prog, flags)
+		os.Exit(1)
 	}
 
-	scriptName := perfutils.GetShortBinaryName()
-	namebase := os.Args[1] + "-node"
 	var hostname = "" // this is for exchange resources that should only be created 1 per host
-	if len(os.Args) >= 3 {
-		hostname = os.Args[2]
+	if len(args) >= 2 {
+		hostname = args[1]
 	}
+	runNodeTest(args[0], hostname)
+}
 
-	rootauth := "root/root:" + perfutils.GetRequiredEnvVar("EXCHANGE_ROOTPW")
-	EXCHANGE_IAM_KEY := perfutils.GetRequiredEnvVar("EXCHANGE_IAM_KEY")
-	EXCHANGE_IAM_EMAIL := perfutils.GetRequiredEnvVar("EXCHANGE_IAM_EMAIL")
+// runNodeTest runs the whole node simulation: setup, the heartbeat loop, and clean up
+func runNodeTest(namebaseArg, hostname string) {
+	scriptName := perfutils.GetDriverName()
+	namebase := namebaseArg + "-node"
+
+	rootauth := perfutils.GetRootCredentials()
 	HZN_EXCHANGE_URL := perfutils.GetRequiredEnvVar("HZN_EXCHANGE_URL")
-	// Setting EXCHANGE_IAM_ACCOUNT_ID (id of your cloud account) distinguishes this as an ibm public cloud environment, instead of ICP
-	EXCHANGE_IAM_ACCOUNT_ID := os.Getenv("EXCHANGE_IAM_ACCOUNT_ID")
 
 	// default of where to write the summary or error msgs. Can be overridden
-	EX_PERF_REPORT_DIR := perfutils.GetEnvVarWithDefault("EX_PERF_REPORT_DIR", "/tmp/exchangePerf")
+	EX_PERF_REPORT_DIR := perfutils.ConfigString("EX_PERF_REPORT_DIR")
 	reportDir := EX_PERF_REPORT_DIR + "/" + scriptName
 	// this file holds the summary stats, and any errors that may have occurred along the way
 	perfutils.EX_PERF_REPORT_FILE = perfutils.GetEnvVarWithDefault("EX_PERF_REPORT_FILE", reportDir+"/"+namebase+".summary")
 
 	// The length of the performance test, measured in the number of times each node heartbeats (by default 60 sec each)
-	numHeartbeats := perfutils.GetEnvVarIntWithDefault("EX_PERF_NUM_HEARTBEATS", 15)
+	numHeartbeats := perfutils.ConfigInt("EX_PERF_NUM_HEARTBEATS")
 	// How many nodes this instance should simulate
-	numNodes := perfutils.GetEnvVarIntWithDefault("EX_PERF_NUM_NODES", 50)
+	numNodes := perfutils.ConfigInt("EX_PERF_NUM_NODES")
 	// EX_PERF_NUM_NODE_AGREEMENTS can be explicitly set to how many nodes should be given an agreement each hb interval, otherwise it will be calculated below. An estimate of the average number of msgs a node will have in flight at 1 time
 	// How many msgs should be created for each node (to simulate agreement negotiation)
-	//numMsgs := perfutils.GetEnvVarIntWithDefault("EX_PERF_NUM_MSGS", 5)
+	//numMsgs := perfutils.ConfigInt("EX_PERF_NUM_MSGS")
 	// create this many extra svcs so the nodes and patterns have to search thru them, but we will just use a primary/common svc for the pattern this group of nodes will use
-	numSvcs := perfutils.GetEnvVarIntWithDefault("EX_PERF_NUM_SVCS", 3)
+	numSvcs := perfutils.ConfigInt("EX_PERF_NUM_SVCS")
 	// create multiple patterns so the agbot has to serve them all, but we will just use the 1st one for this group of nodes
-	numPatterns := perfutils.GetEnvVarIntWithDefault("EX_PERF_NUM_PATTERNS", 1)
+	numPatterns := perfutils.ConfigInt("EX_PERF_NUM_PATTERNS")
 	// how much to sleep (if any) between creation and registration of each node
-	createRegSleep := perfutils.GetEnvVarIntWithDefault("EX_PERF_CREATE_REG_SLEEP_MS", 0)
+	createRegSleep := perfutils.ConfigInt("EX_PERF_CREATE_REG_SLEEP_MS")
 
 	// These defaults are taken from /etc/horizon/anax.json
-	nodeHbInterval := perfutils.GetEnvVarIntWithDefault("EX_NODE_HB_INTERVAL", 60)
-	svcCheckInterval := perfutils.GetEnvVarIntWithDefault("EX_NODE_SVC_CHECK_INTERVAL", 300)
-	versionCheckInterval := perfutils.GetEnvVarIntWithDefault("EX_NODE_VERSION_CHECK_INTERVAL", 720)
+	nodeHbInterval := perfutils.ConfigInt("EX_NODE_HB_INTERVAL")
+	svcCheckInterval := perfutils.ConfigInt("EX_NODE_SVC_CHECK_INTERVAL")
+	versionCheckInterval := perfutils.ConfigInt("EX_NODE_VERSION_CHECK_INTERVAL")
+	statusInterval := perfutils.ConfigInt("EX_NODE_STATUS_INTERVAL") // 0 means the status is only put when the node unregisters
 	// EX_NODE_NO_SLEEP can be set to disable sleeping if it finishes an interval early
 
+	// The percent of the nodes whose service fails to deploy, so they report errors when they get their agreement, and how many hbs later they clear them
+	errorPct := perfutils.ConfigInt("EX_PERF_NODE_ERROR_PCT")
+	errorClearHbs := perfutils.ConfigInt("EX_NODE_ERROR_CLEAR_HBS")
+
 	// CURL_CA_BUNDLE can be exported in our parent if a self-signed cert is needed.
 
 	// This script will create just 1 org and put everything else under that. If you use wrapper.sh, all instances of this script and agbot.go should use the same org.
-	org := perfutils.GetEnvVarWithDefault("EX_PERF_ORG", "performancenodeagbot")
+	org := perfutils.ConfigString("EX_PERF_ORG")
 
-	// Determine whether we are using the public cloud or ICP
-	var userauth string
-	if EXCHANGE_IAM_ACCOUNT_ID != "" {
-		userauth = org + "/iamapikey:" + EXCHANGE_IAM_KEY
-	} else {
-		// for ICP we can't play the game of associating our own org with another account, so we have to create/use a local exchange user
-		userauth = org + "/" + EXCHANGE_IAM_EMAIL + ":" + EXCHANGE_IAM_KEY
-	}
+	// EX_PERF_AUTH_MODE can be set to how the exchange user authenticates: basic, iamapikey, or bearer. The default depends on whether we are using the public cloud or ICP
+	userauth := perfutils.GetUserCredentials(org)
 
 	nodebase := namebase + "-n"
 	nodetoken := "abc123"
@@ -84,7 +99,7 @@ func main() {
 	agbotbase := namebase + "-a"
 	agbotid := agbotbase + "1"
 	agbottoken := "abc123"
-	//agbotauth := org + "/" + agbotid + ":" + agbottoken
+	//agbotauth := perfutils.AgbotToken{Org: org, AgbotId: agbotid, Token: agbottoken}
 
 	// svcurlbase is for creating the extra svcs. svcurl is the primary/common svc that all of the patterns will use
 	var svcurlbase string
@@ -99,21 +114,43 @@ func main() {
 	svcarch := "amd64"
 	svcid := svcurl + "_" + svcversion + "_" + svcarch
 
+	// The arches of the nodes, and the percent of them that are edge clusters. The primary svc is built for all of those arches and svcarch
+	// (which agbot.go uses).
+	archMix := perfutils.ArchMixFromConfig()
+	fleetArches := perfutils.Arches(archMix)
+	svcArches := []string{svcarch}
+	for _, arch := range fleetArches {
+		if arch != svcarch {
+			svcArches = append(svcArches, arch)
+		}
+	}
+	clusterNodePct := perfutils.ConfigInt("EX_PERF_CLUSTER_NODE_PCT")
+
+	var catalogbase string
+	if hostname != "" {
+		catalogbase = hostname + "-cat" // share 1 catalog for all instances on this host
+	} else {
+		catalogbase = namebase + "-cat"
+	}
+
 	var patternbase string
 	if hostname != "" {
 		patternbase = hostname + "-p" // share 1 set of patterns for all instances on this host
 	} else {
 		patternbase = namebase + "-p" // create our own patterns
 	}
-	patternid := patternbase + "1"
 
-	//buspolbase := namebase + "-bp"
-	//buspolid := buspolbase + "1"
+	// The admin who runs the org-wide searches (if EX_PERF_ADMIN_ERROR_SEARCH_S is set), suspends/resumes the service on some nodes
+	// (if EX_PERF_CONFIGSTATE_S is set), and the management console users (if EX_PERF_CONSOLE_USERS is set) while the nodes heartbeat
+	admin := newAdminActor(org, userauth, rootauth, nodebase, svcurl, svcversion, svcarch, numNodes)
 
-	var numNodeAgreements int
-	if na := os.Getenv("EX_PERF_NUM_NODE_AGREEMENTS"); na != "" {
-		numNodeAgreements = perfutils.Str2int(na)
-	} else {
+	// The percent of the nodes that register without a pattern, so the agbots find them with the business policy search instead
+	policyNodePct := perfutils.ConfigInt("EX_PERF_POLICY_NODE_PCT")
+	// There is a business policy for each arch of the nodes, because a business policy's service has 1 arch
+	buspolbase := namebase + "-bp"
+
+	numNodeAgreements := perfutils.ConfigInt("EX_PERF_NUM_NODE_AGREEMENTS")
+	if numNodeAgreements == 0 {
 		// Calculate the num agreements per HB to finish all of the nodes a few HBs before the end
 		numHB := numHeartbeats
 		if numHB > 1 {
@@ -126,82 +163,184 @@ func main() {
 
 	// =========== Initialization =================================================
 
+	perfutils.SetPhase("setup")
 	fmt.Printf("Initializing node test for %s, with %d heartbeats for %d nodes and %d agreements/HB:\n", namebase, numHeartbeats, numNodes, numNodeAgreements)
 	fmt.Println("Using exchange " + HZN_EXCHANGE_URL)
 
 	// Prepare the output dir
 	perfutils.MakeDir(reportDir)
 	perfutils.RemoveFile(perfutils.EX_PERF_REPORT_FILE)
+	// Keep the effective config with the results, so this run can be reproduced by passing it to --config
+	perfutils.WriteEffectiveConfig(reportDir + "/" + namebase + ".config.json")
+
+	// Check for (and create if necessary) each resource we need, recording them in our manifest so a crashed run can be resumed or torn down
+	manifest := perfutils.LoadSetupManifest(namebase, org)
 
 	// Can not delete the org in case other instances of this script are using it. Whoever calls this script must delete it afterward.
-	// So this is tolerant of the org already existing
-	if EXCHANGE_IAM_ACCOUNT_ID != "" {
-		// Using the public cloud
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org, rootauth, []int{403}, `{ "label": "perf test org", "description": "blah blah", "tags": { "ibmcloud_id": "`+EXCHANGE_IAM_ACCOUNT_ID+`" } }`, nil, false)
-		// normally the exchange would automatically create this the 1st time it is used. But until issue 176 is fixed we need to explicitly create it. We'll get 400 if it was already created by another instance
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/users/"+EXCHANGE_IAM_EMAIL, rootauth, []int{400}, `{"password": "foobar", "admin": false, "email": "`+EXCHANGE_IAM_EMAIL+`"}`, nil, false)
-		perfutils.ExchangeGet("orgs/"+org+"/users/iamapikey", userauth, nil, nil)
-	} else {
-		// Using ICP
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org, rootauth, []int{403}, `{ "label": "perf test org", "description": "blah blah" }`, nil, false)
-		// for ICP we can't play the game of associating our own org with another account, so we have to create/use a local exchange user. We'll get 400 if it was already created by another instance
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/users/"+EXCHANGE_IAM_EMAIL, rootauth, []int{400}, `{"password": "`+EXCHANGE_IAM_KEY+`", "admin": false, "email": "`+EXCHANGE_IAM_EMAIL+`"}`, nil, false)
-		perfutils.ExchangeGet("orgs/"+org+"/users/"+EXCHANGE_IAM_EMAIL, userauth, nil, nil)
+	// So the org and user are shared
+	if userId := manifest.EnsurePerfOrgAndUser(org, rootauth, userauth); userId != "" {
+		perfutils.ExchangeGet("orgs/"+org+"/users/"+userId, userauth, nil, nil)
 	}
 
-	// Create the primary/common svc that all the patterns use. All instances of this driver use this, so be tolerant of it existing
-	perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/services", userauth, []int{403}, `{"label": "svc", "public": true, "url": "`+svcurl+`", "version": "`+svcversion+`", "sharable": "singleton",
-	  "deployment": "{\"services\":{\"svc\":{\"image\":\"openhorizon/gps:1.2.3\"}}}", "deploymentSignature": "a", "arch": "`+svcarch+`" }`, nil, false)
+	// The definitions of the primary/common svc, the patterns, and the business policy, for a version of the svc (the publisher rolls out new ones)
+	svcBody := func(version, arch string) string {
+		clusterDeployment := ""
+		if clusterNodePct > 0 {
+			clusterDeployment = `"clusterDeployment": "{\"operatorYamlArchive\":\"H4sIAAAAAAAAA+3OMQ6CQBCF4Z1\"}", "clusterDeploymentSignature": "a", `
+		}
+		return `{"label": "svc", "public": true, "url": "` + svcurl + `", "version": "` + version + `", "sharable": "singleton",
+	  "deployment": "{\"services\":{\"svc\":{\"image\":\"openhorizon/gps_` + arch + `:` + version + `\"}}}", "deploymentSignature": "a", ` + clusterDeployment + `"arch": "` + arch + `" }`
+	}
+	// Each pattern leaves 1 of the arches of the fleet out (pattern 1 the last, which usually has the smallest weight), so the pattern searches
+	// have to filter out the nodes of that arch
+	patternArches := func(p int) []string {
+		if len(fleetArches) < 2 {
+			return fleetArches
+		}
+		left := len(fleetArches) - 1 - (p-1)%len(fleetArches)
+		return append(append([]string{}, fleetArches[:left]...), fleetArches[left+1:]...)
+	}
+	patternBody := func(p int, version string) string {
+		var services []string
+		for _, arch := range patternArches(p) {
+			services = append(services, `{ "serviceUrl": "`+svcurl+`", "serviceOrgid": "`+org+`", "serviceArch": "`+arch+`", "serviceVersions": [{ "version": "`+version+`" }] }`)
+		}
+		return `{"label": "pat", "public": false, "services": [` + strings.Join(services, ", ") + `],
+		"userInput": [{
+			"serviceOrgid": "` + org + `", "serviceUrl": "` + svcurl + `", "serviceArch": "", "serviceVersionRange": "[0.0.0,INFINITY)",
+			"inputs": [{ "name": "VERBOSE", "value": true }]
+		}] }`
+	}
+	buspolBody := func(version, arch string) string {
+		return `{"label": "buspol", "service": {"name": "` + svcurl + `", "org": "` + org + `", "arch": "` + arch + `", "serviceVersions": [{ "version": "` + version + `" }] },
+		"properties": [{"name":"purpose", "value":"testing", "type":"string"}], "constraints":["a == b"] }`
+	}
 
-	// For the creation of services and patterns, we will share them with every other instance on this host if hostname is set, so need to tolerate them already existing
-	var otherGoodHttpCodes []int
-	if hostname != "" {
-		otherGoodHttpCodes = []int{403}
+	// Create the primary/common svc that all the patterns use. All instances of this driver use this, so it is shared
+	manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + org + "/services/" + svcid, CreateMethod: http.MethodPost, CreatePath: "orgs/" + org + "/services", UpdateMethod: http.MethodPut, Auth: userauth, Shared: true,
+		Body: svcBody(svcversion, svcarch)})
+	for _, arch := range svcArches[1:] {
+		manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + org + "/services/" + svcurl + "_" + svcversion + "_" + arch, CreateMethod: http.MethodPost, CreatePath: "orgs/" + org + "/services", UpdateMethod: http.MethodPut, Auth: userauth, Shared: true,
+			Body: svcBody(svcversion, arch)})
 	}
 
+	// For the creation of services and patterns, we will share them with every other instance on this host if hostname is set
+	shared := hostname != ""
+
 	// Create extra services
 	for s := 1; s <= numSvcs; s++ {
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/services", userauth, otherGoodHttpCodes, `{"label": "svc", "public": true, "url": "`+svcurlbase+strconv.Itoa(s)+`", "version": "`+svcversion+`", "sharable": "singleton",
-		  "deployment": "{\"services\":{\"svc\":{\"image\":\"openhorizon/gps:1.2.3\"}}}", "deploymentSignature": "a", "arch": "`+svcarch+`" }`, nil, true)
+		mysvcurl := svcurlbase + strconv.Itoa(s)
+		manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + org + "/services/" + mysvcurl + "_" + svcversion + "_" + svcarch, CreateMethod: http.MethodPost, CreatePath: "orgs/" + org + "/services", UpdateMethod: http.MethodPut, Auth: userauth, Shared: shared, DoContinue: true,
+			Body: `{"label": "svc", "public": true, "url": "` + mysvcurl + `", "version": "` + svcversion + `", "sharable": "singleton",
+		  "deployment": "{\"services\":{\"svc\":{\"image\":\"openhorizon/gps:1.2.3\"}}}", "deploymentSignature": "a", "arch": "` + svcarch + `" }`})
 	}
 	// Create patterns p*, that all use the primary service
 	for p := 1; p <= numPatterns; p++ {
-		perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/patterns/"+patternbase+strconv.Itoa(p), userauth, otherGoodHttpCodes, `{"label": "pat", "public": false, "services": [{ "serviceUrl": "`+svcurl+`", "serviceOrgid": "`+org+`", "serviceArch": "`+svcarch+`", "serviceVersions": [{ "version": "`+svcversion+`" }] }],
-		"userInput": [{
-			"serviceOrgid": "`+org+`", "serviceUrl": "`+svcurl+`", "serviceArch": "", "serviceVersionRange": "[0.0.0,INFINITY)",
-			"inputs": [{ "name": "VERBOSE", "value": true }]
-		}] }`, nil, false)
+		manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + org + "/patterns/" + patternbase + strconv.Itoa(p), CreateMethod: http.MethodPost, UpdateMethod: http.MethodPut, Auth: userauth, Shared: shared,
+			Body: patternBody(p, svcversion)})
 	}
 
-	// Create 1 agbot to be able to create node msgs
-	perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/agbots/"+agbotid, userauth, []int{403}, `{"token": "`+agbottoken+`", "name": "agbot", "publicKey": "ABC"}`, nil, false)
+	// Create the synthetic catalog of services and patterns (if EX_PERF_CATALOG_SVCS is set), so the service and pattern apis are measured
+	// with production-like cardinality. It is shared like the extra services.
+	var catalog *perfutils.Catalog
+	if perfutils.ConfigInt("EX_PERF_CATALOG_SVCS") > 0 {
+		catalog = perfutils.GenerateCatalog(perfutils.CatalogSpecFromConfig(), org, catalogbase)
+		fmt.Println("Creating the catalog: " + catalog.String())
+		catalog.Ensure(manifest, userauth, shared)
+	}
 
-	//todo: add policy objects and use them below
+	// Create 1 agbot to be able to create node msgs. Its name is also our status record, so agbot.go knows when we are done
+	manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + org + "/agbots/" + agbotid, CreateMethod: http.MethodPut, UpdateMethod: http.MethodPut, Auth: userauth, Ignore: []string{"token"},
+		Body: `{"token": "` + agbottoken + `", "name": "` + perfutils.NODE_DRIVER_STATUS_PREFIX + perfutils.NODE_DRIVER_STATUS_RUNNING + `", "publicKey": "ABC"}`})
+	// Create the business policies for the primary service, that the policy nodes (the ones without a pattern) get their agreements for
+	if policyNodePct > 0 {
+		for i, arch := range fleetArches {
+			manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + org + "/business/policies/" + buspolbase + strconv.Itoa(i+1), CreateMethod: http.MethodPost, UpdateMethod: http.MethodPut, Auth: userauth,
+				Body: buspolBody(svcversion, arch)})
+		}
+	}
+	manifest.MarkSetupDone()
 
 	// =========== Node Creation and Registration =================================================
 
+	// Wait for all of the other instances to finish their setup, so the timed phases all start together
+	perfutils.WaitForStartBarrier(namebase)
+
 	// start timing now
-	perfutils.TotalOps = 0
+	perfutils.SetPhase("run")
+	perfutils.ResetTotalOps()
+	perfutils.ResetConnStats()
+	perfutils.ResetRouteStats()
 	t1 := time.Now()
 
-	for n := 1; n <= numNodes; n++ {
-		mynodeid := nodebase + strconv.Itoa(n)
-		mynodeauth := org + "/" + mynodeid + ":" + nodetoken
-
-		if createRegSleep > 0 {
-			time.Sleep(time.Duration(createRegSleep) * time.Millisecond)
+	// The arch and type of each node
+	nodeArches := perfutils.AssignArches(archMix, numNodes)
+	isCluster := func(n int) bool { return perfutils.SpreadEvenly(perfutils.PICK_CLUSTER_NODES, n, clusterNodePct) }
+	nodeType := func(n int) string {
+		if isCluster(n) {
+			return perfutils.NODE_TYPE_CLUSTER
 		}
-
-		// Make all the api calls a node makes during registration
+		return perfutils.NODE_TYPE_DEVICE
+	}
+	// The token and pattern (1 of p*, or 0 for the policy nodes) of each node, which change when the node churns
+	tokens := make([]string, numNodes+1)
+	nodePatterns := make([]int, numNodes+1)
+	nodeAuth := func(n int) perfutils.NodeToken {
+		return perfutils.NodeToken{Org: org, NodeId: nodebase + strconv.Itoa(n), Token: tokens[n]}
+	}
+	// nodePattern returns the org/pattern of the node, or "" if it is a policy node
+	nodePattern := func(n int) string {
+		if nodePatterns[n] == 0 {
+			return ""
+		}
+		return org + "/" + patternbase + strconv.Itoa(nodePatterns[n])
+	}
+	// deployable returns true if the service can be deployed to the node: it is a policy node (the business policy for its arch finds it), or
+	// its pattern has the service for its arch. The agbots do not make agreements with the other nodes, so they do not get 1.
+	deployable := func(n int) bool {
+		if nodePatterns[n] == 0 {
+			return true
+		}
+		for _, arch := range patternArches(nodePatterns[n]) {
+			if arch == nodeArches[n] {
+				return true
+			}
+		}
+		return false
+	}
+	// getPattern gets the node's pattern, like the agent does (policy nodes do not have 1)
+	getPattern := func(n int) {
+		if nodePatterns[n] > 0 {
+			perfutils.ExchangeGet("orgs/"+org+"/patterns/"+patternbase+strconv.Itoa(nodePatterns[n]), nodeAuth(n), []int{404}, nil)
+		}
+	}
+	// register makes all the api calls a node makes during registration
+	register := func(n int) {
+		mynodeid := nodebase + strconv.Itoa(n)
+		mynodeauth := nodeAuth(n)
 		perfutils.ExchangeGet("admin/version", mynodeauth, nil, nil)
-		perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+mynodeid, userauth, nil, `{"token": "`+nodetoken+`", "name": "pi", "pattern": "`+org+`/`+patternid+`", "arch": "`+svcarch+`", "publicKey": "ABC"}`, nil, false)
+		perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+mynodeid, userauth, nil, `{"token": "`+tokens[n]+`", "name": "pi", "nodeType": "`+nodeType(n)+`", "pattern": "`+nodePattern(n)+`", "arch": "`+nodeArches[n]+`", "publicKey": "ABC"}`, nil, false)
 		perfutils.ExchangeGet("orgs/"+org+"/nodes/"+mynodeid, mynodeauth, nil, nil)
 		perfutils.ExchangeGet("orgs/"+org, mynodeauth, nil, nil)
-		perfutils.ExchangeGet("orgs/"+org+"/patterns/"+patternid, mynodeauth, []int{404}, nil)
-		perfutils.ExchangeP(http.MethodPatch, "orgs/"+org+"/nodes/"+mynodeid, mynodeauth, nil, `{ "registeredServices": [{"url": "`+org+`/`+svcurl+`", "numAgreements": 1, "policy": "{blob}", "properties": [{"name": "arch", "value": "`+svcarch+`", "propType": "string", "op": "in"},{"name": "version", "value": "1.0.0", "propType": "version", "op": "in"}]}] }`, nil, true)
-		perfutils.ExchangeGet("orgs/"+org+"/patterns/"+patternid, mynodeauth, []int{404}, nil)
+		getPattern(n)
+		perfutils.ExchangeP(http.MethodPatch, "orgs/"+org+"/nodes/"+mynodeid, mynodeauth, nil, `{ "registeredServices": [{"url": "`+org+`/`+svcurl+`", "numAgreements": 1, "policy": "{blob}", "properties": [{"name": "arch", "value": "`+nodeArches[n]+`", "propType": "string", "op": "in"},{"name": "version", "value": "1.0.0", "propType": "version", "op": "in"}]}] }`, nil, true)
+		getPattern(n)
 		perfutils.ExchangeGet("orgs/"+org+"/services", mynodeauth, nil, nil)
 		perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+mynodeid+"/policy", mynodeauth, nil, `{ "properties": [{"name":"purpose", "value":"testing", "type":"string"}], "constraints":["a == b"] }`, nil, true)
+	}
+
+	for n := 1; n <= numNodes; n++ {
+		tokens[n] = nodetoken
+		nodePatterns[n] = 1 // all of the nodes start with the 1st pattern, except the policy nodes
+		if perfutils.SpreadEvenly(perfutils.PICK_POLICY_NODES, n, policyNodePct) {
+			nodePatterns[n] = 0
+		}
+
+		if createRegSleep > 0 {
+			time.Sleep(time.Duration(createRegSleep) * time.Millisecond)
+		}
+
+		register(n)
 
 		// Do not need to create msgs here to simulate agreement negotiation - agbot.go will do this when it finds the node in a search
 		/* for m := 1; m <= numMsgs; m++ {
@@ -214,7 +353,55 @@ func main() {
 	fmt.Printf("\nRunning %d heartbeats for %d nodes:\n", numHeartbeats, numNodes)
 	svcCheckCount := 0
 	versionCheckCount := 0
+	statusCount := 0
+	statuses := newStatusGenerator(org, svcurl, perfutils.ConfigInt("EX_PERF_STATUS_SVCS"), perfutils.ConfigInt("EX_PERF_STATUS_CONTAINERS"))
 	nextNodeAgreement := 1
+	errorsReportedHb := make([]int, numNodes+1) // the hb in which each node reported its errors (0 if it has none)
+	numErrorNodes := 0
+	wantsAgreement := make([]bool, numNodes+1) // the node's turn to get an agreement has come
+	hasAgreement := make([]bool, numNodes+1)
+	suspended := make([]bool, numNodes+1) // the node has seen that its service is suspended
+	churn := newChurner(numNodes, perfutils.ConfigInt("EX_PERF_CHURN_PCT"))
+	outage := newOutage(numHeartbeats)
+	rollouts := newRollout(svcversion, numNodes, numHeartbeats)
+	// Some nodes stop heartbeating for the rest of the run, so the agbots can verify they drop out of nodehealth and the pattern search
+	stalePct := perfutils.ConfigInt("EX_PERF_STALE_PCT")
+	staleAfterHb := perfutils.ConfigInt("EX_PERF_STALE_AFTER_HB")
+	isStale := func(n, h int) bool {
+		return stalePct > 0 && h >= staleAfterHb && perfutils.SpreadEvenly(perfutils.PICK_STALE_NODES, n, stalePct)
+	}
+	offline := func(n, h int) bool { return outage.isOffline(n, h) || isStale(n, h) }
+	// reconnect makes the calls the agent makes when it can reach the exchange again, before it resumes heartbeating. They are counted in ops
+	// (including the retries), and it returns how many of them failed, so the burst is measured apart from the admin's concurrent calls.
+	reconnect := func(n int, ops *int64) int {
+		mynodeid := nodebase + strconv.Itoa(n)
+		mynodeauth := perfutils.CountedCredentials{Credentials: nodeAuth(n), Ops: ops}
+		failed := 0
+		get := func(url string, goodHttpCodes ...int) {
+			if httpCode := perfutils.ExchangeGet(url, mynodeauth, goodHttpCodes, nil); httpCode != 200 && (len(goodHttpCodes) == 0 || httpCode != goodHttpCodes[0]) {
+				failed++
+			}
+		}
+		get("admin/version")
+		get("orgs/" + org + "/nodes/" + mynodeid)
+		get("orgs/" + org)
+		if nodePatterns[n] > 0 {
+			get("orgs/"+org+"/patterns/"+patternbase+strconv.Itoa(nodePatterns[n]), 404)
+		}
+		get("orgs/" + org + "/services")
+		get("orgs/" + org + "/nodes/" + mynodeid + "/policy")
+		get("orgs/"+org+"/nodes/"+mynodeid+"/msgs", 404)
+		return failed
+	}
+	// putAgreement gives node n an agreement for the version of the service
+	putAgreement := func(n int, version string) {
+		mynodeid := nodebase + strconv.Itoa(n)
+		perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+mynodeid+"/agreements/"+nodeagrbase+strconv.Itoa(n), nodeAuth(n), nil, `{"services": [], "agreementService": {"orgid": "`+org+`", "pattern": "`+nodePattern(n)+`", "url": "`+org+`/`+svcurl+`"}, "state": "negotiating"}`, nil, true)
+		hasAgreement[n] = true
+		rollouts.agreed(n, version)
+		rollouts.converged(n, true)
+	}
+	admin.start()
 	var iterDeltaTotal time.Duration = 0
 	var sleepTotal time.Duration = 0
 
@@ -224,30 +411,131 @@ func main() {
 		// We assume 1 hb of all the nodes takes nodeHbInterval seconds, so increment our other counts by that much
 		svcCheckCount += nodeHbInterval
 		versionCheckCount += nodeHbInterval
+		if statusInterval > 0 {
+			statusCount += nodeHbInterval
+		}
 
+		// The publisher rolls out a new version of the service: it posts it, and updates the patterns and business policy to it
+		if rollouts.due(h) {
+			version := rollouts.next()
+			fmt.Printf("Rolling out version %s of %s\n", version, svcurl)
+			for _, arch := range svcArches {
+				perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/services", userauth, []int{403, 409}, svcBody(version, arch), nil, true) // the other instances post it too
+			}
+			for p := 1; p <= numPatterns; p++ {
+				perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/patterns/"+patternbase+strconv.Itoa(p), userauth, nil, patternBody(p, version), nil, true)
+			}
+			if policyNodePct > 0 {
+				for i, arch := range fleetArches {
+					perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/business/policies/"+buspolbase+strconv.Itoa(i+1), userauth, nil, buspolBody(version, arch), nil, true)
+				}
+			}
+			rollouts.published(version, h)
+		}
+
+		// If the outage is over, all of its nodes reconnect at once
+		if outage.enabled() && h == outage.endHb {
+			outage.recover(numNodes, reconnect, func(n int) bool { return isStale(n, h) })
+		}
+
+		loopStart := time.Now()
+		onlineNodes := 0
 		for n := 1; n <= numNodes; n++ {
+			if offline(n, h) {
+				continue
+			}
+			onlineNodes++
 			mynodeid := nodebase + strconv.Itoa(n)
-			mynodeauth := org + "/" + mynodeid + ":" + nodetoken
+			mynodeauth := nodeAuth(n)
 
 			// These api methods are run every hb
 
 			// temporarily put this here to see if put node was the cause of the body length 0 error (it wasn't)
 			//perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+mynodeid, userauth, nil, `{"token": "`+nodetoken+`", "name": "pi", "pattern": "`+org+`/`+patternid+`", "arch": "`+svcarch+`", "publicKey": "ABC"}`, nil, true)
 
-			perfutils.ExchangeGet("orgs/"+org+"/nodes/"+mynodeid, mynodeauth, nil, nil)
-			perfutils.ExchangeGet("orgs/"+org+"/nodes/"+mynodeid+"/msgs", mynodeauth, []int{404}, nil)
+			if admin.configStates == nil {
+				perfutils.ExchangeGet("orgs/"+org+"/nodes/"+mynodeid, mynodeauth, nil, nil)
+			} else {
+				// Act on the config state of our service, like the agent does: cancel the agreement when it is suspended, and make it again when it is resumed
+				var nodeResp perfutils.ExchangeNodes
+				perfutils.ExchangeGet("orgs/"+org+"/nodes/"+mynodeid, mynodeauth, nil, &nodeResp)
+				nowSuspended := nodeResp.ServiceSuspended(org + "/" + svcurl)
+				admin.configStates.notice(n, nowSuspended)
+				if nowSuspended && !suspended[n] && hasAgreement[n] {
+					perfutils.ExchangeDelete("orgs/"+org+"/nodes/"+mynodeid+"/agreements/"+nodeagrbase+strconv.Itoa(n), mynodeauth, []int{404})
+					hasAgreement[n] = false
+				}
+				suspended[n] = nowSuspended
+			}
+			// A node whose agreement was cancelled (because its service was suspended, or it churned) gets a new one as soon as it can
+			if wantsAgreement[n] && !hasAgreement[n] && !suspended[n] && deployable(n) {
+				putAgreement(n, rollouts.nodeVersions[n])
+			}
+			if !rollouts.enabled() {
+				perfutils.ExchangeGet("orgs/"+org+"/nodes/"+mynodeid+"/msgs", mynodeauth, []int{404}, nil)
+			} else {
+				// Reply to the proposals of the agbots that renegotiate our agreement after a rollout, like the agent does: accept the ones for
+				// the latest version by replacing our agreement with 1 for it, and decline the others (or all of them if we have no agreement)
+				var msgResp perfutils.ExchangeMsgs
+				perfutils.ExchangeGet("orgs/"+org+"/nodes/"+mynodeid+"/msgs", mynodeauth, []int{404}, &msgResp)
+				for _, m := range msgResp.Messages {
+					kind, version, ok := perfutils.ParseAgreementMsg(m.Message)
+					if !ok || kind != perfutils.MSG_PROPOSAL {
+						continue
+					}
+					reply := perfutils.MSG_DECLINED
+					if hasAgreement[n] && rollouts.isLatest(version) {
+						if rollouts.agreedVersions[n] != version {
+							perfutils.ExchangeDelete("orgs/"+org+"/nodes/"+mynodeid+"/agreements/"+nodeagrbase+strconv.Itoa(n), mynodeauth, []int{404})
+							putAgreement(n, version)
+						}
+						reply = perfutils.MSG_AGREED
+					}
+					perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/agbots/"+perfutils.TrimOrg(m.AgbotId)+"/msgs", mynodeauth, []int{404}, `{"message": "`+perfutils.AgreementMsg(reply, version)+`", "ttl": 3600}`, nil, true)
+					perfutils.ExchangeDelete("orgs/"+org+"/nodes/"+mynodeid+"/msgs/"+strconv.Itoa(m.MsgId), mynodeauth, []int{404})
+				}
+			}
 			perfutils.ExchangeP(http.MethodPost, "orgs/"+org+"/nodes/"+mynodeid+"/heartbeat", mynodeauth, nil, nil, nil, true)
 			perfutils.ExchangeGet("orgs/"+org+"/nodes/"+mynodeid+"/policy", mynodeauth, nil, nil)
 
 			// If it is time to do a service check, do that
 			if svcCheckCount >= svcCheckInterval {
-				perfutils.ExchangeGet("orgs/"+org+"/services", mynodeauth, []int{404}, nil)
+				if !rollouts.enabled() {
+					perfutils.ExchangeGet("orgs/"+org+"/services", mynodeauth, []int{404}, nil)
+				} else {
+					// Find the newest version that was rolled out. The node has converged on it once it has an agreement for it (if it needs 1),
+					// which the agbots propose when they renegotiate it (see above).
+					var svcResp exchangeServices
+					perfutils.ExchangeGet("orgs/"+org+"/services", mynodeauth, []int{404}, &svcResp)
+					if latest := rollouts.latest(svcResp, svcurl, nodeArches[n]); latest != "" && rollouts.upgrade(n, latest) {
+						rollouts.converged(n, hasAgreement[n])
+					}
+				}
 			}
 
 			// If it is time to do a version check, do that
 			if versionCheckCount >= versionCheckInterval {
 				perfutils.ExchangeGet("admin/version", mynodeauth, nil, nil)
 			}
+
+			// If it is time to report the status of the running containers, do that
+			if statusInterval > 0 && statusCount >= statusInterval {
+				agreementid := ""
+				if hasAgreement[n] {
+					agreementid = nodeagrbase + strconv.Itoa(n)
+				}
+				perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+mynodeid+"/status", mynodeauth, nil, statuses.body(n, agreementid, rollouts.nodeVersions[n], nodeArches[n], isCluster(n)), nil, true)
+			}
+
+			// If it is time for the node to clear its errors (its service deployed on a retry), do that
+			if errorClearHbs > 0 && errorsReportedHb[n] > 0 && h >= errorsReportedHb[n]+errorClearHbs {
+				perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+mynodeid+"/errors", mynodeauth, nil, `{"errors": []}`, nil, true)
+				errorsReportedHb[n] = 0
+			}
+		}
+		outage.recordHb(h, time.Since(loopStart), onlineNodes)
+		if rollouts.enabled() {
+			rollouts.fleetConverged(func(n int) bool { return offline(n, h) })
 		}
 
 		// Give some (numNodeAgreements) nodes an agreement, so they won't be returned again in the agbot searches
@@ -256,13 +544,57 @@ func main() {
 			fmt.Printf("creating agreements for %s[%d - %d]", nodebase, nextNodeAgreement, toNodeAgreement) // was Debug()
 			for n := nextNodeAgreement; n <= toNodeAgreement; n++ {
 				mynodeid := nodebase + strconv.Itoa(n)
-				mynodeauth := org + "/" + mynodeid + ":" + nodetoken
-				agreementid := nodeagrbase + strconv.Itoa(n)
-				perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+mynodeid+"/agreements/"+agreementid, mynodeauth, nil, `{"services": [], "agreementService": {"orgid": "`+org+`", "pattern": "`+org+`/`+patternid+`", "url": "`+org+`/`+svcurl+`"}, "state": "negotiating"}`, nil, true)
+				mynodeauth := nodeAuth(n)
+				wantsAgreement[n] = true
+				if suspended[n] || offline(n, h) || !deployable(n) {
+					continue // the node will get its agreement when its service is resumed, it is back online (stale nodes never are), or it switches to a pattern for its arch
+				}
+				putAgreement(n, rollouts.nodeVersions[n])
+
+				// Some of the nodes fail to deploy the service, and report the errors
+				if perfutils.SpreadEvenly(perfutils.PICK_ERROR_NODES, n, errorPct) {
+					perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+mynodeid+"/errors", mynodeauth, nil, nodeErrorsBody(n, org, svcurl, svcversion, nodeagrbase+strconv.Itoa(n)), nil, true)
+					errorsReportedHb[n] = h
+					numErrorNodes++
+				}
 			}
 			nextNodeAgreement += numNodeAgreements
 		}
 
+		// Churn some of the nodes, like in a real fleet
+		for _, c := range churn.next(func(n int) bool { return offline(n, h) }) {
+			mynodeid := nodebase + strconv.Itoa(c.node)
+			switch c.action {
+			case churnReregister:
+				if hasAgreement[c.node] {
+					perfutils.ExchangeDelete("orgs/"+org+"/nodes/"+mynodeid+"/agreements/"+nodeagrbase+strconv.Itoa(c.node), nodeAuth(c.node), []int{404})
+					hasAgreement[c.node] = false
+				}
+				perfutils.ExchangeDelete("orgs/"+org+"/nodes/"+mynodeid, userauth, nil)
+				errorsReportedHb[c.node] = 0 // they were deleted with the node
+				fallthrough
+			case churnNewToken:
+				tokens[c.node] = nodetoken + "-" + strconv.Itoa(h) // a node churns at most once per hb, so this is a new token
+				register(c.node)
+				// Registering sets the registered services again, so the service is active
+				suspended[c.node] = false
+				if admin.configStates != nil {
+					admin.configStates.reset(c.node)
+				}
+			case churnSwitch:
+				if numPatterns > 1 && nodePatterns[c.node] > 0 {
+					// The agreement for the old pattern is cancelled, so the agbots have to evaluate the node again
+					nodePatterns[c.node] = nodePatterns[c.node]%numPatterns + 1
+					perfutils.ExchangeP(http.MethodPatch, "orgs/"+org+"/nodes/"+mynodeid, userauth, nil, `{"pattern": "`+nodePattern(c.node)+`"}`, nil, true)
+					if hasAgreement[c.node] {
+						perfutils.ExchangeDelete("orgs/"+org+"/nodes/"+mynodeid+"/agreements/"+nodeagrbase+strconv.Itoa(c.node), nodeAuth(c.node), []int{404})
+						hasAgreement[c.node] = false
+					}
+				}
+				perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+mynodeid+"/policy", nodeAuth(c.node), nil, `{ "properties": [{"name":"purpose", "value":"testing", "type":"string"}, {"name":"churn", "value":`+strconv.Itoa(h)+`, "type":"int"}], "constraints":["a == b"] }`, nil, true)
+			}
+		}
+
 		// Reset our counters if appropriate
 		if svcCheckCount >= svcCheckInterval {
 			svcCheckCount = 0
@@ -270,25 +602,34 @@ func main() {
 		if versionCheckCount >= versionCheckInterval {
 			versionCheckCount = 0
 		}
+		if statusInterval > 0 && statusCount >= statusInterval {
+			statusCount = 0
+		}
 
 		// If we completed this iteration in less than nodeHbInterval, sleep the rest of the time (unless we are not supposed to)
 		// Note: need to do all of the time calculations in Durations (int64 nanaseconds), and only convert to float64 seconds to display
 		iterTime := time.Since(startIteration)
 		iterDelta := perfutils.Seconds2Duration(nodeHbInterval) - iterTime
 		iterDeltaTotal += iterDelta
-		if iterDelta > 0 && os.Getenv("EX_NODE_NO_SLEEP") == "" {
+		if iterDelta > 0 && !perfutils.ConfigBool("EX_NODE_NO_SLEEP") {
 			fmt.Printf("Sleeping for %f seconds at the end of node heartbeat %d of %d because loop iteration finished early\n", iterDelta.Seconds(), h, numHeartbeats)
 			sleepTotal += iterDelta
 			time.Sleep(iterDelta)
 		}
 	}
 
+	admin.stop()
+
+	// Let the agbot.go instances know our nodes are done, so they don't have to wait for a fixed number of agreement checks
+	perfutils.PublishNodeDriverStatus(org, agbotid, agbottoken, perfutils.NODE_DRIVER_STATUS_DONE, userauth)
+
 	// =========== Unregistration and Clean up ===========================================
 
+	perfutils.SetPhase("cleanup")
 	fmt.Println("\nUnregistering nodes and cleaning up from node test:")
 	for n := 1; n <= numNodes; n++ {
 		mynodeid := nodebase + strconv.Itoa(n)
-		mynodeauth := org + "/" + mynodeid + ":" + nodetoken
+		mynodeauth := nodeAuth(n)
 
 		// Update node status when the services stop running
 		perfutils.ExchangeP(http.MethodPut, "orgs/"+org+"/nodes/"+mynodeid+"/status", mynodeauth, nil, `{ "connectivity": {"firmware.bluehorizon.network": true}, "services": [] }`, nil, true)
@@ -297,11 +638,18 @@ func main() {
 	// Don't need to delete the msgs, they'll get deleted with the node
 
 	// We are sharing services and patterns with every other instance on this host if hostname is set, so need to tolerate them already being deleted
-	otherGoodHttpCodes = nil // reset it
+	var otherGoodHttpCodes []int
 	if hostname != "" {
 		otherGoodHttpCodes = []int{404}
 	}
 
+	// Delete the business policies
+	if policyNodePct > 0 {
+		for i := range fleetArches {
+			perfutils.ExchangeDelete("orgs/"+org+"/business/policies/"+buspolbase+strconv.Itoa(i+1), userauth, nil)
+		}
+	}
+
 	// Delete patterns
 	for p := 1; p <= numPatterns; p++ {
 		mypatid := patternbase + strconv.Itoa(p)
@@ -314,8 +662,18 @@ func main() {
 		perfutils.ExchangeDelete("orgs/"+org+"/services/"+mysvcid, userauth, otherGoodHttpCodes)
 	}
 
-	// Delete primary service
-	perfutils.ExchangeDelete("orgs/"+org+"/services/"+svcid, userauth, []int{404})
+	// Delete the catalog
+	if catalog != nil {
+		catalog.Delete(userauth, otherGoodHttpCodes)
+	}
+
+	// Delete primary service for each arch, and the versions of it that were rolled out
+	for _, arch := range svcArches {
+		perfutils.ExchangeDelete("orgs/"+org+"/services/"+svcurl+"_"+svcversion+"_"+arch, userauth, []int{404})
+		for _, v := range rollouts.versions {
+			perfutils.ExchangeDelete("orgs/"+org+"/services/"+svcurl+"_"+v.version+"_"+arch, userauth, []int{404})
+		}
+	}
 
 	// Delete nodes
 	for n := 1; n <= numNodes; n++ {
@@ -327,6 +685,7 @@ func main() {
 	perfutils.ExchangeDelete("orgs/"+org+"/agbots/"+agbotid, userauth, nil)
 
 	// Can not delete the user or org in case other instances of this script are still using it. Whoever calls this script must delete it
+	manifest.Remove() // we cleaned up everything we created, so there is nothing to resume or tear down
 
 	// Note: need to do all of the time calculations in Durations (int64 nanaseconds), and only convert to float64 seconds to display
 	iterDeltaAvg := iterDeltaTotal / time.Duration(numHeartbeats)
@@ -334,9 +693,58 @@ func main() {
 	tDelta := t2.Sub(t1) // this is a Duration
 	activeTime := tDelta - sleepTotal
 	activeTimeSecs := activeTime.Seconds() // this is float64
-	opsAvg := activeTimeSecs / float64(perfutils.TotalOps)
+	// the admin ran concurrently (even while we slept), so its calls are counted separately
+	numOps := perfutils.GetTotalOps() - admin.getOps()
+	opsAvg := activeTimeSecs / float64(numOps)
 	sumMsg := fmt.Sprintf("Simulated %d nodes for %d heartbeats\nStart time: %s, End time: %s, wall clock duration=%f s\nOverall: active time: %f s, num ops=%d, avg=%f s/op, avg iteration delta=%f s",
-		numNodes, numHeartbeats, t1.Format("2006.01.02 15:04:05"), t2.Format("2006.01.02 15:04:05"), tDelta.Seconds(), activeTimeSecs, perfutils.TotalOps, opsAvg, iterDeltaAvg.Seconds())
+		numNodes, numHeartbeats, t1.Format("2006.01.02 15:04:05"), t2.Format("2006.01.02 15:04:05"), tDelta.Seconds(), activeTimeSecs, numOps, opsAvg, iterDeltaAvg.Seconds())
+	if errorPct > 0 {
+		sumMsg += fmt.Sprintf("\nNode errors: %d nodes reported errors (%d%%), cleared after %d heartbeats", numErrorNodes, errorPct, errorClearHbs)
+	}
+	if statusInterval > 0 {
+		sumMsg += "\n" + statuses.String()
+	}
+	if outage.enabled() {
+		sumMsg += "\n" + outage.String()
+	}
+	if rollouts.enabled() {
+		sumMsg += "\n" + rollouts.String()
+	}
+	if len(fleetArches) > 1 || clusterNodePct > 0 {
+		archCounts := map[string]int{}
+		numClusters := 0
+		numUndeployable := 0
+		for n := 1; n <= numNodes; n++ {
+			archCounts[nodeArches[n]]++
+			if isCluster(n) {
+				numClusters++
+			}
+			if !deployable(n) {
+				numUndeployable++
+			}
+		}
+		var archStrs []string
+		for _, arch := range fleetArches {
+			archStrs = append(archStrs, fmt.Sprintf("%s=%d", arch, archCounts[arch]))
+		}
+		sumMsg += fmt.Sprintf("\nFleet: %s, devices=%d, clusters=%d, nodes whose pattern does not have their arch=%d", strings.Join(archStrs, ", "), numNodes-numClusters, numClusters, numUndeployable)
+	}
+	if stalePct > 0 {
+		numStale := 0
+		for n := 1; n <= numNodes; n++ {
+			if perfutils.SpreadEvenly(perfutils.PICK_STALE_NODES, n, stalePct) {
+				numStale++
+			}
+		}
+		sumMsg += fmt.Sprintf("\nStale nodes: %d nodes (%d%%) stopped heartbeating at heartbeat %d", numStale, stalePct, staleAfterHb)
+	}
+	if churn.perHb > 0 {
+		sumMsg += "\n" + churn.String()
+	}
+	if admin.enabled() {
+		sumMsg += "\n" + admin.String()
+	}
+	sumMsg += "\n" + perfutils.GetConnStats().String() + "\n" + perfutils.RouteReport()
 
 	perfutils.Append2File(perfutils.EX_PERF_REPORT_FILE, sumMsg+"\n")
 	fmt.Println("\n" + sumMsg)
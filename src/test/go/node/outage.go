@@ -0,0 +1,121 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+// A heartbeat's time per node must be within this factor of the time before the outage for the exchange to be back in a steady state
+const steadyStateFactor = 1.1
+
+// outage simulates a site losing connectivity: a percent of the nodes stop making api calls for a window of heartbeats, and then all
+// reconnect at the same instant (the thundering herd), replaying the checks the agent makes when it reconnects
+type outage struct {
+	pct     int
+	startHb int // the 1st heartbeat the nodes miss
+	endHb   int // the heartbeat in which they reconnect
+
+	baselineTotal time.Duration // the per node time of the heartbeats before the outage
+	baselineHbs   int
+
+	numNodes    int
+	burstCalls  int
+	burstErrors int
+	burstTime   time.Duration // from when the nodes reconnected until they all finished their checks
+	latencies   []time.Duration
+	released    time.Time
+	steadyAfter time.Duration // from when the nodes reconnected until the end of the 1st heartbeat back in a steady state (0 if not yet)
+}
+
+func newOutage(numHeartbeats int) *outage {
+	o := &outage{pct: perfutils.ConfigInt("EX_PERF_OUTAGE_PCT"), startHb: perfutils.ConfigInt("EX_PERF_OUTAGE_START_HB")}
+	o.endHb = o.startHb + perfutils.ConfigInt("EX_PERF_OUTAGE_HBS")
+	if o.enabled() && (o.startHb < 2 || o.endHb > numHeartbeats) {
+		perfutils.Fatal(perfutils.CLI_INPUT_ERROR, "the outage (heartbeats %d to %d) must start after the 1st heartbeat and end before the last of the %d heartbeats", o.startHb, o.endHb-1, numHeartbeats)
+	}
+	return o
+}
+
+func (o *outage) enabled() bool {
+	return o.pct > 0
+}
+
+// isOffline returns true if node n can not reach the exchange in heartbeat h
+func (o *outage) isOffline(n, h int) bool {
+	return o.enabled() && h >= o.startHb && h < o.endHb && perfutils.SpreadEvenly(perfutils.PICK_OUTAGE_NODES, n, o.pct)
+}
+
+// recover releases all of the nodes that were offline at the same instant (except the ones skip returns true for), each running reconnect
+// concurrently, and measures the burst. reconnect counts its calls in ops and returns how many failed, because the other actors (e.g. the
+// admin) make calls at the same time, so the process-wide counts can not be used.
+func (o *outage) recover(numNodes int, reconnect func(n int, ops *int64) int, skip func(n int) bool) {
+	var nodes []int
+	for n := 1; n <= numNodes; n++ {
+		if perfutils.SpreadEvenly(perfutils.PICK_OUTAGE_NODES, n, o.pct) && !skip(n) {
+			nodes = append(nodes, n)
+		}
+	}
+	fmt.Printf("Reconnecting %d nodes at once after the outage\n", len(nodes))
+
+	o.numNodes = len(nodes)
+	var calls, errors int64
+	release := make(chan struct{})
+	latencies := make([]time.Duration, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i, n int) {
+			defer wg.Done()
+			<-release
+			start := time.Now()
+			atomic.AddInt64(&errors, int64(reconnect(n, &calls)))
+			latencies[i] = time.Since(start)
+		}(i, n)
+	}
+	o.released = time.Now()
+	close(release)
+	wg.Wait()
+	o.burstTime = time.Since(o.released)
+	o.latencies = latencies
+	o.burstCalls = int(calls)
+	o.burstErrors = int(errors)
+}
+
+// recordHb records how long heartbeat h took per node that was online, to find out when the exchange is back in a steady state
+func (o *outage) recordHb(h int, loopTime time.Duration, onlineNodes int) {
+	if !o.enabled() || onlineNodes == 0 {
+		return
+	}
+	perNode := loopTime / time.Duration(onlineNodes)
+	perfutils.Verbose("heartbeat %d: %.3f ms per node for %d nodes", h, perfutils.Millis(perNode), onlineNodes)
+	if h < o.startHb {
+		o.baselineTotal += perNode
+		o.baselineHbs++
+	} else if h >= o.endHb && o.steadyAfter == 0 && float64(perNode) <= float64(o.baseline())*steadyStateFactor {
+		o.steadyAfter = time.Since(o.released)
+	}
+}
+
+func (o *outage) baseline() time.Duration {
+	if o.baselineHbs == 0 {
+		return 0
+	}
+	return o.baselineTotal / time.Duration(o.baselineHbs)
+}
+
+func (o *outage) String() string {
+	errorPct := 0.0
+	if o.burstCalls > 0 {
+		errorPct = 100 * float64(o.burstErrors) / float64(o.burstCalls)
+	}
+	steady := "not reached"
+	if o.steadyAfter > 0 {
+		steady = fmt.Sprintf("%.3f s", o.steadyAfter.Seconds())
+	}
+	return fmt.Sprintf("Outage: %d nodes offline for heartbeats %d to %d, recovery burst: %d calls in %.3f s, errors=%d (%.1f%%), time to steady state=%s\nOutage recovery per node: %s",
+		o.numNodes, o.startHb, o.endHb-1, o.burstCalls, o.burstTime.Seconds(), o.burstErrors, errorPct, steady, perfutils.ComputeLatencyStats(o.latencies))
+}
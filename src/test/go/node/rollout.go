@@ -0,0 +1,192 @@
+package node
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+// The services in the response of GET orgs/{orgid}/services, with the fields the nodes need to find the newest version of theirs
+type exchangeServices struct {
+	Services map[string]struct {
+		Url     string `json:"url"`
+		Version string `json:"version"`
+		Arch    string `json:"arch"`
+	} `json:"services"`
+}
+
+// rolloutVersion is 1 version the publisher rolled out, and how the fleet converged on it
+type rolloutVersion struct {
+	version    string
+	hb         int
+	published  time.Time
+	converged  int             // nodes running it (with an agreement for it, if they want 1)
+	latencies  []time.Duration // from when it was published until each node converged on it
+	fleetTime  time.Duration   // until all of the online nodes converged on it (0 if they did not before the next rollout or the end)
+	fleetNodes int             // the nodes that were online then
+}
+
+// rollout simulates a publisher that posts new versions of the service mid-run, and updates the patterns and business policy to them. The
+// nodes find the new version in their service checks, and the agbots renegotiate their agreements: they propose agreements for the new
+// version in node msgs (see agbot/renegotiate.go), which the nodes accept by replacing their agreement.
+type rollout struct {
+	startHb  int // the heartbeat of the 1st rollout (0 means there are none)
+	everyHbs int // heartbeats between rollouts (0 means there is only 1)
+	numNodes int
+
+	baseVersion    string
+	versions       []*rolloutVersion
+	nodeVersions   []string        // the version each node runs
+	agreedVersions []string        // the version of the agreement of each node
+	nodeDone       []bool          // the node converged on the latest version
+	doneAfter      []time.Duration // how long after the latest version was published the node converged on it
+}
+
+func newRollout(svcversion string, numNodes, numHeartbeats int) *rollout {
+	r := makeRollout(perfutils.ConfigInt("EX_PERF_ROLLOUT_HB"), perfutils.ConfigInt("EX_PERF_ROLLOUT_EVERY_HBS"), svcversion, numNodes)
+	if r.enabled() && (r.startHb < 2 || r.startHb > numHeartbeats) {
+		perfutils.Fatal(perfutils.CLI_INPUT_ERROR, "the 1st rollout (heartbeat %d) must be after the 1st heartbeat and not after the last of the %d heartbeats", r.startHb, numHeartbeats)
+	}
+	return r
+}
+
+// makeRollout returns the rollouts, with all of the nodes running svcversion, with an agreement for it if they have 1
+func makeRollout(startHb, everyHbs int, svcversion string, numNodes int) *rollout {
+	r := &rollout{startHb: startHb, everyHbs: everyHbs, numNodes: numNodes, baseVersion: svcversion, nodeVersions: make([]string, numNodes+1),
+		agreedVersions: make([]string, numNodes+1), nodeDone: make([]bool, numNodes+1), doneAfter: make([]time.Duration, numNodes+1)}
+	for n := 1; n <= numNodes; n++ {
+		r.nodeVersions[n] = svcversion
+		r.agreedVersions[n] = svcversion
+		r.nodeDone[n] = true
+	}
+	return r
+}
+
+func (r *rollout) enabled() bool {
+	return r.startHb > 0
+}
+
+// due returns true if the publisher rolls out a new version in heartbeat h
+func (r *rollout) due(h int) bool {
+	if !r.enabled() || h < r.startHb {
+		return false
+	}
+	return h == r.startHb || (r.everyHbs > 0 && (h-r.startHb)%r.everyHbs == 0)
+}
+
+// next returns the version of the next rollout: the patch level of the base version, incremented once per rollout
+func (r *rollout) next() string {
+	parts := strings.Split(r.baseVersion, ".")
+	patch, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		perfutils.Fatal(perfutils.CLI_INPUT_ERROR, "service version %s does not end in a number", r.baseVersion)
+	}
+	parts[len(parts)-1] = strconv.Itoa(patch + len(r.versions) + 1)
+	return strings.Join(parts, ".")
+}
+
+// published records that the publisher rolled out the version (the service, patterns, and business policy are all updated) in heartbeat h
+func (r *rollout) published(version string, h int) {
+	r.versions = append(r.versions, &rolloutVersion{version: version, hb: h, published: time.Now()})
+	for n := 1; n <= r.numNodes; n++ {
+		r.nodeDone[n] = false
+	}
+}
+
+// latest returns the newest rolled out version of the service in the response of GET services, or "" if there is none
+func (r *rollout) latest(resp exchangeServices, svcurl, svcarch string) string {
+	newest := -1
+	for _, s := range resp.Services {
+		if s.Url != svcurl || s.Arch != svcarch {
+			continue
+		}
+		for i, v := range r.versions {
+			if v.version == s.Version && i > newest {
+				newest = i
+			}
+		}
+	}
+	if newest < 0 {
+		return ""
+	}
+	return r.versions[newest].version
+}
+
+// isLatest returns true if the version is the latest that was rolled out
+func (r *rollout) isLatest(version string) bool {
+	return len(r.versions) > 0 && r.versions[len(r.versions)-1].version == version
+}
+
+// upgrade records that node n runs the version now. It returns false if it already did.
+func (r *rollout) upgrade(n int, version string) bool {
+	if r.nodeVersions[n] == version {
+		return false
+	}
+	r.nodeVersions[n] = version
+	return true
+}
+
+// agreed records that node n has an agreement for the version now
+func (r *rollout) agreed(n int, version string) {
+	r.agreedVersions[n] = version
+}
+
+// converged records that node n converged on the latest version, if it runs it, and has an agreement for it if it needs 1
+func (r *rollout) converged(n int, needsAgreement bool) {
+	if len(r.versions) == 0 || r.nodeDone[n] {
+		return
+	}
+	v := r.versions[len(r.versions)-1]
+	if r.nodeVersions[n] != v.version || (needsAgreement && r.agreedVersions[n] != v.version) {
+		return
+	}
+	r.nodeDone[n] = true
+	r.doneAfter[n] = time.Since(v.published)
+	v.converged++
+	v.latencies = append(v.latencies, r.doneAfter[n])
+}
+
+// fleetConverged records how long the fleet took to converge on the latest version once all of the nodes that are not offline have: when
+// the last of them converged. The offline nodes (stale, or in an outage) can not converge, so they do not hold the fleet back.
+func (r *rollout) fleetConverged(offline func(n int) bool) {
+	if len(r.versions) == 0 {
+		return
+	}
+	v := r.versions[len(r.versions)-1]
+	if v.fleetTime > 0 {
+		return
+	}
+	var last time.Duration
+	online := 0
+	for n := 1; n <= r.numNodes; n++ {
+		if offline(n) {
+			continue
+		}
+		if !r.nodeDone[n] {
+			return
+		}
+		online++
+		if r.doneAfter[n] > last {
+			last = r.doneAfter[n]
+		}
+	}
+	if online > 0 {
+		v.fleetTime, v.fleetNodes = last, online
+	}
+}
+
+func (r *rollout) String() string {
+	str := fmt.Sprintf("Rollout: %d versions rolled out, starting at heartbeat %d", len(r.versions), r.startHb)
+	for _, v := range r.versions {
+		fleet := "fleet not converged"
+		if v.fleetTime > 0 {
+			fleet = fmt.Sprintf("fleet of the %d online nodes converged in %.3f s", v.fleetNodes, v.fleetTime.Seconds())
+		}
+		str += fmt.Sprintf("\nRollout of %s (heartbeat %d): %d of %d nodes converged, %s, per node: %s",
+			v.version, v.hb, v.converged, r.numNodes, fleet, perfutils.ComputeLatencyStats(v.latencies))
+	}
+	return str
+}
@@ -0,0 +1,112 @@
+package node
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRolloutDue(t *testing.T) {
+	for _, tc := range []struct {
+		startHb, everyHbs int
+		due               []int
+	}{
+		{0, 0, nil},
+		{0, 3, nil},
+		{5, 0, []int{5}},
+		{5, 3, []int{5, 8, 11, 14}},
+		{2, 1, []int{2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}},
+	} {
+		r := makeRollout(tc.startHb, tc.everyHbs, "1.2.3", 1)
+		var due []int
+		for h := 1; h <= 15; h++ {
+			if r.due(h) {
+				due = append(due, h)
+			}
+		}
+		if len(due) != len(tc.due) {
+			t.Errorf("rollouts starting at %d every %d are due in heartbeats %v, expected %v", tc.startHb, tc.everyHbs, due, tc.due)
+			continue
+		}
+		for i := range due {
+			if due[i] != tc.due[i] {
+				t.Errorf("rollouts starting at %d every %d are due in heartbeats %v, expected %v", tc.startHb, tc.everyHbs, due, tc.due)
+				break
+			}
+		}
+	}
+}
+
+func TestRolloutNext(t *testing.T) {
+	r := makeRollout(2, 1, "1.2.3", 1)
+	for h, expected := range []string{"1.2.4", "1.2.5", "1.2.6"} {
+		version := r.next()
+		if version != expected {
+			t.Errorf("rollout %d is version %s, expected %s", h+1, version, expected)
+		}
+		if r.next() != version {
+			t.Errorf("next() changed before version %s was published", version)
+		}
+		r.published(version, h+2)
+	}
+	r.baseVersion = "2.0.9"
+	if version := r.next(); version != "2.0.13" {
+		t.Errorf("the 4th rollout of 2.0.9 is version %s, expected 2.0.13", version)
+	}
+}
+
+func TestRolloutLatest(t *testing.T) {
+	r := makeRollout(2, 1, "1.2.3", 1)
+	var resp exchangeServices
+	body := `{"services": {
+		"org/svc_1.2.3_amd64": {"url": "svc", "version": "1.2.3", "arch": "amd64"},
+		"org/svc_1.2.4_amd64": {"url": "svc", "version": "1.2.4", "arch": "amd64"},
+		"org/svc_1.2.5_amd64": {"url": "svc", "version": "1.2.5", "arch": "amd64"},
+		"org/svc_1.2.4_arm64": {"url": "svc", "version": "1.2.4", "arch": "arm64"},
+		"org/other_1.2.9_amd64": {"url": "other", "version": "1.2.9", "arch": "amd64"}
+	}}`
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if latest := r.latest(resp, "svc", "amd64"); latest != "" {
+		t.Errorf("latest() is %s before any rollout, expected none", latest)
+	}
+	r.published(r.next(), 2) // 1.2.4
+	r.published(r.next(), 3) // 1.2.5
+	for _, tc := range []struct{ url, arch, latest string }{
+		{"svc", "amd64", "1.2.5"},
+		{"svc", "arm64", "1.2.4"}, // 1.2.5 is not posted for arm64 yet
+		{"svc", "s390x", ""},
+		{"other", "amd64", ""}, // 1.2.9 was not rolled out
+	} {
+		if latest := r.latest(resp, tc.url, tc.arch); latest != tc.latest {
+			t.Errorf("latest() of %s for %s is %q, expected %q", tc.url, tc.arch, latest, tc.latest)
+		}
+	}
+	if r.isLatest("1.2.4") || !r.isLatest("1.2.5") {
+		t.Error("isLatest() is wrong")
+	}
+}
+
+func TestRolloutFleetConverged(t *testing.T) {
+	r := makeRollout(2, 0, "1.2.3", 4)
+	r.published(r.next(), 2)
+	offline := func(n int) bool { return n == 4 }
+	for n := 1; n <= 3; n++ {
+		r.upgrade(n, "1.2.4")
+	}
+	r.converged(1, false)
+	r.converged(2, true) // its agreement is still for 1.2.3
+	r.converged(3, true)
+	r.fleetConverged(offline)
+	if v := r.versions[0]; v.converged != 1 || v.fleetTime != 0 {
+		t.Fatalf("%d nodes converged and the fleet converged in %v, expected 1 and not converged", v.converged, v.fleetTime)
+	}
+	r.agreed(2, "1.2.4")
+	r.converged(2, true)
+	r.agreed(3, "1.2.4")
+	r.converged(3, true)
+	r.fleetConverged(offline)
+	if v := r.versions[0]; v.converged != 3 || v.fleetTime == 0 || v.fleetNodes != 3 || v.fleetTime != r.doneAfter[3] {
+		t.Errorf("%d nodes converged and the fleet of %d converged in %v, expected 3 nodes, without offline node 4, in %v", v.converged, v.fleetNodes, v.fleetTime, r.doneAfter[3])
+	}
+}
@@ -0,0 +1,101 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+// The body of PUT orgs/{orgid}/nodes/{id}/status, as anax sends it
+type nodeStatus struct {
+	Connectivity map[string]bool `json:"connectivity"`
+	Services     []serviceStatus `json:"services"`
+}
+
+type serviceStatus struct {
+	AgreementId     string                 `json:"agreementId"` // empty for the required services, which are not in an agreement themselves
+	ServiceUrl      string                 `json:"serviceUrl"`
+	Orgid           string                 `json:"orgid"`
+	Version         string                 `json:"version"`
+	Arch            string                 `json:"arch"`
+	ContainerStatus []containerStatus      `json:"containerStatus"`
+	OperatorStatus  map[string]interface{} `json:"operatorStatus,omitempty"` // the status of the operator, for the services of edge clusters
+}
+
+type containerStatus struct {
+	Name    string `json:"name"`
+	Image   string `json:"image"`
+	Created int64  `json:"created"`
+	State   string `json:"state"`
+}
+
+// statusGenerator makes the status documents of the nodes, with between 1 and maxSvcs services (the agreement service and the services
+// it requires), each with between 1 and maxContainers containers, so the payload sizes vary from node to node like they do in production
+type statusGenerator struct {
+	org, svcurl            string
+	maxSvcs, maxContainers int
+	created                int64 // when the containers were started
+
+	puts       int
+	totalBytes int
+	maxBytes   int
+}
+
+func newStatusGenerator(org, svcurl string, maxSvcs, maxContainers int) *statusGenerator {
+	return &statusGenerator{org: org, svcurl: svcurl, maxSvcs: maxSvcs, maxContainers: maxContainers, created: time.Now().Unix()}
+}
+
+// body returns the status of node n, which runs svcversion of the service for its arch. If it has no agreement, no services are running.
+// The services of an edge cluster are run by an operator, so they have an operator status instead of containers.
+func (g *statusGenerator) body(n int, agreementid, svcversion, arch string, cluster bool) string {
+	status := nodeStatus{Connectivity: map[string]bool{"firmware.bluehorizon.network": true, "images.bluehorizon.network": true}, Services: []serviceStatus{}}
+	if agreementid != "" {
+		// anax names the containers of a service with the (64 hex char) agreement id, or the service instance for the required services
+		instance := fmt.Sprintf("%x", sha256.Sum256([]byte(agreementid)))
+		numSvcs := n%g.maxSvcs + 1
+		for s := 0; s < numSvcs; s++ {
+			svc := serviceStatus{AgreementId: agreementid, ServiceUrl: g.svcurl, Orgid: g.org, Version: svcversion, Arch: arch}
+			prefix := "/" + instance
+			if s > 0 {
+				svc.AgreementId = ""
+				svc.ServiceUrl = g.svcurl + "-dep" + strconv.Itoa(s)
+				prefix = "/" + g.org + "_" + svc.ServiceUrl + "_" + svcversion + "_" + instance[:32]
+			}
+			if cluster {
+				replicas := (n+s)%g.maxContainers + 1
+				svc.ContainerStatus = []containerStatus{}
+				svc.OperatorStatus = map[string]interface{}{"replicas": replicas, "readyReplicas": replicas, "availableReplicas": replicas}
+				status.Services = append(status.Services, svc)
+				continue
+			}
+			for c := 0; c < (n+s)%g.maxContainers+1; c++ {
+				name := svc.ServiceUrl + "-c" + strconv.Itoa(c+1)
+				svc.ContainerStatus = append(svc.ContainerStatus, containerStatus{Name: prefix + "-" + name, Image: "openhorizon/" + arch + "_" + name + ":" + svcversion, Created: g.created, State: "running"})
+			}
+			status.Services = append(status.Services, svc)
+		}
+	}
+
+	bodyBytes, err := json.Marshal(status)
+	if err != nil {
+		perfutils.Fatal(perfutils.JSON_PARSING_ERROR, "failed to marshal the status of node %d: %v", n, err)
+	}
+	g.puts++
+	g.totalBytes += len(bodyBytes)
+	if len(bodyBytes) > g.maxBytes {
+		g.maxBytes = len(bodyBytes)
+	}
+	return string(bodyBytes)
+}
+
+func (g *statusGenerator) String() string {
+	avg := 0
+	if g.puts > 0 {
+		avg = g.totalBytes / g.puts
+	}
+	return fmt.Sprintf("Node status: puts=%d, avg size=%d bytes, max size=%d bytes", g.puts, avg, g.maxBytes)
+}
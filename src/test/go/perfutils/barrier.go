@@ -0,0 +1,103 @@
+// Start barrier for the perf/scale drivers, so that all of the instances finish their setup and then start their timed phase together
+package perfutils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BarrierPollIntervalMS = 250
+	barrierFileSuffix     = ".ready"
+)
+
+// ParseStartTime accepts either an RFC3339 timestamp or the number of seconds since the epoch
+func ParseStartTime(str string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339Nano, str)
+}
+
+// WaitForStartBarrier is called by a driver after its setup is done, and does not return until it is time to start the timed phase.
+// It is controlled by these env vars:
+//
+//	EX_PERF_START_AT: a time (RFC3339 or epoch seconds) to start at. If set, this takes precedence over the other settings.
+//	EX_PERF_BARRIER_DIR and EX_PERF_BARRIER_COUNT: each instance creates a file in the dir and waits until there are that many.
+//	  They all then start at the same time: EX_PERF_BARRIER_DELAY_MS (default 1000) after the last instance became ready.
+//	EX_PERF_BARRIER_TIMEOUT_S: how long to wait for the other instances before giving up (default 600)
+//
+// If none of these are set, it returns immediately.
+func WaitForStartBarrier(instanceName string) {
+	if os.Getenv("EX_PERF_START_AT") == "" && os.Getenv("EX_PERF_BARRIER_DIR") == "" {
+		return
+	}
+	SetPhase("ready")
+
+	var startAt time.Time
+	if startAtStr := os.Getenv("EX_PERF_START_AT"); startAtStr != "" {
+		var err error
+		startAt, err = ParseStartTime(startAtStr)
+		if err != nil {
+			Fatal(CLI_INPUT_ERROR, "invalid EX_PERF_START_AT value '%s': %v", startAtStr, err)
+		}
+	} else {
+		barrierDir := os.Getenv("EX_PERF_BARRIER_DIR")
+		count := ConfigInt("EX_PERF_BARRIER_COUNT")
+		if count <= 0 {
+			Fatal(CLI_INPUT_ERROR, "EX_PERF_BARRIER_COUNT must be set to the number of instances when EX_PERF_BARRIER_DIR is set")
+		}
+		lastReady := waitForBarrierFiles(barrierDir, instanceName, count)
+		startAt = lastReady.Add(time.Duration(ConfigInt("EX_PERF_BARRIER_DELAY_MS")) * time.Millisecond)
+	}
+
+	wait := time.Until(startAt)
+	if wait < 0 {
+		Error("setup finished %f seconds after the start time %s, starting now", -wait.Seconds(), startAt.Format("2006.01.02 15:04:05"))
+		return
+	}
+	fmt.Printf("Setup done, waiting %f seconds for the other instances, to start at %s\n", wait.Seconds(), startAt.Format("2006.01.02 15:04:05.000"))
+	time.Sleep(wait)
+}
+
+// waitForBarrierFiles creates our ready file in barrierDir and waits until count instances have done the same.
+// It returns the modification time of the newest ready file, which is the same value for every instance (because the files are on the same host).
+func waitForBarrierFiles(barrierDir, instanceName string, count int) time.Time {
+	MakeDir(barrierDir)
+	readyFile := filepath.Join(barrierDir, instanceName+barrierFileSuffix)
+	if err := ioutil.WriteFile(readyFile, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		Fatal(FILE_IO_ERROR, "could not create barrier file %s: %v", readyFile, err)
+	}
+
+	timeout := time.Now().Add(Seconds2Duration(ConfigInt("EX_PERF_BARRIER_TIMEOUT_S")))
+	for {
+		files, err := ioutil.ReadDir(barrierDir)
+		if err != nil {
+			Fatal(FILE_IO_ERROR, "could not read barrier dir %s: %v", barrierDir, err)
+		}
+		var lastReady time.Time
+		numReady := 0
+		for _, f := range files {
+			if !strings.HasSuffix(f.Name(), barrierFileSuffix) {
+				continue
+			}
+			numReady++
+			if f.ModTime().After(lastReady) {
+				lastReady = f.ModTime()
+			}
+		}
+		if numReady >= count {
+			return lastReady
+		}
+		if time.Now().After(timeout) {
+			Fatal(CLI_GENERAL_ERROR, "only %d of %d instances were ready in %s before the timeout", numReady, count, barrierDir)
+		}
+		Verbose("%d of %d instances ready in %s", numReady, count, barrierDir)
+		time.Sleep(BarrierPollIntervalMS * time.Millisecond)
+	}
+}
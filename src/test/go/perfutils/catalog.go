@@ -0,0 +1,323 @@
+// Synthetic catalogs of services and patterns with production-like cardinality: many versions and arches of each service, chains of
+// required services, user inputs with and without defaults, public and private services, and patterns that deploy several services.
+// The node driver creates 1 when EX_PERF_CATALOG_SVCS is set, and the catalog command of exchperf creates 1 on its own.
+package perfutils
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+)
+
+// CatalogSpec is the shape of a catalog. The same spec always generates the same catalog.
+type CatalogSpec struct {
+	NumUrls        int      // the number of service urls
+	MaxVersions    int      // each url has 1 to MaxVersions versions
+	Arches         []string // each url is built for 1 or more of these
+	MaxDepDepth    int      // the max length of a chain of required services (0 means no required services)
+	PublicPct      int      // the percent of the service urls and patterns that are public
+	NumPatterns    int
+	MaxPatternUrls int // each pattern deploys 1 to MaxPatternUrls service urls
+	Seed           int64
+}
+
+// CatalogSpecFromConfig returns the spec in the EX_PERF_CATALOG_* settings
+func CatalogSpecFromConfig() CatalogSpec {
+	var arches []string
+	for _, arch := range strings.Split(ConfigString("EX_PERF_CATALOG_ARCHES"), ",") {
+		if arch = strings.TrimSpace(arch); arch != "" {
+			arches = append(arches, arch)
+		}
+	}
+	if len(arches) == 0 {
+		Fatal(CLI_INPUT_ERROR, "EX_PERF_CATALOG_ARCHES must have at least 1 arch")
+	}
+	return CatalogSpec{
+		NumUrls:        ConfigInt("EX_PERF_CATALOG_SVCS"),
+		MaxVersions:    ConfigInt("EX_PERF_CATALOG_VERSIONS"),
+		Arches:         arches,
+		MaxDepDepth:    ConfigInt("EX_PERF_CATALOG_DEP_DEPTH"),
+		PublicPct:      ConfigInt("EX_PERF_CATALOG_PUBLIC_PCT"),
+		NumPatterns:    ConfigInt("EX_PERF_CATALOG_PATTERNS"),
+		MaxPatternUrls: ConfigInt("EX_PERF_CATALOG_PATTERN_SVCS"),
+		Seed:           int64(ConfigInt("EX_PERF_CATALOG_SEED")),
+	}
+}
+
+// The exchange definitions of the catalog resources
+
+type catalogRequiredService struct {
+	Url          string `json:"url"`
+	Org          string `json:"org"`
+	VersionRange string `json:"versionRange"`
+	Arch         string `json:"arch"`
+}
+
+type catalogUserInput struct {
+	Name         string `json:"name"`
+	Label        string `json:"label"`
+	Type         string `json:"type"`
+	DefaultValue string `json:"defaultValue"`
+}
+
+type catalogService struct {
+	Label               string                   `json:"label"`
+	Description         string                   `json:"description"`
+	Public              bool                     `json:"public"`
+	Url                 string                   `json:"url"`
+	Version             string                   `json:"version"`
+	Arch                string                   `json:"arch"`
+	Sharable            string                   `json:"sharable"`
+	RequiredServices    []catalogRequiredService `json:"requiredServices"`
+	UserInput           []catalogUserInput       `json:"userInput"`
+	Deployment          string                   `json:"deployment"`
+	DeploymentSignature string                   `json:"deploymentSignature"`
+}
+
+type catalogServiceVersion struct {
+	Version string `json:"version"`
+}
+
+type catalogPatternService struct {
+	ServiceUrl      string                  `json:"serviceUrl"`
+	ServiceOrgid    string                  `json:"serviceOrgid"`
+	ServiceArch     string                  `json:"serviceArch"`
+	ServiceVersions []catalogServiceVersion `json:"serviceVersions"`
+}
+
+type catalogInputValue struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+type catalogPatternInput struct {
+	ServiceOrgid        string              `json:"serviceOrgid"`
+	ServiceUrl          string              `json:"serviceUrl"`
+	ServiceArch         string              `json:"serviceArch"`
+	ServiceVersionRange string              `json:"serviceVersionRange"`
+	Inputs              []catalogInputValue `json:"inputs"`
+}
+
+type catalogPattern struct {
+	Label       string                  `json:"label"`
+	Description string                  `json:"description"`
+	Public      bool                    `json:"public"`
+	Services    []catalogPatternService `json:"services"`
+	UserInput   []catalogPatternInput   `json:"userInput"`
+}
+
+// The types of the user inputs, with a default value and a value a pattern sets
+var catalogInputTypes = []struct {
+	typ          string
+	defaultValue string
+	value        interface{}
+}{
+	{"string", "abc", "xyz"},
+	{"int", "10", 42},
+	{"float", "1.5", 2.5},
+	{"boolean", "false", true},
+	{"list of strings", `["a","b"]`, []string{"x", "y"}},
+}
+
+// catalogUrl is 1 service url of the catalog, with all of its versions and arches
+type catalogUrl struct {
+	url      string
+	public   bool
+	versions []string // oldest 1st
+	arches   []string
+	deps     []int // the indexes of the urls it requires
+	depth    int   // the length of its longest chain of required services
+	inputs   []catalogUserInput
+}
+
+// CatalogResource is 1 service or pattern of the catalog
+type CatalogResource struct {
+	Id   string      `json:"id"`
+	Body interface{} `json:"body"`
+}
+
+// Catalog is a generated catalog
+type Catalog struct {
+	Org       string
+	Services  []CatalogResource // in the order they must be created: every service is after the ones it requires
+	Patterns  []CatalogResource
+	NumUrls   int
+	NumPublic int // public services
+	MaxDepth  int // the length of the longest chain of required services
+}
+
+// GenerateCatalog generates the catalog of the spec in org. The ids of the services and patterns start with base.
+func GenerateCatalog(spec CatalogSpec, org, base string) *Catalog {
+	rng := rand.New(rand.NewSource(spec.Seed))
+	c := &Catalog{Org: org, NumUrls: spec.NumUrls}
+	urls := make([]catalogUrl, spec.NumUrls)
+	for i := range urls {
+		u := &urls[i]
+		u.url = fmt.Sprintf("%s-svc%d", base, i+1)
+		u.public = rng.Intn(100) < spec.PublicPct
+
+		// Each arch is built for 2/3 of the time, but at least 1
+		for _, arch := range spec.Arches {
+			if rng.Intn(3) > 0 {
+				u.arches = append(u.arches, arch)
+			}
+		}
+		if len(u.arches) == 0 {
+			u.arches = []string{spec.Arches[rng.Intn(len(spec.Arches))]}
+		}
+
+		// Mostly patch and minor releases, with an occasional major one
+		major, minor, patch := 1, 0, 0
+		for v := 1 + rng.Intn(spec.MaxVersions); v > 0; v-- {
+			u.versions = append(u.versions, fmt.Sprintf("%d.%d.%d", major, minor, patch))
+			switch r := rng.Intn(10); {
+			case r < 6:
+				patch++
+			case r < 9:
+				minor, patch = minor+1, 0
+			default:
+				major, minor, patch = major+1, 0, 0
+			}
+		}
+
+		// Require up to 2 of the urls before this one, if they are built for all of our arches and the chain does not get too long
+		if spec.MaxDepDepth > 0 && i > 0 {
+			for tries := rng.Intn(3); tries > 0; tries-- {
+				j := rng.Intn(i)
+				if urls[j].depth+1 > spec.MaxDepDepth || !containsAll(urls[j].arches, u.arches) || containsInt(u.deps, j) {
+					continue
+				}
+				u.deps = append(u.deps, j)
+				u.depth = MaxInt(u.depth, urls[j].depth+1)
+			}
+		}
+		c.MaxDepth = MaxInt(c.MaxDepth, u.depth)
+
+		// Up to 3 user inputs, some of them without a default, so the patterns have to set them
+		for k := rng.Intn(4); k > 0; k-- {
+			t := catalogInputTypes[rng.Intn(len(catalogInputTypes))]
+			input := catalogUserInput{Name: fmt.Sprintf("INPUT%d", len(u.inputs)+1), Label: "input", Type: t.typ}
+			if rng.Intn(2) == 0 {
+				input.DefaultValue = t.defaultValue
+			}
+			u.inputs = append(u.inputs, input)
+		}
+
+		for _, version := range u.versions {
+			for _, arch := range u.arches {
+				svc := catalogService{Label: u.url, Description: "synthetic catalog service", Public: u.public, Url: u.url, Version: version, Arch: arch,
+					Sharable: "multiple", RequiredServices: []catalogRequiredService{}, UserInput: u.inputs, DeploymentSignature: "a",
+					Deployment: `{"services":{"` + u.url + `":{"image":"openhorizon/` + u.url + `_` + arch + `:` + version + `"}}}`}
+				for _, j := range u.deps {
+					svc.RequiredServices = append(svc.RequiredServices, catalogRequiredService{Url: urls[j].url, Org: org, VersionRange: "[" + urls[j].versions[0] + ",INFINITY)", Arch: arch})
+				}
+				if svc.UserInput == nil {
+					svc.UserInput = []catalogUserInput{}
+				}
+				c.Services = append(c.Services, CatalogResource{Id: u.url + "_" + version + "_" + arch, Body: svc})
+				if u.public {
+					c.NumPublic++
+				}
+			}
+		}
+	}
+
+	if len(urls) == 0 {
+		return c
+	}
+	for p := 1; p <= spec.NumPatterns; p++ {
+		pattern := catalogPattern{Label: fmt.Sprintf("pattern %d", p), Description: "synthetic catalog pattern", Public: rng.Intn(100) < spec.PublicPct,
+			Services: []catalogPatternService{}, UserInput: []catalogPatternInput{}}
+		numUrls := MinInt(1+rng.Intn(spec.MaxPatternUrls), len(urls))
+		for _, i := range rng.Perm(len(urls))[:numUrls] {
+			u := urls[i]
+			// Deploy the latest version, and sometimes the one before it too (like during a rollout)
+			versions := []catalogServiceVersion{{Version: u.versions[len(u.versions)-1]}}
+			if len(u.versions) > 1 && rng.Intn(2) == 0 {
+				versions = append(versions, catalogServiceVersion{Version: u.versions[len(u.versions)-2]})
+			}
+			for _, arch := range u.arches {
+				pattern.Services = append(pattern.Services, catalogPatternService{ServiceUrl: u.url, ServiceOrgid: org, ServiceArch: arch, ServiceVersions: versions})
+			}
+
+			var inputs []catalogInputValue
+			for _, input := range u.inputs {
+				if input.DefaultValue == "" {
+					for _, t := range catalogInputTypes {
+						if t.typ == input.Type {
+							inputs = append(inputs, catalogInputValue{Name: input.Name, Value: t.value})
+						}
+					}
+				}
+			}
+			if len(inputs) > 0 {
+				pattern.UserInput = append(pattern.UserInput, catalogPatternInput{ServiceOrgid: org, ServiceUrl: u.url, ServiceVersionRange: "[0.0.0,INFINITY)", Inputs: inputs})
+			}
+		}
+		c.Patterns = append(c.Patterns, CatalogResource{Id: fmt.Sprintf("%s-pat%d", base, p), Body: pattern})
+	}
+	return c
+}
+
+func containsAll(list, items []string) bool {
+	for _, item := range items {
+		found := false
+		for _, l := range list {
+			if l == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func containsInt(list []int, i int) bool {
+	for _, l := range list {
+		if l == i {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the size of the catalog
+func (c *Catalog) String() string {
+	return fmt.Sprintf("%d services (%d urls, %d public), %d patterns, longest required service chain %d", len(c.Services), c.NumUrls, c.NumPublic, len(c.Patterns), c.MaxDepth)
+}
+
+// Resources returns the definitions of the services and patterns, in the order they must be created
+func (c *Catalog) Resources(auth Credentials, shared bool) []ExchangeResource {
+	var resources []ExchangeResource
+	for _, s := range c.Services {
+		resources = append(resources, ExchangeResource{Path: "orgs/" + c.Org + "/services/" + s.Id, CreateMethod: http.MethodPost, CreatePath: "orgs/" + c.Org + "/services",
+			UpdateMethod: http.MethodPut, Auth: auth, Body: MarshalIndent(s.Body, "catalog service"), Shared: shared, DoContinue: true})
+	}
+	for _, p := range c.Patterns {
+		resources = append(resources, ExchangeResource{Path: "orgs/" + c.Org + "/patterns/" + p.Id, CreateMethod: http.MethodPost, UpdateMethod: http.MethodPut,
+			Auth: auth, Body: MarshalIndent(p.Body, "catalog pattern"), Shared: shared, DoContinue: true})
+	}
+	return resources
+}
+
+// Ensure creates the services and patterns that do not exist yet, recording them in the manifest
+func (c *Catalog) Ensure(m *SetupManifest, auth Credentials, shared bool) {
+	for _, r := range c.Resources(auth, shared) {
+		m.Ensure(r)
+	}
+}
+
+// Delete deletes the patterns, and then the services in the reverse order they were created, so no service is deleted while another
+// one requires it
+func (c *Catalog) Delete(auth Credentials, goodHttpCodes []int) {
+	for _, p := range c.Patterns {
+		ExchangeDelete("orgs/"+c.Org+"/patterns/"+p.Id, auth, goodHttpCodes)
+	}
+	for i := len(c.Services) - 1; i >= 0; i-- {
+		ExchangeDelete("orgs/"+c.Org+"/services/"+c.Services[i].Id, auth, goodHttpCodes)
+	}
+}
@@ -0,0 +1,80 @@
+package perfutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGenerateCatalog(t *testing.T) {
+	spec := CatalogSpec{NumUrls: 40, MaxVersions: 4, Arches: []string{"amd64", "arm", "arm64"}, MaxDepDepth: 2, PublicPct: 50, NumPatterns: 10, MaxPatternUrls: 3, Seed: 7}
+	c := GenerateCatalog(spec, "myorg", "cat")
+	if !reflect.DeepEqual(c, GenerateCatalog(spec, "myorg", "cat")) {
+		t.Error("the same spec generated different catalogs")
+	}
+	if len(c.Services) < spec.NumUrls || len(c.Patterns) != spec.NumPatterns {
+		t.Fatalf("catalog = %s", c)
+	}
+	if c.MaxDepth == 0 || c.MaxDepth > spec.MaxDepDepth {
+		t.Errorf("longest required service chain = %d, want 1 to %d", c.MaxDepth, spec.MaxDepDepth)
+	}
+	if c.NumPublic == 0 || c.NumPublic == len(c.Services) {
+		t.Errorf("%d of %d services are public, want some of them", c.NumPublic, len(c.Services))
+	}
+
+	// Every required service must be created before the service that requires it, for the same arch
+	created := map[string]bool{}
+	urls := map[string]bool{}
+	for _, r := range c.Services {
+		svc := r.Body.(catalogService)
+		if r.Id != svc.Url+"_"+svc.Version+"_"+svc.Arch {
+			t.Errorf("service id %s does not match its url, version, and arch", r.Id)
+		}
+		for _, req := range svc.RequiredServices {
+			if req.Arch != svc.Arch || !created[req.Url+"_"+req.Arch] {
+				t.Errorf("%s requires %s %s, which is not created before it", r.Id, req.Url, req.Arch)
+			}
+		}
+		created[svc.Url+"_"+svc.Arch] = true
+		urls[svc.Url] = true
+	}
+	if len(urls) != spec.NumUrls {
+		t.Errorf("the catalog has %d service urls, want %d", len(urls), spec.NumUrls)
+	}
+
+	// The patterns must deploy services in the catalog, and set the inputs that have no default
+	for _, r := range c.Patterns {
+		pattern := r.Body.(catalogPattern)
+		if len(pattern.Services) == 0 {
+			t.Errorf("pattern %s has no services", r.Id)
+		}
+		for _, s := range pattern.Services {
+			for _, v := range s.ServiceVersions {
+				if !created[s.ServiceUrl+"_"+s.ServiceArch] || !serviceExists(c, s.ServiceUrl+"_"+v.Version+"_"+s.ServiceArch) {
+					t.Errorf("pattern %s deploys %s %s %s, which is not in the catalog", r.Id, s.ServiceUrl, v.Version, s.ServiceArch)
+				}
+			}
+		}
+		for _, input := range pattern.UserInput {
+			if len(input.Inputs) == 0 {
+				t.Errorf("pattern %s has an empty user input for %s", r.Id, input.ServiceUrl)
+			}
+		}
+	}
+
+	// No required services when the depth is 0
+	spec.MaxDepDepth = 0
+	for _, r := range GenerateCatalog(spec, "myorg", "cat").Services {
+		if len(r.Body.(catalogService).RequiredServices) > 0 {
+			t.Fatalf("%s has required services with EX_PERF_CATALOG_DEP_DEPTH=0", r.Id)
+		}
+	}
+}
+
+func serviceExists(c *Catalog, id string) bool {
+	for _, r := range c.Services {
+		if r.Id == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+// Explicit completion signaling between the node and agbot drivers, so the agbots know exactly when all of the node simulations are done.
+// Each node.go instance already creates 1 agbot (to be able to create node msgs). It also uses the name of that agbot as its status record:
+// running while it is simulating nodes, and done when its nodes have finished (before it starts cleaning up). The agbot.go instances check
+// these status records each interval.
+package perfutils
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	NODE_DRIVER_STATUS_PREFIX  = "perf-node-driver-"
+	NODE_DRIVER_STATUS_RUNNING = "running"
+	NODE_DRIVER_STATUS_DONE    = "done"
+)
+
+// PublishNodeDriverStatus sets the status record of this node.go instance, which is the name of the agbot the instance created
+func PublishNodeDriverStatus(org, agbotid, agbottoken, status string, userauth Credentials) {
+	ExchangeP(http.MethodPut, "orgs/"+org+"/agbots/"+agbotid, userauth, nil, `{"token": "`+agbottoken+`", "name": "`+NODE_DRIVER_STATUS_PREFIX+status+`", "publicKey": "ABC"}`, nil, true)
+}
+
+// The response from the exchange for GET orgs/{orgid}/agbots. We only need the name of each agbot.
+type exchangeAgbots struct {
+	Agbots map[string]struct {
+		Name string `json:"name"`
+	} `json:"agbots"`
+}
+
+// NodeDriverTracker keeps track of the status records of the node.go instances, to determine when they are all done
+type NodeDriverTracker struct {
+	org      string
+	auth     Credentials
+	expected int             // the min number of node.go instances to wait for, even if we have not seen their status records yet
+	seen     map[string]bool // every node.go instance we have seen, and whether it is done
+}
+
+// NewNodeDriverTracker returns a tracker for the node.go instances in org. It waits for every node.go instance it has seen to be done, and
+// for at least EX_AGBOT_NUM_NODE_INSTANCES of them (default 1), so the agbot does not stop before the node.go instances that start later have
+// created their status records.
+func NewNodeDriverTracker(org string, auth Credentials) *NodeDriverTracker {
+	return &NodeDriverTracker{org: org, auth: auth, expected: ConfigInt("EX_AGBOT_NUM_NODE_INSTANCES"), seen: map[string]bool{}}
+}
+
+// AllDone queries the status records and returns true if all of the node.go instances have finished simulating their nodes.
+// A node.go instance whose status record was already deleted (because it is cleaning up) is also considered done.
+func (t *NodeDriverTracker) AllDone() bool {
+	var resp exchangeAgbots
+	httpCode := ExchangeGet("orgs/"+t.org+"/agbots", t.auth, []int{404}, &resp)
+	if httpCode != 200 && httpCode != 404 {
+		return false // we can not tell, so assume they are still running
+	}
+
+	present := map[string]bool{}
+	for id, agbot := range resp.Agbots {
+		if !strings.HasPrefix(agbot.Name, NODE_DRIVER_STATUS_PREFIX) {
+			continue // this is not a node.go instance's agbot
+		}
+		present[id] = true
+		t.seen[id] = agbot.Name == NODE_DRIVER_STATUS_PREFIX+NODE_DRIVER_STATUS_DONE
+	}
+	for id := range t.seen {
+		if !present[id] {
+			t.seen[id] = true
+		}
+	}
+
+	numDone := 0
+	for _, done := range t.seen {
+		if done {
+			numDone++
+		}
+	}
+	Verbose("%d of %d node driver instances are done", numDone, len(t.seen))
+	return len(t.seen) >= MaxInt(t.expected, 1) && numDone == len(t.seen)
+}
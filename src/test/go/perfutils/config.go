@@ -0,0 +1,544 @@
+// Configuration of the perf drivers. Every setting is still an env var, but they are all listed (with their type, default, and description)
+// in Settings, and can also be set in a json config file or with command line flags. The precedence is: flag, config file, env var, default.
+// The config file and flag values are applied to the env of the process (like the coordinator's scenario settings are in worker mode),
+// so the settings can still be read with os.Getenv() or the Config*() functions below.
+package perfutils
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The drivers (the exchperf subcommands) that have settings
+const (
+	DRIVER_NODE    = "node"
+	DRIVER_AGBOT   = "agbot"
+	DRIVER_SMALL   = "small"
+	DRIVER_CLEANUP = "cleanup"
+	DRIVER_RECORD  = "record"
+	DRIVER_REPLAY  = "replay"
+	DRIVER_CATALOG = "catalog"
+)
+
+// The types of settings
+const (
+	SETTING_STRING = "string"
+	SETTING_INT    = "integer"
+	SETTING_BOOL   = "boolean" // the env var is set to any value for true, or unset for false
+)
+
+// Setting is 1 setting of the perf drivers
+type Setting struct {
+	Name        string                   // the env var name
+	Type        string                   // SETTING_STRING, SETTING_INT, or SETTING_BOOL
+	Default     string                   // the value used when it is not set, or empty if there is no default (or it is calculated from other settings)
+	Values      []string                 // if not empty, the only values allowed (case insensitive)
+	Secret      bool                     // secrets can not be set with flags (they would show up in ps), and are left out of the effective config
+	Required    bool                     // the drivers that use it can not run without it
+	Drivers     []string                 // the drivers that use it, or empty if all of them do
+	Check       func(value string) error // an optional extra check of the value, beyond its type and Values
+	Description string
+}
+
+// The drivers that share settings
+var (
+	timedDrivers        = []string{DRIVER_NODE, DRIVER_AGBOT, DRIVER_REPLAY}                                 // the ones that run a timed test and write a summary of it
+	exchangeUserDrivers = []string{DRIVER_NODE, DRIVER_AGBOT, DRIVER_REPLAY, DRIVER_CATALOG}                 // the ones that use an exchange user in the perf org
+	perfOrgDrivers      = []string{DRIVER_NODE, DRIVER_AGBOT, DRIVER_REPLAY, DRIVER_CATALOG, DRIVER_CLEANUP} // the ones that use the perf org
+	catalogDrivers      = []string{DRIVER_NODE, DRIVER_CATALOG}                                              // the ones that can create a synthetic catalog
+)
+
+// Settings are all of the settings of the drivers, and the perfutils settings they share
+var Settings = []Setting{
+	// The exchange and its credentials
+	{Name: "HZN_EXCHANGE_URL", Type: SETTING_STRING, Required: true, Description: "the exchange url, e.g. https://myexchange/v1"},
+	{Name: "EXCHANGE_ROOTPW", Type: SETTING_STRING, Secret: true, Required: true, Drivers: perfOrgDrivers, Description: "the password of the exchange root user"},
+	{Name: "EXCHANGE_IAM_EMAIL", Type: SETTING_STRING, Drivers: exchangeUserDrivers, Description: "the exchange user (required for basic and iamapikey auth. With bearer auth it is only used by replay, for the user routes of the trace)"},
+	{Name: "EXCHANGE_IAM_KEY", Type: SETTING_STRING, Secret: true, Drivers: exchangeUserDrivers, Description: "the password or IBM Cloud api key of the exchange user (required for basic and iamapikey auth)"},
+	{Name: "EXCHANGE_IAM_ACCOUNT_ID", Type: SETTING_STRING, Drivers: exchangeUserDrivers, Description: "the IBM Cloud account id. Setting it means this is the public cloud instead of ICP"},
+	{Name: "EX_PERF_AUTH_MODE", Type: SETTING_STRING, Values: []string{AUTH_MODE_BASIC, AUTH_MODE_IAMAPIKEY, AUTH_MODE_BEARER}, Drivers: exchangeUserDrivers, Description: "how the exchange user authenticates (default: iamapikey if EXCHANGE_IAM_ACCOUNT_ID is set, otherwise basic)"},
+	{Name: "EX_PERF_BEARER_TOKEN", Type: SETTING_STRING, Secret: true, Drivers: exchangeUserDrivers, Description: "the token of the exchange user when EX_PERF_AUTH_MODE is bearer"},
+	{Name: "EX_PERF_ORG", Type: SETTING_STRING, Default: "performancenodeagbot", Drivers: perfOrgDrivers, Description: "the org all of the driver instances create their resources in"},
+
+	// Where the results go
+	{Name: "EX_PERF_REPORT_DIR", Type: SETTING_STRING, Default: "/tmp/exchangePerf", Description: "where the summaries are written, in a subdir for each driver"},
+	{Name: "EX_PERF_REPORT_FILE", Type: SETTING_STRING, Drivers: timedDrivers, Description: "the summary file of this instance (default: <report dir>/<driver>/<namebase>.summary)"},
+	{Name: "EX_PERF_MANIFEST_DIR", Type: SETTING_STRING, Default: "/tmp/exchangePerfManifests", Drivers: perfOrgDrivers, Description: "where the setup manifests are kept, so a crashed run can be resumed or torn down"},
+	{Name: "VERBOSE", Type: SETTING_BOOL, Description: "show every api call"},
+
+	// The node driver
+	{Name: "EX_PERF_NUM_NODES", Type: SETTING_INT, Default: "50", Drivers: []string{DRIVER_NODE}, Check: checkPositive, Description: "how many nodes this instance simulates"},
+	{Name: "EX_PERF_NUM_HEARTBEATS", Type: SETTING_INT, Default: "15", Drivers: []string{DRIVER_NODE}, Check: checkPositive, Description: "the length of the node test, in node heartbeats"},
+	{Name: "EX_PERF_NUM_NODE_AGREEMENTS", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkNotNegative, Description: "how many nodes get an agreement each heartbeat (0 means enough to finish a few heartbeats before the end)"},
+	{Name: "EX_PERF_NUM_SVCS", Type: SETTING_INT, Default: "3", Drivers: []string{DRIVER_NODE}, Check: checkNotNegative, Description: "extra services to create, so the nodes and patterns have to search thru them"},
+	{Name: "EX_PERF_NUM_PATTERNS", Type: SETTING_INT, Default: "1", Drivers: []string{DRIVER_NODE}, Check: checkPositive, Description: "patterns to create, so the agbot has to serve them all"},
+	{Name: "EX_PERF_CREATE_REG_SLEEP_MS", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkNotNegative, Description: "how long to sleep between creating and registering each node"},
+	{Name: "EX_NODE_HB_INTERVAL", Type: SETTING_INT, Default: "60", Drivers: []string{DRIVER_NODE}, Check: checkPositive, Description: "seconds between node heartbeats"},
+	{Name: "EX_NODE_SVC_CHECK_INTERVAL", Type: SETTING_INT, Default: "300", Drivers: []string{DRIVER_NODE}, Check: checkPositive, Description: "seconds between node service checks"},
+	{Name: "EX_NODE_VERSION_CHECK_INTERVAL", Type: SETTING_INT, Default: "720", Drivers: []string{DRIVER_NODE}, Check: checkPositive, Description: "seconds between node exchange version checks"},
+	{Name: "EX_NODE_NO_SLEEP", Type: SETTING_BOOL, Drivers: []string{DRIVER_NODE}, Description: "do not sleep when a heartbeat finishes early"},
+	{Name: "EX_NODE_STATUS_INTERVAL", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkNotNegative, Description: "how often (in seconds) each node puts its status with its running containers (0 means only when it unregisters)"},
+	{Name: "EX_PERF_STATUS_SVCS", Type: SETTING_INT, Default: "3", Drivers: []string{DRIVER_NODE}, Check: checkPositive, Description: "the max number of services (the agreement service and the services it requires) in the status of a node"},
+	{Name: "EX_PERF_STATUS_CONTAINERS", Type: SETTING_INT, Default: "2", Drivers: []string{DRIVER_NODE}, Check: checkPositive, Description: "the max number of containers of each service in the status of a node"},
+	{Name: "EX_PERF_CHURN_PCT", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkPercent, Description: "the percent of the nodes that churn each heartbeat interval: they take turns to unregister and register again, register with a new token, or switch pattern and policy"},
+	{Name: "EX_PERF_OUTAGE_PCT", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkPercent, Description: "the percent of the nodes that lose connectivity during the outage, and then all reconnect at the same instant"},
+	{Name: "EX_PERF_OUTAGE_START_HB", Type: SETTING_INT, Default: "2", Drivers: []string{DRIVER_NODE}, Check: checkPositive, Description: "the 1st heartbeat the nodes miss during the outage (the heartbeats before it are the baseline for the recovery)"},
+	{Name: "EX_PERF_OUTAGE_HBS", Type: SETTING_INT, Default: "3", Drivers: []string{DRIVER_NODE}, Check: checkPositive, Description: "how many heartbeats the outage lasts"},
+	{Name: "EX_PERF_STALE_PCT", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE, DRIVER_AGBOT}, Check: checkPercent, Description: "the percent of the nodes that stop heartbeating (and making any other calls) for the rest of the run, so the agbots can verify they drop out of nodehealth and the pattern search. They are picked independently of the outage, policy, and cluster nodes, so some of them are those too"},
+	{Name: "EX_PERF_STALE_AFTER_HB", Type: SETTING_INT, Default: "2", Drivers: []string{DRIVER_NODE}, Check: checkPositive, Description: "the 1st heartbeat the stale nodes miss"},
+	{Name: "EX_PERF_POLICY_NODE_PCT", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkPercent, Description: "the percent of the nodes that register without a pattern, so the agbots find them with the search of the business policy the node driver creates"},
+	{Name: "EX_PERF_ROLLOUT_HB", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkNotNegative, Description: "the heartbeat in which the publisher rolls out a new version of the service, and updates the patterns and business policy to it. The nodes find it in their service check, and the agbots renegotiate their agreements for it, so agbot.go must run too (0 means there are no rollouts)"},
+	{Name: "EX_PERF_ROLLOUT_EVERY_HBS", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkNotNegative, Description: "heartbeats between the rollouts of the next versions (0 means there is only 1)"},
+	{Name: "EX_PERF_NODE_ARCHES", Type: SETTING_STRING, Default: "amd64", Drivers: []string{DRIVER_NODE}, Check: checkArchMix, Description: "the arches of the nodes, with their weights, e.g. amd64:70,arm64:20,arm:5,ppc64le:3,s390x:2. The primary service is created for each of them, there is a business policy for each of them, and each pattern leaves 1 of them out, so the searches have to filter out the nodes of the other arches"},
+	{Name: "EX_PERF_CLUSTER_NODE_PCT", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkPercent, Description: "the percent of the nodes that are edge clusters instead of devices"},
+	{Name: "EX_PERF_NODE_ERROR_PCT", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkPercent, Description: "the percent of the nodes that report errors when they get their agreement"},
+	{Name: "EX_NODE_ERROR_CLEAR_HBS", Type: SETTING_INT, Default: "3", Drivers: []string{DRIVER_NODE}, Check: checkNotNegative, Description: "how many heartbeats after reporting its errors a node clears them (0 means never)"},
+	{Name: "EX_PERF_CONFIGSTATE_S", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkNotNegative, Description: "seconds between the admin's suspends/resumes of the service on a random subset of the nodes (0 means never)"},
+	{Name: "EX_PERF_CONFIGSTATE_PCT", Type: SETTING_INT, Default: "10", Drivers: []string{DRIVER_NODE}, Check: checkPercent, Description: "the percent of the nodes whose service the admin suspends or resumes each time"},
+	{Name: "EX_PERF_CONSOLE_USERS", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkNotNegative, Description: "how many management console users run the org-wide node queries (node-details, nodehealth, nodes/service, orgstatus) concurrently while the nodes run"},
+	{Name: "EX_PERF_CONSOLE_THINK_MS", Type: SETTING_INT, Default: "5000", Drivers: []string{DRIVER_NODE}, Check: checkNotNegative, Description: "how long each management console user waits between queries (varied by up to half of it either way)"},
+	{Name: "EX_PERF_ADMIN_ERROR_SEARCH_S", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_NODE}, Check: checkNotNegative, Description: "seconds between the admin's searches for the nodes with errors (0 means there is no admin)"},
+
+	// The agbot driver
+	{Name: "EX_PERF_NUM_AGBOTS", Type: SETTING_INT, Default: "1", Drivers: []string{DRIVER_AGBOT}, Check: checkPositive, Description: "how many agbots this instance simulates"},
+	{Name: "EX_PERF_NUM_AGR_CHECKS", Type: SETTING_INT, Default: "90", Drivers: []string{DRIVER_AGBOT}, Check: checkPositive, Description: "the length of the agbot test, in agreement checks"},
+	{Name: "EX_PERF_NUM_MSGS", Type: SETTING_INT, Default: "50", Drivers: []string{DRIVER_AGBOT}, Check: checkNotNegative, Description: "msgs to create for each agbot, to simulate agreement negotiation"},
+	{Name: "EX_AGBOT_NEW_AGR_INTERVAL", Type: SETTING_INT, Default: "10", Drivers: []string{DRIVER_AGBOT}, Check: checkPositive, Description: "seconds between agreement checks"},
+	{Name: "EX_AGBOT_HB_INTERVAL", Type: SETTING_INT, Default: "60", Drivers: []string{DRIVER_AGBOT}, Check: checkPositive, Description: "seconds between agbot heartbeats"},
+	{Name: "EX_AGBOT_VERSION_CHECK_INTERVAL", Type: SETTING_INT, Default: "60", Drivers: []string{DRIVER_AGBOT}, Check: checkPositive, Description: "seconds between agbot exchange version checks"},
+	{Name: "EX_AGBOT_SECONDS_STALE", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_AGBOT}, Check: checkNotNegative, Description: "the secondsStale of the pattern search (and the age of the lastTime of nodehealth), with which the agbots verify that the nodes that stopped heartbeating drop out of the results (0 means all nodes are returned, and nothing is verified)"},
+	{Name: "EX_AGBOT_STALE_TOLERANCE_S", Type: SETTING_INT, Default: "2", Drivers: []string{DRIVER_AGBOT}, Check: checkNotNegative, Description: "seconds a node may be late to drop out of the results (or early, for the lastHeartbeat) before it is reported as a mismatch, for clock skew and the time of the calls"},
+	{Name: "EX_AGBOT_SEARCH_PAGE_SIZE", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_AGBOT}, Check: checkNotNegative, Description: "the numEntries of the pattern and business policy searches: the agbots page through the results, and verify the pages do not overlap or skip nodes (0 means the results are not paged)"},
+	{Name: "EX_AGBOT_NUM_NODE_INSTANCES", Type: SETTING_INT, Default: "1", Drivers: []string{DRIVER_AGBOT}, Check: checkPositive, Description: "the min number of node driver instances to wait for. Set it to the number launched, when they are launched separately from the agbots, so the agbots do not stop before the later ones start"},
+	{Name: "EX_AGBOT_NO_NODE_TRACKING", Type: SETTING_BOOL, Drivers: []string{DRIVER_AGBOT}, Description: "run all of the agreement checks, instead of stopping when the node driver instances are done"},
+	{Name: "EX_AGBOT_NO_SLEEP", Type: SETTING_BOOL, Drivers: []string{DRIVER_AGBOT}, Description: "do not sleep when an agreement check finishes early"},
+	{Name: "EX_AGBOT_CREATE_SERVICE", Type: SETTING_BOOL, Drivers: []string{DRIVER_AGBOT}, Description: "create 1 service, so the nodes find something"},
+	{Name: "EX_AGBOT_CREATE_PATTERN", Type: SETTING_BOOL, Drivers: []string{DRIVER_AGBOT}, Description: "create 1 pattern (if EX_AGBOT_CREATE_SERVICE is set), so it finds something even if the node driver is not running"},
+
+	// The synthetic catalog of services and patterns (see catalog.go)
+	{Name: "EX_PERF_CATALOG_SVCS", Type: SETTING_INT, Default: "0", Drivers: catalogDrivers, Check: checkNotNegative, Description: "how many service urls to generate in the catalog (0 means the node driver does not create a catalog)"},
+	{Name: "EX_PERF_CATALOG_VERSIONS", Type: SETTING_INT, Default: "3", Drivers: catalogDrivers, Check: checkPositive, Description: "the max number of versions of each catalog service"},
+	{Name: "EX_PERF_CATALOG_ARCHES", Type: SETTING_STRING, Default: "amd64,arm,arm64", Drivers: catalogDrivers, Description: "comma-separated arches the catalog services are built for (each one for some of them)"},
+	{Name: "EX_PERF_CATALOG_DEP_DEPTH", Type: SETTING_INT, Default: "3", Drivers: catalogDrivers, Check: checkNotNegative, Description: "the max length of the required service chains"},
+	{Name: "EX_PERF_CATALOG_PUBLIC_PCT", Type: SETTING_INT, Default: "50", Drivers: catalogDrivers, Check: checkPercent, Description: "the percent of the catalog services and patterns that are public"},
+	{Name: "EX_PERF_CATALOG_PATTERNS", Type: SETTING_INT, Default: "10", Drivers: catalogDrivers, Check: checkNotNegative, Description: "how many patterns to generate in the catalog"},
+	{Name: "EX_PERF_CATALOG_PATTERN_SVCS", Type: SETTING_INT, Default: "3", Drivers: catalogDrivers, Check: checkPositive, Description: "the max number of service urls each catalog pattern deploys"},
+	{Name: "EX_PERF_CATALOG_SEED", Type: SETTING_INT, Default: "1", Drivers: catalogDrivers, Description: "the random seed of the catalog, so the same settings always generate the same catalog"},
+
+	// Starting all of the instances together
+	{Name: "EX_PERF_START_AT", Type: SETTING_STRING, Drivers: timedDrivers, Check: checkStartTime, Description: "a time (RFC3339 or epoch seconds) to start the timed phase at"},
+	{Name: "EX_PERF_BARRIER_DIR", Type: SETTING_STRING, Drivers: timedDrivers, Description: "a dir the instances on this host use to wait for each other before the timed phase"},
+	{Name: "EX_PERF_BARRIER_COUNT", Type: SETTING_INT, Default: "0", Drivers: timedDrivers, Description: "how many instances wait in EX_PERF_BARRIER_DIR"},
+	{Name: "EX_PERF_BARRIER_DELAY_MS", Type: SETTING_INT, Default: "1000", Drivers: timedDrivers, Description: "how long after the last instance is ready to start"},
+	{Name: "EX_PERF_BARRIER_TIMEOUT_S", Type: SETTING_INT, Default: "600", Drivers: timedDrivers, Description: "how long to wait for the other instances"},
+
+	// The small test (micro-benchmarks of single apis)
+	{Name: "HZN_EXCHANGE_NODE_AUTH", Type: SETTING_STRING, Secret: true, Required: true, Drivers: []string{DRIVER_SMALL}, Check: checkIdToken, Description: "<nodeid>:<token> of an existing node in org EX_SMALLTEST_ORG"},
+	{Name: "EXCHANGE_AGBOTAUTH", Type: SETTING_STRING, Secret: true, Drivers: []string{DRIVER_SMALL}, Check: checkIdToken, Description: "<agbotid>:<token> of an existing agbot in org EX_SMALLTEST_ORG. Needed for pattern-search"},
+	{Name: "EX_SMALLTEST_ORG", Type: SETTING_STRING, Default: "IBM", Drivers: []string{DRIVER_SMALL}, Description: "the org of the node and agbot"},
+	{Name: "EX_SMALLTEST_PATTERN", Type: SETTING_STRING, Drivers: []string{DRIVER_SMALL}, Description: "the pattern to search (in org EX_SMALLTEST_ORG). Needed for pattern-search"},
+	{Name: "EX_SMALLTEST_SERVICE_URL", Type: SETTING_STRING, Drivers: []string{DRIVER_SMALL}, Description: "the service url to search for (default: the 1st service of the pattern)"},
+	{Name: "EX_SMALLTEST_CONCURRENCY", Type: SETTING_INT, Default: "1", Drivers: []string{DRIVER_SMALL}, Check: checkPositive, Description: "how many goroutines call the api at the same time"},
+	{Name: "EX_SMALLTEST_WARMUP", Type: SETTING_INT, Default: "10", Drivers: []string{DRIVER_SMALL}, Description: "how many calls to make (in total) before measuring each benchmark"},
+
+	// Cleanup
+	{Name: "EX_PERF_CLEANUP_CONCURRENCY", Type: SETTING_INT, Default: "10", Drivers: []string{DRIVER_CLEANUP}, Check: checkPositive, Description: "how many deletes to run at the same time"},
+
+	// Recording and replaying agent traffic
+	{Name: "EX_PERF_TRACE_FILE", Type: SETTING_STRING, Required: true, Drivers: []string{DRIVER_RECORD, DRIVER_REPLAY}, Description: "the trace file the api calls are recorded in, and replayed from"},
+	{Name: "EX_PERF_RECORD_LISTEN", Type: SETTING_STRING, Default: ":8090", Drivers: []string{DRIVER_RECORD}, Description: "the address the recording proxy listens on"},
+	{Name: "EX_PERF_REPLAY_ACTORS", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_REPLAY}, Check: checkNotNegative, Description: "how many synthetic nodes and agbots to replay the trace as (0 means 1 for each one in the trace)"},
+	{Name: "EX_PERF_REPLAY_SPEEDUP", Type: SETTING_INT, Default: "1", Drivers: []string{DRIVER_REPLAY}, Check: checkNotNegative, Description: "replay the trace this many times faster than it was recorded (0 means do not wait between calls, or keep the order of the calls of different actors)"},
+	{Name: "EX_PERF_REPLAY_LOOPS", Type: SETTING_INT, Default: "1", Drivers: []string{DRIVER_REPLAY}, Check: checkPositive, Description: "how many times each synthetic node and agbot repeats its calls"},
+	{Name: "EX_PERF_REPLAY_RAMP_S", Type: SETTING_INT, Default: "0", Drivers: []string{DRIVER_REPLAY}, Check: checkNotNegative, Description: "spread the start of the synthetic nodes and agbots over this many seconds"},
+
+	// The HTTP client
+	{Name: "EX_PERF_HTTP_RETRY_MAX", Type: SETTING_INT, Default: "5", Description: "how many times to retry a failed api call"},
+	{Name: "EX_PERF_HTTP_RETRY_SLEEP", Type: SETTING_INT, Default: "2", Description: "seconds to sleep before retrying"},
+	{Name: "EX_PERF_DONT_REUSE_HTTP_CLIENT", Type: SETTING_BOOL, Description: "create a new HTTP client for every api call"},
+	{Name: "EX_PERF_HTTP_MAX_IDLE_CONNS", Type: SETTING_INT, Default: strconv.Itoa(MaxHTTPIdleConnections), Description: "max idle connections kept in the pool"},
+	{Name: "EX_PERF_HTTP_MAX_IDLE_CONNS_PER_HOST", Type: SETTING_INT, Description: "max idle connections kept to the exchange (default: EX_PERF_HTTP_MAX_IDLE_CONNS)"},
+	{Name: "EX_PERF_HTTP_IDLE_CONN_TIMEOUT_S", Type: SETTING_INT, Default: strconv.Itoa(HTTPIdleConnectionTimeoutS), Description: "how long an idle connection is kept"},
+	{Name: "EX_PERF_HTTP_KEEPALIVE_S", Type: SETTING_INT, Default: "60", Description: "the tcp keepalive period"},
+	{Name: "EX_PERF_HTTP_DISABLE_KEEPALIVES", Type: SETTING_BOOL, Description: "open a new connection for every request"},
+	{Name: "EX_PERF_HTTP_DISABLE_COMPRESSION", Type: SETTING_BOOL, Description: "do not ask for gzip responses"},
+	{Name: "EX_PERF_HTTP2", Type: SETTING_BOOL, Description: "use HTTP/2 when the exchange supports it (https only)"},
+
+	// TLS (see tls.go)
+	{Name: "HZN_SSL_SKIP_VERIFY", Type: SETTING_BOOL, Description: "do not verify the exchange's cert. Should only be used in test environments"},
+	{Name: "CURL_CA_BUNDLE", Type: SETTING_STRING, Description: "a PEM file of CA certs to trust instead of the system's"},
+	{Name: "EX_PERF_TLS_CA_FILE", Type: SETTING_STRING, Description: "a PEM file of CA certs to trust in addition to the system's (or CURL_CA_BUNDLE's)"},
+	{Name: "EX_PERF_TLS_CLIENT_CERT", Type: SETTING_STRING, Description: "a PEM file of the client cert, for mutual TLS"},
+	{Name: "EX_PERF_TLS_CLIENT_KEY", Type: SETTING_STRING, Description: "a PEM file of the client key, for mutual TLS"},
+	{Name: "EX_PERF_TLS_PIN_SHA256", Type: SETTING_STRING, Description: "comma-separated hex sha256 hashes of the exchange's cert. It must match 1 of them"},
+	{Name: "EX_PERF_TLS_MIN_VERSION", Type: SETTING_STRING, Default: "1.2", Values: []string{"1.0", "1.1", "1.2", "1.3"}, Description: "the minimum TLS version"},
+}
+
+// UsedBy returns true if the driver uses the setting
+func (s Setting) UsedBy(driver string) bool {
+	if len(s.Drivers) == 0 {
+		return true
+	}
+	for _, d := range s.Drivers {
+		if d == driver {
+			return true
+		}
+	}
+	return false
+}
+
+// The Check functions of the settings
+func checkPositive(value string) error {
+	if i, _ := strconv.Atoi(value); i < 1 {
+		return fmt.Errorf("must be at least 1")
+	}
+	return nil
+}
+
+func checkNotNegative(value string) error {
+	if i, _ := strconv.Atoi(value); i < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	return nil
+}
+
+func checkPercent(value string) error {
+	if i, _ := strconv.Atoi(value); i < 0 || i > 100 {
+		return fmt.Errorf("must be between 0 and 100")
+	}
+	return nil
+}
+
+func checkStartTime(value string) error {
+	_, err := ParseStartTime(value)
+	return err
+}
+
+func checkIdToken(value string) error {
+	if parts := strings.SplitN(value, ":", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("must be in the form <id>:<token>")
+	}
+	return nil
+}
+
+// LookupSetting returns the setting with this env var name, or nil if there is none
+func LookupSetting(name string) *Setting {
+	for i := range Settings {
+		if Settings[i].Name == name {
+			return &Settings[i]
+		}
+	}
+	return nil
+}
+
+// mustLookupSetting is for the Config*() functions, where an unknown name is a bug in the driver
+func mustLookupSetting(name string) *Setting {
+	s := LookupSetting(name)
+	if s == nil {
+		Fatal(INTERNAL_ERROR, "%s is not in perfutils.Settings", name)
+	}
+	return s
+}
+
+// ConfigString returns the value of the setting, or its default
+func ConfigString(name string) string {
+	return GetEnvVarWithDefault(name, mustLookupSetting(name).Default)
+}
+
+// ConfigInt returns the value of the int setting, or its default (or 0 if it has none)
+func ConfigInt(name string) int {
+	value := ConfigString(name)
+	if value == "" {
+		return 0
+	}
+	return Str2int(value)
+}
+
+// ConfigBool returns true if the bool setting is set
+func ConfigBool(name string) bool {
+	mustLookupSetting(name)
+	return os.Getenv(name) != ""
+}
+
+// FlagName returns the command line flag of the setting: its name in lower case with dashes, without the EX_ prefix (e.g. --perf-num-nodes)
+func (s Setting) FlagName() string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(s.Name, "EX_"), "_", "-"))
+}
+
+// validate returns an error if value is not valid for the setting
+func (s Setting) validate(value string) error {
+	if s.Type == SETTING_INT {
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%s must be an integer, not '%s'", s.Name, value)
+		}
+	}
+	if len(s.Values) > 0 {
+		found := false
+		for _, v := range s.Values {
+			if strings.EqualFold(v, value) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of %s, not '%s'", s.Name, strings.Join(s.Values, ", "), value)
+		}
+	}
+	if s.Check != nil {
+		if err := s.Check(value); err != nil {
+			if s.Secret {
+				return fmt.Errorf("invalid %s: %v", s.Name, err)
+			}
+			return fmt.Errorf("invalid %s value '%s': %v", s.Name, value, err)
+		}
+	}
+	return nil
+}
+
+// settingFlag is the flag.Value of the command line flag of a setting. It records the value in values, to be applied after the config file.
+type settingFlag struct {
+	setting Setting
+	values  map[string]string
+}
+
+func (f *settingFlag) String() string { return "" }
+
+func (f *settingFlag) IsBoolFlag() bool { return f.setting.Type == SETTING_BOOL }
+
+func (f *settingFlag) Set(value string) error {
+	if f.setting.Type == SETTING_BOOL {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		value = boolValue(b)
+	} else if err := f.setting.validate(value); err != nil {
+		return err
+	}
+	f.values[f.setting.Name] = value
+	return nil
+}
+
+// boolValue is what a bool setting's env var is set to: "true" (VERBOSE only accepts that), or empty to unset it
+func boolValue(b bool) string {
+	if b {
+		return "true"
+	}
+	return ""
+}
+
+// ParseConfig adds the config flags (--config, --print-config, --print-schema, and a flag for every non-secret setting the driver uses) to
+// flags, and parses args with them. It then applies the config file (--config or EX_PERF_CONFIG_FILE) and the flags to the env, and checks
+// all of the settings, so bad input is caught before the test starts. It also records driver as the name of this driver (see GetDriverName()).
+// It returns the remaining (positional) args. If --print-config or --print-schema is given, it prints that and exits.
+func ParseConfig(driver string, flags *flag.FlagSet, args []string) []string {
+	SetDriverName(driver)
+	configFile := flags.String("config", os.Getenv("EX_PERF_CONFIG_FILE"), "a json file of settings, e.g. {\"EX_PERF_NUM_NODES\": 100}. Flags override it, and it overrides env vars. Can also be set with EX_PERF_CONFIG_FILE.")
+	printConfig := flags.Bool("print-config", false, "print the effective config (in the config file format) and exit")
+	printSchema := flags.Bool("print-schema", false, "print the json schema of the config file and exit")
+	flagValues := map[string]string{}
+	for _, s := range Settings {
+		if !s.Secret && s.UsedBy(driver) {
+			flags.Var(&settingFlag{setting: s, values: flagValues}, s.FlagName(), settingUsage(s))
+		}
+	}
+	flags.Parse(args) // flags is expected to be ExitOnError
+
+	if *printSchema {
+		fmt.Println(string(marshalIndent(ConfigSchema())))
+		os.Exit(0)
+	}
+	if *configFile != "" {
+		fileValues, err := ReadConfigFile(*configFile)
+		if err != nil {
+			Fatal(CLI_INPUT_ERROR, "%v", err)
+		}
+		applySettings(fileValues)
+	}
+	applySettings(flagValues)
+	if *printConfig {
+		fmt.Println(string(marshalIndent(EffectiveConfig(driver))))
+		os.Exit(0)
+	}
+	if err := ValidateSettings(driver); err != nil {
+		Fatal(CLI_INPUT_ERROR, "%v", err)
+	}
+	loadRetrySettings() // they were read at init, before the config was applied
+	return flags.Args()
+}
+
+func settingUsage(s Setting) string {
+	return s.Description + " (" + s.Name + ")" + settingNotes(s)
+}
+
+func settingNotes(s Setting) string {
+	notes := ""
+	if s.Required {
+		notes += " (required)"
+	}
+	if s.Default != "" {
+		notes += " (default: " + s.Default + ")"
+	}
+	return notes
+}
+
+// PrintSettingsUsage prints the flags of the driver, and the settings it uses that do not have flags (the secrets)
+func PrintSettingsUsage(driver string, flags *flag.FlagSet) {
+	fmt.Println("\nEvery setting can be set with a flag, in the --config file, or with its env var (in that order of precedence).\nFlags:")
+	flags.SetOutput(os.Stdout)
+	flags.PrintDefaults()
+	var secrets []string
+	for _, s := range Settings {
+		if s.Secret && s.UsedBy(driver) {
+			secrets = append(secrets, "  "+s.Name+": "+s.Description+settingNotes(s))
+		}
+	}
+	if len(secrets) > 0 {
+		fmt.Println("\nThese settings can only be set with their env var or in the --config file:\n" + strings.Join(secrets, "\n"))
+	}
+}
+
+// applySettings sets the env vars of the settings. An empty value (a false bool) unsets it.
+func applySettings(values map[string]string) {
+	for name, value := range values {
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+// ReadConfigFile reads the json config file, which is an object whose keys are setting names (keys starting with $, like $schema, are
+// ignored). It returns the values in the form of the env vars, or an error if a setting is unknown or the wrong type.
+func ReadConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file %s: %v", path, err)
+	}
+	var fileSettings map[string]interface{}
+	if err := json.Unmarshal(data, &fileSettings); err != nil {
+		return nil, fmt.Errorf("could not parse config file %s: %v", path, err)
+	}
+
+	values := map[string]string{}
+	for name, v := range fileSettings {
+		if strings.HasPrefix(name, "$") {
+			continue
+		}
+		s := LookupSetting(name)
+		if s == nil {
+			return nil, fmt.Errorf("unknown setting %s in config file %s", name, path)
+		}
+		var value string
+		switch v := v.(type) {
+		case bool:
+			if s.Type != SETTING_BOOL {
+				return nil, fmt.Errorf("%s in config file %s must be of type %s", name, path, s.Type)
+			}
+			value = boolValue(v)
+		case float64:
+			if s.Type != SETTING_INT || v != float64(int(v)) {
+				return nil, fmt.Errorf("%s in config file %s must be of type %s", name, path, s.Type)
+			}
+			value = strconv.Itoa(int(v))
+		case string:
+			if s.Type != SETTING_STRING {
+				return nil, fmt.Errorf("%s in config file %s must be of type %s", name, path, s.Type)
+			}
+			value = v
+		default:
+			return nil, fmt.Errorf("%s in config file %s must be of type %s", name, path, s.Type)
+		}
+		if value != "" {
+			if err := s.validate(value); err != nil {
+				return nil, fmt.Errorf("in config file %s: %v", path, err)
+			}
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// ValidateSettings checks the values of all of the settings that are set, and that the ones the driver requires are set
+func ValidateSettings(driver string) error {
+	for _, s := range Settings {
+		value := os.Getenv(s.Name)
+		if value == "" && s.Required && s.UsedBy(driver) {
+			return fmt.Errorf("%s is required", s.Name)
+		}
+		if value != "" && s.Type != SETTING_BOOL {
+			if err := s.validate(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EffectiveConfig returns the value (or default) of every setting the driver uses, in the config file format, so a run can be reproduced
+// with it. The secrets and the settings that are not set and have no default are left out.
+func EffectiveConfig(driver string) map[string]interface{} {
+	config := map[string]interface{}{}
+	for _, s := range Settings {
+		value := GetEnvVarWithDefault(s.Name, s.Default)
+		if s.Secret || value == "" || !s.UsedBy(driver) {
+			continue
+		}
+		switch s.Type {
+		case SETTING_BOOL:
+			config[s.Name] = true
+		case SETTING_INT:
+			config[s.Name] = Str2int(value)
+		default:
+			config[s.Name] = value
+		}
+	}
+	return config
+}
+
+// WriteEffectiveConfig writes the effective config of this driver to path, to keep with the results of the run
+func WriteEffectiveConfig(path string) {
+	if err := ioutil.WriteFile(path, append(marshalIndent(EffectiveConfig(GetDriverName())), '\n'), 0644); err != nil {
+		Fatal(FILE_IO_ERROR, "could not write %s: %v", path, err)
+	}
+}
+
+// ConfigSchema returns the json schema of the config file
+func ConfigSchema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, s := range Settings {
+		p := map[string]interface{}{"type": s.Type, "description": s.Description}
+		if s.Default != "" {
+			if s.Type == SETTING_INT {
+				p["default"] = Str2int(s.Default)
+			} else {
+				p["default"] = s.Default
+			}
+		}
+		if len(s.Values) > 0 {
+			p["enum"] = s.Values
+		}
+		properties[s.Name] = p
+	}
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                "exchange perf driver config",
+		"type":                 "object",
+		"properties":           properties,
+		"patternProperties":    map[string]interface{}{"^\\$": true},
+		"additionalProperties": false,
+	}
+}
+
+func marshalIndent(v interface{}) []byte {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		Fatal(JSON_PARSING_ERROR, "could not marshal to json: %v", err)
+	}
+	return data
+}
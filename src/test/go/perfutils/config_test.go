@@ -0,0 +1,184 @@
+package perfutils
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile writes the json config to a temp file and returns its path
+func writeConfigFile(t *testing.T, config string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// unsetAfter unsets the env vars at the end of the test
+func unsetAfter(t *testing.T, names ...string) {
+	t.Cleanup(func() {
+		for _, name := range names {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func TestParseConfigPrecedence(t *testing.T) {
+	unsetAfter(t, "EX_PERF_NUM_NODES", "EX_PERF_NUM_HEARTBEATS", "EX_PERF_NUM_SVCS", "EX_NODE_NO_SLEEP", "EX_PERF_ORG", "EX_PERF_HTTP_RETRY_MAX", "HZN_EXCHANGE_URL", "EXCHANGE_ROOTPW")
+	os.Setenv("HZN_EXCHANGE_URL", "http://localhost/v1") // the required settings
+	os.Setenv("EXCHANGE_ROOTPW", "x")
+	os.Setenv("EX_PERF_NUM_NODES", "1")      // overridden by the file and the flag
+	os.Setenv("EX_PERF_NUM_HEARTBEATS", "2") // overridden by the file
+	os.Setenv("EX_PERF_NUM_SVCS", "3")       // only in the env
+	os.Setenv("EX_NODE_NO_SLEEP", "1")       // unset by the file
+	path := writeConfigFile(t, `{"$schema": "x", "EX_PERF_NUM_NODES": 10, "EX_PERF_NUM_HEARTBEATS": 20, "EX_NODE_NO_SLEEP": false, "EX_PERF_HTTP_RETRY_MAX": 7}`)
+
+	retryMax, retrySleep := RetryMax, RetrySleep
+	t.Cleanup(func() { RetryMax, RetrySleep = retryMax, retrySleep }) // ParseConfig reloads them
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	args := ParseConfig(DRIVER_NODE, flags, []string{"--config", path, "--perf-num-nodes", "100", "mybase", "myhost"})
+
+	if strings.Join(args, " ") != "mybase myhost" {
+		t.Errorf("args = %v, want [mybase myhost]", args)
+	}
+	tests := map[string]int{"EX_PERF_NUM_NODES": 100, "EX_PERF_NUM_HEARTBEATS": 20, "EX_PERF_NUM_SVCS": 3, "EX_PERF_NUM_PATTERNS": 1}
+	for name, want := range tests {
+		if got := ConfigInt(name); got != want {
+			t.Errorf("ConfigInt(%s) = %d, want %d", name, got, want)
+		}
+	}
+	if ConfigBool("EX_NODE_NO_SLEEP") {
+		t.Error("EX_NODE_NO_SLEEP is still set after the config file set it to false")
+	}
+	if ConfigString("EX_PERF_ORG") != "performancenodeagbot" {
+		t.Errorf("ConfigString(EX_PERF_ORG) = %s, want the default", ConfigString("EX_PERF_ORG"))
+	}
+	if RetryMax != 7 {
+		t.Errorf("RetryMax = %d, want 7 from the config file", RetryMax)
+	}
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	tests := map[string]string{
+		`{"EX_PERF_NUM_NODEZ": 1}`:              "unknown setting",
+		`{"EX_PERF_NUM_NODES": "1"}`:            "must be of type integer",
+		`{"EX_PERF_NUM_NODES": 1.5}`:            "must be of type integer",
+		`{"EX_PERF_ORG": 1}`:                    "must be of type string",
+		`{"EX_NODE_NO_SLEEP": "yes"}`:           "must be of type boolean",
+		`{"EX_PERF_AUTH_MODE": "password"}`:     "must be one of",
+		`{"EX_PERF_TLS_MIN_VERSION": "1.4"}`:    "must be one of",
+		`["EX_PERF_NUM_NODES"]`:                 "could not parse",
+		`{"EX_PERF_NUM_NODES": {"value": 1}}`:   "must be of type integer",
+		`{"EX_PERF_HTTP2": true, "VERBOSE": 1}`: "must be of type boolean",
+	}
+	for config, wantErr := range tests {
+		_, err := ReadConfigFile(writeConfigFile(t, config))
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("ReadConfigFile(%s) error = %v, want it to contain '%s'", config, err, wantErr)
+		}
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	unsetAfter(t, "EX_PERF_NUM_NODES", "EX_PERF_AUTH_MODE", "HZN_EXCHANGE_URL", "EXCHANGE_ROOTPW", "HZN_EXCHANGE_NODE_AUTH", "EX_SMALLTEST_CONCURRENCY", "EX_PERF_START_AT",
+		"EX_PERF_NUM_HEARTBEATS", "EX_NODE_HB_INTERVAL", "EX_PERF_NUM_SVCS", "EX_PERF_NUM_AGR_CHECKS", "EX_PERF_NUM_AGBOTS", "EX_AGBOT_NEW_AGR_INTERVAL")
+	os.Setenv("HZN_EXCHANGE_URL", "http://localhost/v1")
+	os.Setenv("EXCHANGE_ROOTPW", "x")
+	os.Setenv("EX_PERF_AUTH_MODE", "Bearer") // the allowed values are case insensitive
+	if err := ValidateSettings(DRIVER_NODE); err != nil {
+		t.Errorf("ValidateSettings() = %v, want no error", err)
+	}
+
+	tests := []struct {
+		driver, name, value, wantErr string
+	}{
+		{DRIVER_NODE, "EX_PERF_NUM_NODES", "many", "must be an integer"},
+		{DRIVER_CLEANUP, "EXCHANGE_ROOTPW", "", "EXCHANGE_ROOTPW is required"},
+		{DRIVER_SMALL, "HZN_EXCHANGE_NODE_AUTH", "", "HZN_EXCHANGE_NODE_AUTH is required"},
+		{DRIVER_SMALL, "HZN_EXCHANGE_NODE_AUTH", "mynode", "must be in the form <id>:<token>"},
+		{DRIVER_SMALL, "EX_SMALLTEST_CONCURRENCY", "0", "must be at least 1"},
+		{DRIVER_NODE, "EX_PERF_NUM_HEARTBEATS", "0", "must be at least 1"},
+		{DRIVER_NODE, "EX_PERF_NUM_NODES", "-5", "must be at least 1"},
+		{DRIVER_NODE, "EX_NODE_HB_INTERVAL", "0", "must be at least 1"},
+		{DRIVER_NODE, "EX_PERF_NUM_SVCS", "-1", "must not be negative"},
+		{DRIVER_AGBOT, "EX_PERF_NUM_AGR_CHECKS", "0", "must be at least 1"},
+		{DRIVER_AGBOT, "EX_PERF_NUM_AGBOTS", "0", "must be at least 1"},
+		{DRIVER_AGBOT, "EX_AGBOT_NEW_AGR_INTERVAL", "-1", "must be at least 1"},
+		{DRIVER_AGBOT, "EX_PERF_START_AT", "noon", "invalid EX_PERF_START_AT value 'noon'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			os.Setenv("HZN_EXCHANGE_NODE_AUTH", "mynode:mytoken")
+			defer os.Setenv(tt.name, os.Getenv(tt.name)) // so the rest of the cases do not fail because of this value
+			os.Setenv(tt.name, tt.value)
+			if err := ValidateSettings(tt.driver); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateSettings(%s) = %v, want an error containing '%s'", tt.driver, err, tt.wantErr)
+			}
+		})
+	}
+
+	// The secrets must not show up in the error msgs
+	os.Setenv("HZN_EXCHANGE_NODE_AUTH", "secrettoken")
+	if err := ValidateSettings(DRIVER_SMALL); err == nil || strings.Contains(err.Error(), "secrettoken") {
+		t.Errorf("ValidateSettings() = %v, want an error without the secret", err)
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	unsetAfter(t, "EXCHANGE_ROOTPW", "EX_PERF_NUM_NODES", "EX_PERF_HTTP2", "EX_PERF_BARRIER_DIR")
+	os.Setenv("EXCHANGE_ROOTPW", "secret")
+	os.Setenv("EX_PERF_NUM_NODES", "12")
+	os.Setenv("EX_PERF_HTTP2", "1")
+
+	config := EffectiveConfig(DRIVER_NODE)
+	if _, ok := config["EXCHANGE_ROOTPW"]; ok {
+		t.Error("the effective config includes the root password")
+	}
+	if _, ok := config["EX_PERF_BARRIER_DIR"]; ok {
+		t.Error("the effective config includes EX_PERF_BARRIER_DIR, which is not set and has no default")
+	}
+	if config["EX_PERF_NUM_NODES"] != 12 || config["EX_PERF_HTTP2"] != true || config["EX_PERF_NUM_HEARTBEATS"] != 15 {
+		t.Errorf("effective config = %v", config)
+	}
+	if _, ok := config["EX_PERF_NUM_AGBOTS"]; ok {
+		t.Error("the effective config of the node driver includes the agbot setting EX_PERF_NUM_AGBOTS")
+	}
+
+	// It must be usable as a config file
+	SetDriverName(DRIVER_NODE)
+	defer SetDriverName("")
+	path := filepath.Join(t.TempDir(), "effective.json")
+	WriteEffectiveConfig(path)
+	values, err := ReadConfigFile(path)
+	if err != nil {
+		t.Fatalf("could not read back the effective config: %v", err)
+	}
+	if values["EX_PERF_NUM_NODES"] != "12" || values["EX_PERF_HTTP2"] != "true" {
+		t.Errorf("effective config read back = %v", values)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	flagNames := map[string]bool{}
+	for _, s := range Settings {
+		if s.Type != SETTING_STRING && s.Type != SETTING_INT && s.Type != SETTING_BOOL {
+			t.Errorf("%s has invalid type %s", s.Name, s.Type)
+		}
+		if s.Default != "" {
+			if err := s.validate(s.Default); err != nil {
+				t.Errorf("the default of %s is invalid: %v", s.Name, err)
+			}
+		}
+		if flagNames[s.FlagName()] {
+			t.Errorf("flag --%s is used by more than 1 setting", s.FlagName())
+		}
+		flagNames[s.FlagName()] = true
+	}
+	if name := LookupSetting("EX_NODE_HB_INTERVAL").FlagName(); name != "node-hb-interval" {
+		t.Errorf("FlagName() = %s, want node-hb-interval", name)
+	}
+}
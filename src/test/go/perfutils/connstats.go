@@ -0,0 +1,113 @@
+// Connection reuse stats of the perf HTTP client, so we can study how connection churn from many agents affects the exchange.
+// Every api call is traced with net/http/httptrace to see whether it got a new or reused connection, and how long connecting took.
+// The same trace times the phases of each request for the per-route report (see routestats.go).
+package perfutils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats is a snapshot of the connection stats since the last ResetConnStats()
+type ConnStats struct {
+	Requests        int           `json:"requests"`        // http requests sent, including retries
+	NewConns        int           `json:"newConns"`        // requests that had to open a new connection
+	ReusedConns     int           `json:"reusedConns"`     // requests that reused a connection from the pool
+	HTTP2Responses  int           `json:"http2Responses"`  // responses that came back over HTTP/2
+	AvgConnectTime  time.Duration `json:"avgConnectTime"`  // avg time to open the tcp connection of the new connections
+	TLSHandshakes   int           `json:"tlsHandshakes"`   // TLS handshakes that were done
+	AvgTLSHandshake time.Duration `json:"avgTlsHandshake"` // avg time of those handshakes
+}
+
+// The counters behind ConnStats. They are updated from every goroutine that calls the exchange, so are only accessed atomically.
+var connCounters struct {
+	requests, newConns, reusedConns, http2Responses int64
+	connects, connectNanos                          int64
+	tlsHandshakes, tlsHandshakeNanos                int64
+}
+
+// GetConnStats returns the connection stats since the last ResetConnStats(). It is safe to call from other goroutines.
+func GetConnStats() ConnStats {
+	s := ConnStats{
+		Requests:       int(atomic.LoadInt64(&connCounters.requests)),
+		NewConns:       int(atomic.LoadInt64(&connCounters.newConns)),
+		ReusedConns:    int(atomic.LoadInt64(&connCounters.reusedConns)),
+		HTTP2Responses: int(atomic.LoadInt64(&connCounters.http2Responses)),
+		TLSHandshakes:  int(atomic.LoadInt64(&connCounters.tlsHandshakes)),
+	}
+	if connects := atomic.LoadInt64(&connCounters.connects); connects > 0 {
+		s.AvgConnectTime = time.Duration(atomic.LoadInt64(&connCounters.connectNanos) / connects)
+	}
+	if s.TLSHandshakes > 0 {
+		s.AvgTLSHandshake = time.Duration(atomic.LoadInt64(&connCounters.tlsHandshakeNanos) / int64(s.TLSHandshakes))
+	}
+	return s
+}
+
+// ResetConnStats sets the connection stats back to 0, usually right before starting the timed part of a test (with ResetTotalOps())
+func ResetConnStats() {
+	for _, counter := range []*int64{&connCounters.requests, &connCounters.newConns, &connCounters.reusedConns, &connCounters.http2Responses,
+		&connCounters.connects, &connCounters.connectNanos, &connCounters.tlsHandshakes, &connCounters.tlsHandshakeNanos} {
+		atomic.StoreInt64(counter, 0)
+	}
+}
+
+// String returns the stats in the form used in the summary files
+func (s ConnStats) String() string {
+	reusedPct := 0.0
+	if s.NewConns+s.ReusedConns > 0 {
+		reusedPct = 100 * float64(s.ReusedConns) / float64(s.NewConns+s.ReusedConns)
+	}
+	return fmt.Sprintf("Connections: requests=%d, new=%d, reused=%d (%.1f%%), http2 responses=%d, avg connect=%.2f ms, TLS handshakes=%d, avg TLS handshake=%.2f ms",
+		s.Requests, s.NewConns, s.ReusedConns, reusedPct, s.HTTP2Responses, Millis(s.AvgConnectTime), s.TLSHandshakes, Millis(s.AvgTLSHandshake))
+}
+
+// connTrace records the connection stats of 1 request, and the times of its phases in timing
+func connTrace(timing *requestTiming) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&connCounters.reusedConns, 1)
+			} else {
+				atomic.AddInt64(&connCounters.newConns, 1)
+			}
+			timing.setNewConn(!info.Reused)
+		},
+		DNSStart:     func(httptrace.DNSStartInfo) { timing.start(PHASE_DNS) },
+		DNSDone:      func(httptrace.DNSDoneInfo) { timing.end(PHASE_DNS) },
+		ConnectStart: func(network, addr string) { timing.startConnect(addr) },
+		ConnectDone: func(network, addr string, err error) {
+			if d := timing.endConnect(addr, err); err == nil && d > 0 {
+				atomic.AddInt64(&connCounters.connects, 1)
+				atomic.AddInt64(&connCounters.connectNanos, int64(d))
+			}
+		},
+		TLSHandshakeStart: func() { timing.start(PHASE_TLS) },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if d := timing.end(PHASE_TLS); err == nil && d > 0 {
+				atomic.AddInt64(&connCounters.tlsHandshakes, 1)
+				atomic.AddInt64(&connCounters.tlsHandshakeNanos, int64(d))
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { timing.start(PHASE_TTFB) },
+		GotFirstResponseByte: func() { timing.end(PHASE_TTFB) },
+	}
+}
+
+// doRequest runs the request with the client, tracing it for the connection stats. The caller should pass the returned timing to
+// recordRoute() after it reads the body.
+func doRequest(httpClient *http.Client, req *http.Request) (*http.Response, *requestTiming, error) {
+	atomic.AddInt64(&connCounters.requests, 1)
+	timing := newRequestTiming()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), connTrace(timing)))
+	resp, err := httpClient.Do(req)
+	timing.start(PHASE_BODY) // the rest of the time is reading the body
+	if err == nil && resp.ProtoMajor == 2 {
+		atomic.AddInt64(&connCounters.http2Responses, 1)
+	}
+	return resp, timing, err
+}
@@ -0,0 +1,93 @@
+package perfutils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConnStats(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantNew    int
+		wantReused int
+	}{
+		{"reuse", map[string]string{}, 1, 4},
+		{"keepalives disabled", map[string]string{"EX_PERF_HTTP_DISABLE_KEEPALIVES": "1"}, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestExchange(t, `{}`, 200)
+			setTLSEnv(t, tt.env) // also makes the next call create a new client
+			ResetConnStats()
+			for i := 0; i < 5; i++ {
+				ExchangeGet("orgs/myorg", testCreds, nil, nil)
+			}
+			s := GetConnStats()
+			if s.Requests != 5 || s.NewConns != tt.wantNew || s.ReusedConns != tt.wantReused {
+				t.Errorf("conn stats = %+v, want 5 requests, %d new, %d reused", s, tt.wantNew, tt.wantReused)
+			}
+			if s.AvgConnectTime <= 0 {
+				t.Errorf("avg connect time = %v, want > 0", s.AvgConnectTime)
+			}
+		})
+	}
+}
+
+func TestConnStatsHTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) }))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	os.Setenv("HZN_EXCHANGE_URL", ts.URL+"/v1")
+
+	for _, http2 := range []bool{false, true} {
+		env := map[string]string{"HZN_SSL_SKIP_VERIFY": "1"}
+		if http2 {
+			env["EX_PERF_HTTP2"] = "1"
+		}
+		setTLSEnv(t, env)
+		ResetConnStats()
+		ExchangeGet("admin/version", nil, nil, nil)
+		ExchangeGet("admin/version", nil, nil, nil)
+		s := GetConnStats()
+		wantHTTP2 := 0
+		if http2 {
+			wantHTTP2 = 2
+		}
+		if s.HTTP2Responses != wantHTTP2 || s.TLSHandshakes != 1 || s.ReusedConns != 1 {
+			t.Errorf("with EX_PERF_HTTP2=%v: conn stats = %+v, want %d http2 responses, 1 TLS handshake, 1 reused", http2, s, wantHTTP2)
+		}
+	}
+}
+
+// A dial to a dual-stack host connects to its IPv4 and IPv6 addresses at the same time. Only the connect that won is counted.
+func TestConnStatsConcurrentConnects(t *testing.T) {
+	ResetConnStats()
+	timing := newRequestTiming()
+	trace := connTrace(timing)
+	trace.ConnectStart("tcp", "[::1]:443")
+	time.Sleep(20 * time.Millisecond)
+	trace.ConnectStart("tcp", "127.0.0.1:443")
+	time.Sleep(20 * time.Millisecond)
+	trace.ConnectDone("tcp", "127.0.0.1:443", nil)
+	trace.ConnectDone("tcp", "[::1]:443", errors.New("operation was canceled"))
+
+	s := GetConnStats()
+	connect := timing.phases[PHASE_CONNECT]
+	if atomic.LoadInt64(&connCounters.connects) != 1 {
+		t.Errorf("connects = %d, want 1", atomic.LoadInt64(&connCounters.connects))
+	}
+	// the IPv4 connect took 20 ms, and the IPv6 one (which started 20 ms before it) must not be added to it or restarted by it
+	if s.AvgConnectTime < 20*time.Millisecond || s.AvgConnectTime >= 40*time.Millisecond {
+		t.Errorf("avg connect time = %v, want the 20 ms of the IPv4 connect", s.AvgConnectTime)
+	}
+	if connect != s.AvgConnectTime {
+		t.Errorf("connect phase = %v, want %v", connect, s.AvgConnectTime)
+	}
+}
@@ -0,0 +1,152 @@
+// Credentials for the exchange api calls the drivers make. The drivers choose the auth mode of the exchange user from configuration
+// (see GetUserCredentials()), instead of building credential strings by hand.
+package perfutils
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Credentials authenticate the requests the drivers make to the exchange. Passing nil credentials makes an anonymous call.
+type Credentials interface {
+	// AddAuth sets the authentication of the request
+	AddAuth(req *http.Request)
+	// String describes the credentials, without the secret, so they can be shown in verbose output
+	String() string
+}
+
+// BasicAuth is an exchange user (or root) and its password
+type BasicAuth struct {
+	Org      string
+	User     string
+	Password string
+}
+
+func (c BasicAuth) AddAuth(req *http.Request) {
+	req.SetBasicAuth(c.Org+"/"+c.User, c.Password)
+}
+
+func (c BasicAuth) String() string {
+	return c.Org + "/" + c.User
+}
+
+// NodeToken is a node and its token
+type NodeToken struct {
+	Org    string
+	NodeId string
+	Token  string
+}
+
+func (c NodeToken) AddAuth(req *http.Request) {
+	req.SetBasicAuth(c.Org+"/"+c.NodeId, c.Token)
+}
+
+func (c NodeToken) String() string {
+	return c.Org + "/" + c.NodeId
+}
+
+// AgbotToken is an agbot and its token
+type AgbotToken struct {
+	Org     string
+	AgbotId string
+	Token   string
+}
+
+func (c AgbotToken) AddAuth(req *http.Request) {
+	req.SetBasicAuth(c.Org+"/"+c.AgbotId, c.Token)
+}
+
+func (c AgbotToken) String() string {
+	return c.Org + "/" + c.AgbotId
+}
+
+// IAMAPIKey is an IBM Cloud IAM api key, which the exchange accepts as the password of the special user iamapikey
+type IAMAPIKey struct {
+	Org string
+	Key string
+}
+
+func (c IAMAPIKey) AddAuth(req *http.Request) {
+	req.SetBasicAuth(c.Org+"/iamapikey", c.Key)
+}
+
+func (c IAMAPIKey) String() string {
+	return c.Org + "/iamapikey"
+}
+
+// BearerToken is a token (e.g. from an identity provider) that is sent as is, for exchange deployments behind an auth proxy.
+// If Org is set, it is also sent in the X-Horizon-Org header.
+type BearerToken struct {
+	Org   string
+	Token string
+}
+
+func (c BearerToken) AddAuth(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if c.Org != "" {
+		req.Header.Set("X-Horizon-Org", c.Org)
+	}
+}
+
+func (c BearerToken) String() string {
+	return c.Org + "/<bearer token>"
+}
+
+// CountedCredentials are credentials whose api calls (including the retries) are also counted in Ops, so the calls of an actor that runs
+// alongside the agents (e.g. an admin) can be left out of their stats. Ops must only be accessed atomically.
+type CountedCredentials struct {
+	Credentials
+	Ops *int64
+}
+
+// The auth modes for the exchange user, set via EX_PERF_AUTH_MODE
+const (
+	AUTH_MODE_BASIC     = "basic"
+	AUTH_MODE_IAMAPIKEY = "iamapikey"
+	AUTH_MODE_BEARER    = "bearer"
+)
+
+// GetRootCredentials returns the credentials of the exchange root user, whose password is in EXCHANGE_ROOTPW
+func GetRootCredentials() Credentials {
+	return BasicAuth{Org: "root", User: "root", Password: GetRequiredEnvVar("EXCHANGE_ROOTPW")}
+}
+
+// GetUserCredentials returns the credentials of the exchange user the drivers use in org, according to EX_PERF_AUTH_MODE:
+//
+//	basic: user EXCHANGE_IAM_EMAIL with password EXCHANGE_IAM_KEY (a local exchange user, e.g. for ICP)
+//	iamapikey: the IBM Cloud api key EXCHANGE_IAM_KEY
+//	bearer: the token in EX_PERF_BEARER_TOKEN
+//
+// If EX_PERF_AUTH_MODE is not set, it is iamapikey if EXCHANGE_IAM_ACCOUNT_ID is set (which means this is the public cloud), otherwise basic.
+func GetUserCredentials(org string) Credentials {
+	mode := os.Getenv("EX_PERF_AUTH_MODE")
+	if mode == "" {
+		if os.Getenv("EXCHANGE_IAM_ACCOUNT_ID") != "" {
+			mode = AUTH_MODE_IAMAPIKEY
+		} else {
+			mode = AUTH_MODE_BASIC
+		}
+	}
+
+	switch strings.ToLower(mode) {
+	case AUTH_MODE_BASIC:
+		return BasicAuth{Org: org, User: GetRequiredEnvVar("EXCHANGE_IAM_EMAIL"), Password: GetRequiredEnvVar("EXCHANGE_IAM_KEY")}
+	case AUTH_MODE_IAMAPIKEY:
+		return IAMAPIKey{Org: org, Key: GetRequiredEnvVar("EXCHANGE_IAM_KEY")}
+	case AUTH_MODE_BEARER:
+		return BearerToken{Org: org, Token: GetRequiredEnvVar("EX_PERF_BEARER_TOKEN")}
+	default:
+		Fatal(CLI_INPUT_ERROR, "invalid EX_PERF_AUTH_MODE '%s', must be one of: %s, %s, %s", mode, AUTH_MODE_BASIC, AUTH_MODE_IAMAPIKEY, AUTH_MODE_BEARER)
+	}
+	return nil // will never get here
+}
+
+// ParseIdToken splits "<id>:<token>" (the form of HZN_EXCHANGE_NODE_AUTH) into the id and token
+func ParseIdToken(idToken string) (string, string) {
+	parts := strings.SplitN(idToken, ":", 2)
+	if len(parts) != 2 {
+		Fatal(CLI_INPUT_ERROR, "'%s' is not of the form <id>:<token>", idToken)
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,67 @@
+package perfutils
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestGetUserCredentials(t *testing.T) {
+	os.Setenv("EXCHANGE_IAM_EMAIL", "me@example.com")
+	os.Setenv("EXCHANGE_IAM_KEY", "mykey")
+	os.Setenv("EX_PERF_BEARER_TOKEN", "mytoken")
+	defer func() {
+		for _, name := range []string{"EXCHANGE_IAM_EMAIL", "EXCHANGE_IAM_KEY", "EX_PERF_BEARER_TOKEN", "EX_PERF_AUTH_MODE", "EXCHANGE_IAM_ACCOUNT_ID"} {
+			os.Unsetenv(name)
+		}
+	}()
+
+	tests := []struct {
+		mode, accountId string
+		want            Credentials
+	}{
+		{"", "", BasicAuth{Org: "myorg", User: "me@example.com", Password: "mykey"}},
+		{"", "myaccount", IAMAPIKey{Org: "myorg", Key: "mykey"}},
+		{"basic", "myaccount", BasicAuth{Org: "myorg", User: "me@example.com", Password: "mykey"}},
+		{"iamapikey", "", IAMAPIKey{Org: "myorg", Key: "mykey"}},
+		{"Bearer", "", BearerToken{Org: "myorg", Token: "mytoken"}},
+	}
+	for _, tt := range tests {
+		os.Setenv("EX_PERF_AUTH_MODE", tt.mode)
+		os.Setenv("EXCHANGE_IAM_ACCOUNT_ID", tt.accountId)
+		if got := GetUserCredentials("myorg"); got != tt.want {
+			t.Errorf("GetUserCredentials() with mode '%s' and account id '%s' = %#v, want %#v", tt.mode, tt.accountId, got, tt.want)
+		}
+	}
+}
+
+func TestCredentialsString(t *testing.T) {
+	// The secrets must not show up in verbose output
+	tests := map[string]Credentials{
+		"myorg/u":              BasicAuth{Org: "myorg", User: "u", Password: "secret"},
+		"myorg/n1":             NodeToken{Org: "myorg", NodeId: "n1", Token: "secret"},
+		"myorg/a1":             AgbotToken{Org: "myorg", AgbotId: "a1", Token: "secret"},
+		"myorg/iamapikey":      IAMAPIKey{Org: "myorg", Key: "secret"},
+		"myorg/<bearer token>": BearerToken{Org: "myorg", Token: "secret"},
+	}
+	for want, creds := range tests {
+		if got := creds.String(); got != want {
+			t.Errorf("%#v.String() = %q, want %q", creds, got, want)
+		}
+	}
+}
+
+func TestBearerTokenOrgHeader(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/v1/orgs/myorg", nil)
+	BearerToken{Org: "myorg", Token: "xyz"}.AddAuth(req)
+	if got := req.Header.Get("X-Horizon-Org"); got != "myorg" {
+		t.Errorf("X-Horizon-Org = %q, want myorg", got)
+	}
+}
+
+func TestParseIdToken(t *testing.T) {
+	id, token := ParseIdToken("n1:abc:def")
+	if id != "n1" || token != "abc:def" {
+		t.Errorf("ParseIdToken() = %q, %q, want n1, abc:def", id, token)
+	}
+}
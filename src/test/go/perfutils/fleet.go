@@ -0,0 +1,100 @@
+// The composition of the simulated fleet: the weighted mix of the arches of the nodes, so the exchange's arch filtering in the searches is
+// exercised with the heterogeneity of a real fleet
+package perfutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	NODE_TYPE_DEVICE  = "device"
+	NODE_TYPE_CLUSTER = "cluster"
+)
+
+// The arches the nodes can have
+var NODE_ARCHES = []string{"amd64", "arm", "arm64", "ppc64le", "s390x"}
+
+// ArchWeight is 1 arch of the mix, and its share of the nodes relative to the others
+type ArchWeight struct {
+	Arch   string
+	Weight int
+}
+
+// ParseArchMix parses a mix of arches like "amd64:70,arm64:20,arm:10". The weight of an arch is 1 if it is not given.
+func ParseArchMix(mix string) ([]ArchWeight, error) {
+	var weights []ArchWeight
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(mix, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		aw := ArchWeight{Arch: entry, Weight: 1}
+		if i := strings.Index(entry, ":"); i >= 0 {
+			w, err := strconv.Atoi(strings.TrimSpace(entry[i+1:]))
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("the weight of %s must be a positive integer", entry)
+			}
+			aw = ArchWeight{Arch: strings.TrimSpace(entry[:i]), Weight: w}
+		}
+		if !containsAll(NODE_ARCHES, []string{aw.Arch}) {
+			return nil, fmt.Errorf("arch %s is not 1 of %s", aw.Arch, strings.Join(NODE_ARCHES, ", "))
+		}
+		if seen[aw.Arch] {
+			return nil, fmt.Errorf("arch %s is in the mix more than once", aw.Arch)
+		}
+		seen[aw.Arch] = true
+		weights = append(weights, aw)
+	}
+	if len(weights) == 0 {
+		return nil, fmt.Errorf("the mix must have at least 1 arch")
+	}
+	return weights, nil
+}
+
+func checkArchMix(value string) error {
+	_, err := ParseArchMix(value)
+	return err
+}
+
+// ArchMixFromConfig returns the mix of arches of EX_PERF_NODE_ARCHES
+func ArchMixFromConfig() []ArchWeight {
+	mix, err := ParseArchMix(ConfigString("EX_PERF_NODE_ARCHES"))
+	if err != nil {
+		Fatal(CLI_INPUT_ERROR, "EX_PERF_NODE_ARCHES: %v", err)
+	}
+	return mix
+}
+
+// Arches returns the arches of the mix, in its order
+func Arches(mix []ArchWeight) []string {
+	var arches []string
+	for _, aw := range mix {
+		arches = append(arches, aw.Arch)
+	}
+	return arches
+}
+
+// AssignArches returns the arch of each of the nodes 1 to numNodes (index 0 is not used). The arches are interleaved in proportion to their
+// weights (smooth weighted round robin), so any range of nodes has about the same mix as the whole fleet.
+func AssignArches(mix []ArchWeight, numNodes int) []string {
+	arches := make([]string, numNodes+1)
+	total := 0
+	for _, aw := range mix {
+		total += aw.Weight
+	}
+	current := make([]int, len(mix))
+	for n := 1; n <= numNodes; n++ {
+		best := 0
+		for i, aw := range mix {
+			current[i] += aw.Weight
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		arches[n] = mix[best].Arch
+	}
+	return arches
+}
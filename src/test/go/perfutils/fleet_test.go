@@ -0,0 +1,26 @@
+package perfutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArchMix(t *testing.T) {
+	mix, err := ParseArchMix("amd64:70, arm64:20,arm")
+	if err != nil || !reflect.DeepEqual(mix, []ArchWeight{{"amd64", 70}, {"arm64", 20}, {"arm", 1}}) {
+		t.Errorf("ParseArchMix() = %v, %v", mix, err)
+	}
+	for _, bad := range []string{"", "amd64:0", "amd64:x", "mips", "arm,arm"} {
+		if _, err := ParseArchMix(bad); err == nil {
+			t.Errorf("ParseArchMix(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestAssignArches(t *testing.T) {
+	arches := AssignArches([]ArchWeight{{"amd64", 3}, {"arm64", 1}}, 8)
+	want := []string{"", "amd64", "amd64", "arm64", "amd64", "amd64", "amd64", "arm64", "amd64"}
+	if !reflect.DeepEqual(arches, want) {
+		t.Errorf("AssignArches() = %v, want %v", arches, want)
+	}
+}
@@ -0,0 +1,63 @@
+// Latency statistics for the perf/scale drivers
+package perfutils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// LatencyStats summarizes a set of measured latencies
+type LatencyStats struct {
+	Count int           `json:"count"`
+	Mean  time.Duration `json:"mean"`
+	Min   time.Duration `json:"min"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+// ComputeLatencyStats returns the stats of the given latencies. The slice is sorted in place.
+func ComputeLatencyStats(latencies []time.Duration) LatencyStats {
+	stats := LatencyStats{Count: len(latencies)}
+	if len(latencies) == 0 {
+		return stats
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	stats.Mean = total / time.Duration(len(latencies))
+	stats.Min = latencies[0]
+	stats.P50 = Percentile(latencies, 50)
+	stats.P90 = Percentile(latencies, 90)
+	stats.P95 = Percentile(latencies, 95)
+	stats.P99 = Percentile(latencies, 99)
+	stats.Max = latencies[len(latencies)-1]
+	return stats
+}
+
+// Percentile returns the pct percentile (using the nearest-rank method) of the already sorted latencies
+func Percentile(sorted []time.Duration, pct float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(pct*float64(len(sorted))/100)) - 1 // round up to the nearest rank, then make it 0-based
+	rank = MaxInt(0, MinInt(rank, len(sorted)-1))
+	return sorted[rank]
+}
+
+// String returns the stats in ms, in the form used in the summary files
+func (s LatencyStats) String() string {
+	return fmt.Sprintf("mean=%.2f min=%.2f p50=%.2f p90=%.2f p95=%.2f p99=%.2f max=%.2f ms", Millis(s.Mean), Millis(s.Min), Millis(s.P50), Millis(s.P90), Millis(s.P95), Millis(s.P99), Millis(s.Max))
+}
+
+// Millis returns the duration as a float number of milliseconds
+func Millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
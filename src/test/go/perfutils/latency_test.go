@@ -0,0 +1,38 @@
+package perfutils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeLatencyStats(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- { // out of order, to verify it sorts them
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	stats := ComputeLatencyStats(latencies)
+	want := LatencyStats{Count: 100, Mean: 50500 * time.Microsecond, Min: time.Millisecond, P50: 50 * time.Millisecond, P90: 90 * time.Millisecond,
+		P95: 95 * time.Millisecond, P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}
+	if stats != want {
+		t.Errorf("ComputeLatencyStats() = %+v, want %+v", stats, want)
+	}
+
+	if stats := ComputeLatencyStats(nil); stats != (LatencyStats{}) {
+		t.Errorf("ComputeLatencyStats(nil) = %+v, want all zeros", stats)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3}
+	tests := map[float64]time.Duration{0: 1, 33: 1, 34: 2, 50: 2, 99: 3, 100: 3}
+	for pct, want := range tests {
+		if got := Percentile(sorted, pct); got != want {
+			t.Errorf("Percentile(%v) = %v, want %v", pct, got, want)
+		}
+	}
+
+	// The rank of the 10.000001 percentile of 10 latencies is 1.0000001, which is just over the 1st, so it is the 2nd
+	if got := Percentile([]time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 10.000001); got != 2 {
+		t.Errorf("Percentile(10.000001) = %v, want 2", got)
+	}
+}
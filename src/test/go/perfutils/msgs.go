@@ -0,0 +1,42 @@
+// The msgs the agbots and nodes send each other through the exchange. Most of them only simulate the load of agreement negotiation, but when
+// the publisher rolls out a new version of the service, the agbots propose agreements for it in them, and the nodes reply.
+package perfutils
+
+import "strings"
+
+// The kinds of the agreement msgs. Their message is the kind and the version of the service, e.g. "proposal 1.2.4".
+const (
+	MSG_PROPOSAL = "proposal" // an agbot proposes an agreement for the version to a node
+	MSG_AGREED   = "agreed"   // the node has an agreement for the version now
+	MSG_DECLINED = "declined" // the node does not want an agreement for the version (it has none, or the version is not the latest)
+)
+
+// ExchangeMsgs is the response from the exchange for GET orgs/{orgid}/nodes/{id}/msgs and orgs/{orgid}/agbots/{id}/msgs
+type ExchangeMsgs struct {
+	Messages []ExchangeMsg `json:"messages"`
+}
+
+type ExchangeMsg struct {
+	MsgId   int    `json:"msgId"`
+	AgbotId string `json:"agbotId"` // the sender of a node msg, with the org prepended
+	NodeId  string `json:"nodeId"`  // the sender of an agbot msg, with the org prepended
+	Message string `json:"message"`
+}
+
+// AgreementMsg returns the message of an agreement msg of the kind for the version
+func AgreementMsg(kind, version string) string {
+	return kind + " " + version
+}
+
+// ParseAgreementMsg returns the kind and version of an agreement msg, or false if the message is not 1 (e.g. it only simulates load)
+func ParseAgreementMsg(message string) (kind, version string, ok bool) {
+	fields := strings.Fields(message)
+	if len(fields) != 2 {
+		return "", "", false
+	}
+	switch fields[0] {
+	case MSG_PROPOSAL, MSG_AGREED, MSG_DECLINED:
+		return fields[0], fields[1], true
+	}
+	return "", "", false
+}
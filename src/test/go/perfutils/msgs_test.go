@@ -0,0 +1,16 @@
+package perfutils
+
+import "testing"
+
+func TestParseAgreementMsg(t *testing.T) {
+	for _, kind := range []string{MSG_PROPOSAL, MSG_AGREED, MSG_DECLINED} {
+		if k, v, ok := ParseAgreementMsg(AgreementMsg(kind, "1.2.4")); !ok || k != kind || v != "1.2.4" {
+			t.Errorf("ParseAgreementMsg(AgreementMsg(%s, 1.2.4)) = %s, %s, %v", kind, k, v, ok)
+		}
+	}
+	for _, message := range []string{"hey there", "proposal", "proposal 1.2.4 extra", ""} {
+		if _, _, ok := ParseAgreementMsg(message); ok {
+			t.Errorf("ParseAgreementMsg(%q) parsed a msg that is not an agreement msg", message)
+		}
+	}
+}
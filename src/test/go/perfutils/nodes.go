@@ -0,0 +1,107 @@
+// The node resources as the exchange returns them, with the fields the drivers need to act on
+package perfutils
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CONFIG_STATE_ACTIVE    = "active"
+	CONFIG_STATE_SUSPENDED = "suspended"
+)
+
+// EXCHANGE_TIME_FORMAT is how the exchange formats its times (e.g. lastHeartbeat). It compares them as strings, so the times we give it
+// (e.g. the lastTime of nodehealth) must be in the same format.
+const EXCHANGE_TIME_FORMAT = "2006-01-02T15:04:05.000Z[UTC]"
+
+// The ids of the nodes the node driver simulates end in -node-n<number>
+var nodeNumberRegex = regexp.MustCompile(`-node-n(\d+)$`)
+
+// ExchangeNodes is the response from the exchange for GET orgs/{orgid}/nodes and orgs/{orgid}/nodes/{id}
+type ExchangeNodes struct {
+	Nodes map[string]ExchangeNode `json:"nodes"`
+}
+
+type ExchangeNode struct {
+	NodeType           string              `json:"nodeType"`
+	Arch               string              `json:"arch"`
+	Pattern            string              `json:"pattern"`
+	RegisteredServices []RegisteredService `json:"registeredServices"`
+	LastHeartbeat      string              `json:"lastHeartbeat"`
+	LastUpdated        string              `json:"lastUpdated"`
+	Agreements         map[string]struct{} `json:"agreements"` // only in the nodehealth responses
+}
+
+type RegisteredService struct {
+	Url         string `json:"url"` // org/url
+	ConfigState string `json:"configState"`
+}
+
+// ServiceSuspended returns true if the service (org/url) is suspended on the node, i.e. set by POST orgs/{orgid}/nodes/{id}/services_configstate
+// (which is what "hzn service configstate suspend" does)
+func (n ExchangeNode) ServiceSuspended(svcUrl string) bool {
+	for _, s := range n.RegisteredServices {
+		if s.Url == svcUrl {
+			return s.ConfigState == CONFIG_STATE_SUSPENDED
+		}
+	}
+	return false
+}
+
+// ServiceSuspended returns true if the service is suspended on any of the nodes in the response
+func (r ExchangeNodes) ServiceSuspended(svcUrl string) bool {
+	for _, n := range r.Nodes {
+		if n.ServiceSuspended(svcUrl) {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatExchangeTime returns the time in the format of the exchange
+func FormatExchangeTime(t time.Time) string {
+	return t.UTC().Format(EXCHANGE_TIME_FORMAT)
+}
+
+// ParseExchangeTime parses a time from the exchange, e.g. "2020-02-05T20:28:14.123Z[UTC]"
+func ParseExchangeTime(str string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, strings.TrimSuffix(str, "[UTC]"))
+}
+
+// The features that pick a percent of the nodes with SpreadEvenly
+const (
+	PICK_ERROR_NODES = iota
+	PICK_OUTAGE_NODES
+	PICK_STALE_NODES
+	PICK_POLICY_NODES
+	PICK_CLUSTER_NODES
+	numNodePicks
+)
+
+// The step of each feature's sequence (see SpreadEvenly). They are irrational, and were chosen so that the nodes any 2 features pick
+// overlap about as much as if they were picked independently, even in small fleets.
+var nodePickSteps = [numNodePicks]float64{(math.Sqrt(5) - 1) / 2, math.Sqrt(3), math.Sqrt(6), math.Sqrt(19), math.Sqrt(21)}
+
+// SpreadEvenly returns true if node number n is 1 of the pct percent of the nodes the feature picks (e.g. to report errors). Node n is
+// picked if the fractional part of n times the feature's step is less than pct/100, so the picked nodes are spread evenly: any range of
+// nodes has about pct percent of them picked. Each feature has its own step, so the sets are independent of each other instead of nested
+// (e.g. about 10% of the stale nodes are policy nodes, instead of all of them). The node and agbot drivers both use it, so they agree on
+// which nodes were picked.
+func SpreadEvenly(feature, n, pct int) bool {
+	x := float64(n) * nodePickSteps[feature]
+	return (x-math.Floor(x))*100 < float64(pct)
+}
+
+// NodeNumber returns the number of a node the node driver simulates (e.g. 12 for "myorg/myhost-node-n12"), or false if it is not 1 of them
+func NodeNumber(nodeId string) (int, bool) {
+	m := nodeNumberRegex.FindStringSubmatch(nodeId)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	return n, err == nil
+}
@@ -0,0 +1,72 @@
+package perfutils
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestServiceSuspended(t *testing.T) {
+	var resp ExchangeNodes
+	body := `{"nodes": {"myorg/n1": {"pattern": "myorg/p1", "registeredServices": [
+		{"url": "myorg/svc1", "numAgreements": 1, "configState": "active", "policy": "", "properties": []},
+		{"url": "myorg/svc2", "numAgreements": 1, "configState": "suspended", "policy": "", "properties": []}
+	]}}, "lastIndex": 0}`
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ServiceSuspended("myorg/svc1") || !resp.ServiceSuspended("myorg/svc2") || resp.ServiceSuspended("myorg/svc3") {
+		t.Errorf("ServiceSuspended() is wrong for %s", body)
+	}
+}
+
+func TestExchangeTime(t *testing.T) {
+	tm := time.Date(2020, 2, 5, 20, 28, 0, 0, time.UTC)
+	if str := FormatExchangeTime(tm); str != "2020-02-05T20:28:00.000Z[UTC]" {
+		t.Errorf("FormatExchangeTime() = %s", str)
+	}
+	for _, str := range []string{"2020-02-05T20:28:00.000Z[UTC]", "2020-02-05T20:28:00Z[UTC]", "2020-02-05T20:28:00.000000Z[UTC]"} {
+		if parsed, err := ParseExchangeTime(str); err != nil || !parsed.Equal(tm) {
+			t.Errorf("ParseExchangeTime(%s) = %v, %v", str, parsed, err)
+		}
+	}
+}
+
+func TestSpreadEvenly(t *testing.T) {
+	// Every range of nodes 1 to n has about pct percent of them picked
+	for feature := 0; feature < numNodePicks; feature++ {
+		for _, pct := range []int{0, 5, 15, 50, 100} {
+			picked := 0
+			for n := 1; n <= 1000; n++ {
+				if SpreadEvenly(feature, n, pct) {
+					picked++
+				}
+				if want := float64(n*pct) / 100; math.Abs(float64(picked)-want) > 4 {
+					t.Fatalf("SpreadEvenly() of feature %d picked %d of nodes 1 to %d at %d%%, want about %.0f", feature, picked, n, pct, want)
+				}
+			}
+		}
+	}
+
+	// The features pick independently, so they overlap by about the product of their percents, instead of 1 set being inside the other
+	for f1 := 0; f1 < numNodePicks; f1++ {
+		for f2 := f1 + 1; f2 < numNodePicks; f2++ {
+			both := 0
+			for n := 1; n <= 1000; n++ {
+				if SpreadEvenly(f1, n, 10) && SpreadEvenly(f2, n, 20) {
+					both++
+				}
+			}
+			if both < 10 || both > 30 {
+				t.Errorf("features %d (10%%) and %d (20%%) both picked %d of 1000 nodes, want about 20", f1, f2, both)
+			}
+		}
+	}
+	if n, ok := NodeNumber("myorg/myhost-node-n12"); n != 12 || !ok {
+		t.Errorf("NodeNumber() = %d, %v", n, ok)
+	}
+	if _, ok := NodeNumber("myorg/myhost-node-a1"); ok {
+		t.Error("NodeNumber() matched an agbot id")
+	}
+}
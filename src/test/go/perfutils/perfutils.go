@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -18,6 +17,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -40,13 +40,48 @@ const (
 
 var EX_PERF_REPORT_FILE string
 var HttpClient *http.Client // holds the global client we reuse
-var TotalOps int            // holds the total number of rest apis we have run
+var totalOps int64          // holds the total number of rest apis we have run. Use GetTotalOps() and ResetTotalOps() to access it
+var errorCount int64        // holds the number of errors that have been reported via Error()
 var RetryMax int
 var RetrySleep int
+var exitHooks []func(exitCode int) // run by Fatal() before exiting, see AddExitHook()
+var driverName string              // see SetDriverName()
 
 func init() {
-	RetryMax = GetEnvVarIntWithDefault("EX_PERF_HTTP_RETRY_MAX", 5)
-	RetrySleep = GetEnvVarIntWithDefault("EX_PERF_HTTP_RETRY_SLEEP", 2)
+	loadRetrySettings()
+}
+
+func loadRetrySettings() {
+	RetryMax = ConfigInt("EX_PERF_HTTP_RETRY_MAX")
+	RetrySleep = ConfigInt("EX_PERF_HTTP_RETRY_SLEEP")
+}
+
+// GetTotalOps returns the number of rest apis we have run since the last ResetTotalOps(). It is safe to call from other goroutines.
+func GetTotalOps() int {
+	return int(atomic.LoadInt64(&totalOps))
+}
+
+// countOp counts 1 rest api call (including each retry) in the total, and in the counter of the credentials if they have 1
+func countOp(credentials Credentials) {
+	atomic.AddInt64(&totalOps, 1)
+	if c, ok := credentials.(CountedCredentials); ok {
+		atomic.AddInt64(c.Ops, 1)
+	}
+}
+
+// ResetTotalOps sets the rest api count back to 0, usually right before starting the timed part of a test
+func ResetTotalOps() {
+	atomic.StoreInt64(&totalOps, 0)
+}
+
+// GetErrorCount returns the number of errors that have been reported so far. It is safe to call from other goroutines.
+func GetErrorCount() int {
+	return int(atomic.LoadInt64(&errorCount))
+}
+
+// AddExitHook registers a function that Fatal() will call (with the exit code) right before it exits the process
+func AddExitHook(hook func(exitCode int)) {
+	exitHooks = append(exitHooks, hook)
 }
 
 func GetRequiredEnvVar(envVarName string) string {
@@ -77,6 +112,19 @@ func GetShortBinaryName() string {
 	return filepath.Base(os.Args[0])
 }
 
+// SetDriverName records which driver is running (e.g. node), for when several drivers are built into 1 binary (see exchperf)
+func SetDriverName(name string) {
+	driverName = name
+}
+
+// GetDriverName returns the name set by SetDriverName(), or the binary name if it was not set. It is used in the report and manifest paths.
+func GetDriverName() string {
+	if driverName == "" {
+		return GetShortBinaryName()
+	}
+	return driverName
+}
+
 func IsVerbose() bool {
 	return GetEnvVarWithDefault("VERBOSE", "false") == "true"
 }
@@ -97,9 +145,11 @@ func Debug(msg string, args ...interface{}) {
 		msg += "\n"
 	}
 	//fmt.Printf("DEBUG "+GetShortBinaryName()+": "+time.Now().Format("2006.01.02 15:04:05")+" "+msg, args...)
-	errMsg := fmt.Sprintf("DEBUG "+GetShortBinaryName()+": "+time.Now().Format("2006.01.02 15:04:05")+" "+msg, args...)
+	errMsg := fmt.Sprintf("DEBUG "+GetDriverName()+": "+time.Now().Format("2006.01.02 15:04:05")+" "+msg, args...)
 	// write error msg to both the summary file and stderr
-	Append2File(EX_PERF_REPORT_FILE, errMsg)
+	if EX_PERF_REPORT_FILE != "" {
+		Append2File(EX_PERF_REPORT_FILE, errMsg)
+	}
 	fmt.Print(errMsg)
 }
 
@@ -107,15 +157,21 @@ func Error(msg string, args ...interface{}) {
 	if !strings.HasSuffix(msg, "\n") {
 		msg += "\n"
 	}
+	atomic.AddInt64(&errorCount, 1)
 	errMsg := fmt.Sprintf("Error:==> "+time.Now().Format("2006.01.02 15:04:05")+" "+msg, args...)
-	// write error msg to both the summary file and stderr
-	Append2File(EX_PERF_REPORT_FILE, errMsg)
+	// write error msg to both the summary file and stderr. If the driver has not set the report file yet, Append2File() would call Fatal(), which calls us again.
+	if EX_PERF_REPORT_FILE != "" {
+		Append2File(EX_PERF_REPORT_FILE, errMsg)
+	}
 	//fmt.Fprint(os.Stderr, errMsg)  <- pssh doesn't seem to return stderr to the screen, so send to stdout instead
 	fmt.Print(errMsg)
 }
 
 func Fatal(exitCode int, msg string, args ...interface{}) {
 	Error(msg, args...)
+	for _, hook := range exitHooks {
+		hook(exitCode)
+	}
 	os.Exit(exitCode)
 }
 
@@ -223,7 +279,7 @@ func GetExchangeUrl() string {
 }
 
 func GetHTTPClient() *http.Client {
-	if os.Getenv("EX_PERF_DONT_REUSE_HTTP_CLIENT") == "" {
+	if !ConfigBool("EX_PERF_DONT_REUSE_HTTP_CLIENT") {
 		// reuse the 1 global client
 		if HttpClient == nil {
 			HttpClient = NewHTTPClient()
@@ -237,50 +293,62 @@ func GetHTTPClient() *http.Client {
 
 // Common function for getting an HTTP client connection object.
 func NewHTTPClient() *http.Client {
-	// This env var should only be used in our test environments or in an emergency when there is a problem with the SSL certificate of a horizon service.
-	skipSSL := false
-	if os.Getenv("HZN_SSL_SKIP_VERIFY") != "" {
-		skipSSL = true
-	}
-
 	httpClient := &http.Client{
 		// remember that this timeout is for the whole request, including
 		// body reading. This means that you must set the timeout according
 		// to the total payload size you expect
-		Timeout: time.Second * time.Duration(HTTPRequestTimeoutS),
-		Transport: &http.Transport{
-			Dial: (&net.Dialer{
-				Timeout:   20 * time.Second,
-				KeepAlive: 60 * time.Second,
-			}).Dial,
-			TLSHandshakeTimeout:   20 * time.Second,
-			ResponseHeaderTimeout: 20 * time.Second,
-			ExpectContinueTimeout: 8 * time.Second,
-			MaxIdleConns:          MaxHTTPIdleConnections,
-			IdleConnTimeout:       HTTPIdleConnectionTimeoutS * time.Second,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: skipSSL,
-			},
-		},
-	}
-	if ca := os.Getenv("CURL_CA_BUNDLE"); ca != "" {
-		TrustIcpCert(httpClient, ca)
+		Timeout:   time.Second * time.Duration(HTTPRequestTimeoutS),
+		Transport: NewHTTPTransport(),
 	}
-
 	return httpClient
 }
 
-//TrustIcpCert adds the icp cert file to be trusted in calls made by the given http client
-func TrustIcpCert(httpClient *http.Client, certPath string) {
-	icpCert, err := ioutil.ReadFile(certPath)
-	if err != nil {
-		Fatal(HTTP_ERROR, "Encountered error reading ICP cert file %v: %v", certPath, err)
+// NewHTTPTransport returns the transport of the HTTP client, so we can experiment with how the connection behavior of many agents
+// affects the exchange. These env vars control it:
+//
+//	EX_PERF_HTTP_MAX_IDLE_CONNS: max idle connections kept in the pool (default: 20)
+//	EX_PERF_HTTP_MAX_IDLE_CONNS_PER_HOST: max idle connections kept to the exchange (default: the same as EX_PERF_HTTP_MAX_IDLE_CONNS)
+//	EX_PERF_HTTP_IDLE_CONN_TIMEOUT_S: how long an idle connection is kept (default: 120)
+//	EX_PERF_HTTP_KEEPALIVE_S: the tcp keepalive period (default: 60)
+//	EX_PERF_HTTP_DISABLE_KEEPALIVES: if set, a new connection is opened for every request
+//	EX_PERF_HTTP_DISABLE_COMPRESSION: if set, do not ask for gzip responses
+//	EX_PERF_HTTP2: if set, use HTTP/2 when the exchange supports it (https only)
+//
+// The connection reuse stats are in GetConnStats().
+func NewHTTPTransport() *http.Transport {
+	maxIdleConns := ConfigInt("EX_PERF_HTTP_MAX_IDLE_CONNS")
+	maxIdleConnsPerHost := ConfigInt("EX_PERF_HTTP_MAX_IDLE_CONNS_PER_HOST")
+	if maxIdleConnsPerHost == 0 {
+		maxIdleConnsPerHost = maxIdleConns // the default of 2 would make most requests open a new connection
+	}
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   20 * time.Second,
+			KeepAlive: Seconds2Duration(ConfigInt("EX_PERF_HTTP_KEEPALIVE_S")),
+		}).DialContext,
+		TLSHandshakeTimeout:   20 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		ExpectContinueTimeout: 8 * time.Second,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       Seconds2Duration(ConfigInt("EX_PERF_HTTP_IDLE_CONN_TIMEOUT_S")),
+		DisableKeepAlives:     ConfigBool("EX_PERF_HTTP_DISABLE_KEEPALIVES"),
+		DisableCompression:    ConfigBool("EX_PERF_HTTP_DISABLE_COMPRESSION"),
+		TLSClientConfig:       NewTLSConfig(), // see tls.go for the TLS settings
+	}
+	if ConfigBool("EX_PERF_HTTP2") {
+		transport.ForceAttemptHTTP2 = true // needed because we set our own dialer and TLS config
+	} else {
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{} // this is how to turn off HTTP/2 in the transport
 	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(icpCert)
+	return transport
+}
 
+// TrustIcpCert adds the icp cert file to be trusted in calls made by the given http client. It replaces the system's CA certs (to add
+// a CA cert to them instead, use EX_PERF_TLS_CA_FILE). NewHTTPClient() already does this for CURL_CA_BUNDLE.
+func TrustIcpCert(httpClient *http.Client, certPath string) {
 	transport := httpClient.Transport.(*http.Transport)
-	transport.TLSClientConfig.RootCAs = caCertPool
+	transport.TLSClientConfig.RootCAs = AppendCACert(x509.NewCertPool(), certPath)
 }
 
 /* not currently used, because retries need to use a new request too...
@@ -288,7 +356,7 @@ func invokeRestApiWithRetry(httpClient *http.Client, req *http.Request, doContin
 	retryCount := 0
 	for {
 		retryCount++
-		TotalOps++
+		atomic.AddInt64(&totalOps, 1)
 		resp, err := httpClient.Do(req)
 		if err != nil {
 			if IsRetryableError(err) {
@@ -400,7 +468,7 @@ func isGoodCode(actualHttpCode int, goodHttpCodes []int) bool {
 
 // ExchangeGet runs a GET to the specified service api and fills in the specified json respStruct. If the respStruct is just a string, fill in the raw json.
 // If the list of goodHttpCodes is not empty and none match the actual http code, it will exit with an error. Otherwise the actual code is returned.
-func ExchangeGet(urlSuffix, credentials string, goodHttpCodes []int, respStruct interface{}) (httpCode int) {
+func ExchangeGet(urlSuffix string, credentials Credentials, goodHttpCodes []int, respStruct interface{}) (httpCode int) {
 	url := GetExchangeUrl() + "/" + urlSuffix
 	apiMsg := http.MethodGet + " " + url
 
@@ -411,6 +479,7 @@ func ExchangeGet(urlSuffix, credentials string, goodHttpCodes []int, respStruct
 	// Loop for potential retries
 	retryCount := 0
 	var resp *http.Response
+	var timing *requestTiming
 	for {
 		// Create the request
 		req, err := http.NewRequest(http.MethodGet, url, nil)
@@ -419,15 +488,15 @@ func ExchangeGet(urlSuffix, credentials string, goodHttpCodes []int, respStruct
 			return
 		}
 		req.Header.Add("Accept", "application/json")
-		if credentials != "" {
-			req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(credentials))))
+		if credentials != nil {
+			credentials.AddAuth(req)
 		}
 
 		// Run it
 		//resp := invokeRestApiWithRetry(httpClient, req, true)
-		TotalOps++
+		countOp(credentials)
 		retryCount++
-		resp, err = httpClient.Do(req)
+		resp, timing, err = doRequest(httpClient, req)
 		retry, keepGoing := IsRetryable(resp, req, err, retryCount, true)
 		if retry {
 			continue
@@ -443,6 +512,7 @@ func ExchangeGet(urlSuffix, credentials string, goodHttpCodes []int, respStruct
 		Error("failed to read body response from %s: %v", apiMsg, err)
 		return
 	}
+	recordRoute(http.MethodGet, urlSuffix, timing)
 	httpCode = resp.StatusCode
 	Verbose("HTTP code: %d", httpCode)
 	if !isGoodCode(httpCode, append(goodHttpCodes, 200)) {
@@ -480,7 +550,7 @@ func ExchangeGet(urlSuffix, credentials string, goodHttpCodes []int, respStruct
 // ExchangeP runs a PUT, POST, or PATCH to the exchange api to create of update a resource. If body is a string, it will be given to the exchange
 // as json. Otherwise the struct will be marshaled to json.
 // If the list of goodHttpCodes is not empty and none match the actual http code, it will exit with an error. Otherwise the actual code is returned.
-func ExchangeP(method string, urlSuffix, credentials string, goodHttpCodes []int, body, respStruct interface{}, doContinue bool) (httpCode int) {
+func ExchangeP(method string, urlSuffix string, credentials Credentials, goodHttpCodes []int, body, respStruct interface{}, doContinue bool) (httpCode int) {
 	url := GetExchangeUrl() + "/" + urlSuffix
 	apiMsg := method + " " + url
 
@@ -491,6 +561,7 @@ func ExchangeP(method string, urlSuffix, credentials string, goodHttpCodes []int
 	// Loop for potential retries
 	retryCount := 0
 	var resp *http.Response
+	var timing *requestTiming
 	for {
 		// Prepare body
 		var requestBody io.Reader
@@ -521,14 +592,14 @@ func ExchangeP(method string, urlSuffix, credentials string, goodHttpCodes []int
 		req.Header.Add("Accept", "application/json")
 		req.Header.Add("Content-Type", "application/json")
 
-		if credentials != "" {
-			req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(credentials))))
+		if credentials != nil {
+			credentials.AddAuth(req)
 		} // else it is an anonymous call
 
 		// Run it
-		TotalOps++
+		countOp(credentials)
 		retryCount++
-		resp, err = httpClient.Do(req)
+		resp, timing, err = doRequest(httpClient, req)
 		retry, keepGoing := IsRetryable(resp, req, err, retryCount, doContinue)
 		if retry {
 			continue
@@ -544,6 +615,7 @@ func ExchangeP(method string, urlSuffix, credentials string, goodHttpCodes []int
 		MaybeFatal(doContinue, HTTP_ERROR, "failed to read body response from %s: %v", apiMsg, err)
 		return
 	}
+	recordRoute(method, urlSuffix, timing)
 	httpCode = resp.StatusCode
 	Verbose("HTTP code: %d", httpCode)
 	if !isGoodCode(httpCode, append(goodHttpCodes, 201)) {
@@ -582,7 +654,7 @@ func ExchangeP(method string, urlSuffix, credentials string, goodHttpCodes []int
 
 // ExchangeDelete deletes a resource via the exchange api.
 // If the list of goodHttpCodes is not empty and none match the actual http code, it will exit with an error. Otherwise the actual code is returned.
-func ExchangeDelete(urlSuffix, credentials string, goodHttpCodes []int) (httpCode int) {
+func ExchangeDelete(urlSuffix string, credentials Credentials, goodHttpCodes []int) (httpCode int) {
 	url := GetExchangeUrl() + "/" + urlSuffix
 	apiMsg := http.MethodDelete + " " + url
 
@@ -593,6 +665,7 @@ func ExchangeDelete(urlSuffix, credentials string, goodHttpCodes []int) (httpCod
 	// Loop for potential retries
 	retryCount := 0
 	var resp *http.Response
+	var timing *requestTiming
 	for {
 		// Create the request
 		req, err := http.NewRequest(http.MethodDelete, url, nil)
@@ -600,13 +673,15 @@ func ExchangeDelete(urlSuffix, credentials string, goodHttpCodes []int) (httpCod
 			Error("%s new request failed: %v", apiMsg, err)
 			return
 		}
-		req.Header.Add("Authorization", fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(credentials))))
+		if credentials != nil {
+			credentials.AddAuth(req)
+		}
 
 		// Run it
 		//resp := invokeRestApiWithRetry(httpClient, req, true)
-		TotalOps++
+		countOp(credentials)
 		retryCount++
-		resp, err = httpClient.Do(req)
+		resp, timing, err = doRequest(httpClient, req)
 		retry, keepGoing := IsRetryable(resp, req, err, retryCount, true)
 		if retry {
 			continue
@@ -617,6 +692,7 @@ func ExchangeDelete(urlSuffix, credentials string, goodHttpCodes []int) (httpCod
 		break
 	}
 	// delete never returns a body
+	recordRoute(http.MethodDelete, urlSuffix, timing)
 	httpCode = resp.StatusCode
 	Verbose("HTTP code: %d", httpCode)
 	if !isGoodCode(httpCode, append(goodHttpCodes, 204)) {
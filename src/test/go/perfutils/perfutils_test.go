@@ -0,0 +1,417 @@
+package perfutils
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+var testCreds = BasicAuth{Org: "o", User: "u", Password: "p"}
+
+func TestMain(m *testing.M) {
+	// Do not sleep between retries, and keep the number of retries small
+	RetrySleep = 0
+	RetryMax = 2
+	reportDir, err := ioutil.TempDir("", "perfutils-test")
+	if err != nil {
+		panic(err)
+	}
+	EX_PERF_REPORT_FILE = filepath.Join(reportDir, "test.summary")
+	code := m.Run()
+	os.RemoveAll(reportDir)
+	os.Exit(code)
+}
+
+// testExchange is an httptest server that records the requests it gets and responds with the codes in responses (the last one is repeated)
+type testExchange struct {
+	*httptest.Server
+	numRequests int64
+	responses   []int
+	body        string
+	lastRequest *http.Request
+	lastBody    string
+}
+
+func newTestExchange(t *testing.T, body string, responses ...int) *testExchange {
+	te := &testExchange{responses: responses, body: body}
+	te.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&te.numRequests, 1)
+		bodyBytes, _ := ioutil.ReadAll(r.Body)
+		te.lastRequest = r
+		te.lastBody = string(bodyBytes)
+		code := te.responses[MinInt(int(n), len(te.responses))-1]
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if code != 204 {
+			w.Write([]byte(te.body))
+		}
+	}))
+	t.Cleanup(te.Close)
+	os.Setenv("HZN_EXCHANGE_URL", te.URL+"/v1")
+	return te
+}
+
+func (te *testExchange) requests() int {
+	return int(atomic.LoadInt64(&te.numRequests))
+}
+
+// errorsDuring returns how many errors were reported by Error() while running f
+func errorsDuring(f func()) int {
+	before := GetErrorCount()
+	f()
+	return GetErrorCount() - before
+}
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		err  string
+		want bool
+	}{
+		{"Get http://x/v1: dial tcp: i/o timeout", true},
+		{"net/http: request canceled (Client.Timeout exceeded while awaiting headers)", true},
+		{"dial tcp 127.0.0.1:1: connect: connection refused", true},
+		{"read tcp: connection reset by peer", true},
+		{"http: ContentLength=10 with Body length 0", true},
+		{"x509: certificate signed by unknown authority", false},
+		{"unsupported protocol scheme", false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryableError(errors.New(tt.err)); got != tt.want {
+			t.Errorf("IsRetryableError(%q) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestIsRetryableHttpCode(t *testing.T) {
+	for _, code := range []int{502, 503, 504} {
+		if !IsRetryableHttpCode(code) {
+			t.Errorf("IsRetryableHttpCode(%d) = false, want true", code)
+		}
+	}
+	for _, code := range []int{200, 201, 400, 401, 404, 500} {
+		if IsRetryableHttpCode(code) {
+			t.Errorf("IsRetryableHttpCode(%d) = true, want false", code)
+		}
+	}
+}
+
+func TestIsGoodCode(t *testing.T) {
+	tests := []struct {
+		code  int
+		codes []int
+		want  bool
+	}{
+		{500, nil, true}, // an empty list means anything is ok
+		{500, []int{}, true},
+		{404, []int{404}, true},
+		{200, []int{404, 200}, true},
+		{200, []int{404}, false},
+	}
+	for _, tt := range tests {
+		if got := isGoodCode(tt.code, tt.codes); got != tt.want {
+			t.Errorf("isGoodCode(%d, %v) = %v, want %v", tt.code, tt.codes, got, tt.want)
+		}
+	}
+}
+
+func TestTrimOrg(t *testing.T) {
+	tests := map[string]string{"myorg/mynode": "mynode", "mynode": "mynode", "": ""}
+	for id, want := range tests {
+		if got := TrimOrg(id); got != want {
+			t.Errorf("TrimOrg(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestAddOrg(t *testing.T) {
+	os.Setenv("HZN_ORG_ID", "myorg")
+	defer os.Unsetenv("HZN_ORG_ID")
+	tests := map[string]string{"mynode": "myorg/mynode", "otherorg/mynode": "otherorg/mynode"}
+	for id, want := range tests {
+		if got := AddOrg(id); got != want {
+			t.Errorf("AddOrg(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestRetryCounts(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []int
+		wantRequests int
+		wantCode     int
+		wantErrors   int
+	}{
+		{"no retry", []int{200}, 1, 200, 0},
+		{"retry then succeed", []int{503, 502, 200}, 3, 200, 0},
+		{"over retry max", []int{503}, RetryMax + 1, HTTP_CLIENT_ERROR, 1},
+		{"not retryable", []int{500}, 1, 500, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			te := newTestExchange(t, `{}`, tt.responses...)
+			opsBefore := GetTotalOps()
+			var httpCode int
+			numErrors := errorsDuring(func() { httpCode = ExchangeGet("orgs/myorg", testCreds, nil, nil) })
+			if httpCode != tt.wantCode {
+				t.Errorf("http code = %d, want %d", httpCode, tt.wantCode)
+			}
+			if te.requests() != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", te.requests(), tt.wantRequests)
+			}
+			if ops := GetTotalOps() - opsBefore; ops != tt.wantRequests {
+				t.Errorf("total ops increased by %d, want %d", ops, tt.wantRequests)
+			}
+			if numErrors != tt.wantErrors {
+				t.Errorf("errors = %d, want %d", numErrors, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestRetryAllMethods(t *testing.T) {
+	te := newTestExchange(t, `{}`, 504, 201)
+	if httpCode := ExchangeP(http.MethodPut, "orgs/myorg", testCreds, nil, `{}`, nil, true); httpCode != 201 || te.requests() != 2 {
+		t.Errorf("ExchangeP: http code = %d after %d requests, want 201 after 2", httpCode, te.requests())
+	}
+	te = newTestExchange(t, `{}`, 504, 504, 204)
+	if httpCode := ExchangeDelete("orgs/myorg", testCreds, nil); httpCode != 204 || te.requests() != 3 {
+		t.Errorf("ExchangeDelete: http code = %d after %d requests, want 204 after 3", httpCode, te.requests())
+	}
+}
+
+func TestCountedCredentials(t *testing.T) {
+	var ops int64
+	counted := CountedCredentials{Credentials: testCreds, Ops: &ops}
+	newTestExchange(t, `{}`, 503, 200, 201, 200)
+	ExchangeGet("orgs/myorg", counted, nil, nil)                           // retried once
+	ExchangeP(http.MethodPut, "orgs/myorg", counted, nil, `{}`, nil, true) // not retried
+	ExchangeGet("orgs/myorg", testCreds, nil, nil)                         // not counted
+	if ops != 3 {
+		t.Errorf("counted ops = %d, want 3 (including the retry)", ops)
+	}
+}
+
+func TestRetryConnectionRefused(t *testing.T) {
+	te := newTestExchange(t, `{}`, 200)
+	te.Close() // now the connections will be refused
+	opsBefore := GetTotalOps()
+	var httpCode int
+	numErrors := errorsDuring(func() { httpCode = ExchangeGet("orgs/myorg", testCreds, nil, nil) })
+	if httpCode != HTTP_CLIENT_ERROR {
+		t.Errorf("http code = %d, want %d", httpCode, HTTP_CLIENT_ERROR)
+	}
+	if ops := GetTotalOps() - opsBefore; ops != RetryMax+1 {
+		t.Errorf("attempts = %d, want %d", ops, RetryMax+1)
+	}
+	if numErrors != 1 {
+		t.Errorf("errors = %d, want 1", numErrors)
+	}
+}
+
+func TestGoodCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       int
+		goodCodes  []int
+		call       func(goodCodes []int) int
+		wantErrors int
+	}{
+		{"GET implicit 200", 200, []int{404}, func(c []int) int { return ExchangeGet("x", testCreds, c, nil) }, 0},
+		{"GET listed code", 404, []int{404}, func(c []int) int { return ExchangeGet("x", testCreds, c, nil) }, 0},
+		{"GET unlisted code", 404, nil, func(c []int) int { return ExchangeGet("x", testCreds, c, nil) }, 1},
+		{"P implicit 201", 201, []int{403}, func(c []int) int { return ExchangeP(http.MethodPost, "x", testCreds, c, `{}`, nil, true) }, 0},
+		{"P listed code", 403, []int{403}, func(c []int) int { return ExchangeP(http.MethodPost, "x", testCreds, c, `{}`, nil, true) }, 0},
+		{"P unlisted code", 200, nil, func(c []int) int { return ExchangeP(http.MethodPost, "x", testCreds, c, `{}`, nil, true) }, 1},
+		{"DELETE implicit 204", 204, []int{404}, func(c []int) int { return ExchangeDelete("x", testCreds, c) }, 0},
+		{"DELETE listed code", 404, []int{404}, func(c []int) int { return ExchangeDelete("x", testCreds, c) }, 0},
+		{"DELETE unlisted code", 404, nil, func(c []int) int { return ExchangeDelete("x", testCreds, c) }, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestExchange(t, `{}`, tt.code)
+			var httpCode int
+			numErrors := errorsDuring(func() { httpCode = tt.call(tt.goodCodes) })
+			if httpCode != tt.code {
+				t.Errorf("http code = %d, want %d", httpCode, tt.code)
+			}
+			if numErrors != tt.wantErrors {
+				t.Errorf("errors = %d, want %d", numErrors, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestRespStruct(t *testing.T) {
+	body := `{"nodes":{"myorg/n1":{"name":"n1"}}}`
+
+	t.Run("raw bytes", func(t *testing.T) {
+		newTestExchange(t, body, 200)
+		var resp []byte
+		ExchangeGet("orgs/myorg/nodes", testCreds, nil, &resp)
+		if string(resp) != body {
+			t.Errorf("resp = %s, want %s", resp, body)
+		}
+	})
+
+	t.Run("indented string", func(t *testing.T) {
+		newTestExchange(t, body, 200)
+		var resp string
+		ExchangeGet("orgs/myorg/nodes", testCreds, nil, &resp)
+		want := "{\n    \"nodes\": {\n        \"myorg/n1\": {\n            \"name\": \"n1\"\n        }\n    }\n}"
+		if resp != want {
+			t.Errorf("resp = %s, want %s", resp, want)
+		}
+	})
+
+	t.Run("struct", func(t *testing.T) {
+		newTestExchange(t, body, 201)
+		var resp struct {
+			Nodes map[string]struct {
+				Name string `json:"name"`
+			} `json:"nodes"`
+		}
+		ExchangeP(http.MethodPost, "orgs/myorg/patterns/p1/search", testCreds, nil, `{}`, &resp, true)
+		if resp.Nodes["myorg/n1"].Name != "n1" {
+			t.Errorf("resp = %+v, want node myorg/n1 with name n1", resp)
+		}
+	})
+
+	t.Run("bad code leaves it alone", func(t *testing.T) {
+		newTestExchange(t, body, 404)
+		resp := []byte("unchanged")
+		errorsDuring(func() { ExchangeGet("orgs/myorg/nodes", testCreds, nil, &resp) })
+		if string(resp) != "unchanged" {
+			t.Errorf("resp = %s, want it unchanged", resp)
+		}
+	})
+}
+
+func TestRequestBody(t *testing.T) {
+	te := newTestExchange(t, `{}`, 201)
+	ExchangeP(http.MethodPut, "orgs/myorg/nodes/n1", testCreds, nil, struct {
+		Token string `json:"token"`
+	}{"abc"}, nil, true)
+	if te.lastBody != `{"token":"abc"}` {
+		t.Errorf("body = %s, want the marshaled struct", te.lastBody)
+	}
+	if te.lastRequest.Method != http.MethodPut || te.lastRequest.URL.Path != "/v1/orgs/myorg/nodes/n1" {
+		t.Errorf("request = %s %s, want PUT /v1/orgs/myorg/nodes/n1", te.lastRequest.Method, te.lastRequest.URL.Path)
+	}
+	if ct := te.lastRequest.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %s, want application/json", ct)
+	}
+}
+
+func TestAuthHeader(t *testing.T) {
+	tests := []struct {
+		creds Credentials
+		want  string
+	}{
+		{BasicAuth{Org: "myorg", User: "myuser", Password: "mypw"}, "Basic " + base64.StdEncoding.EncodeToString([]byte("myorg/myuser:mypw"))},
+		{BasicAuth{Org: "root", User: "root", Password: "rootpw"}, "Basic " + base64.StdEncoding.EncodeToString([]byte("root/root:rootpw"))},
+		{NodeToken{Org: "myorg", NodeId: "n1", Token: "abc"}, "Basic " + base64.StdEncoding.EncodeToString([]byte("myorg/n1:abc"))},
+		{AgbotToken{Org: "myorg", AgbotId: "a1", Token: "def"}, "Basic " + base64.StdEncoding.EncodeToString([]byte("myorg/a1:def"))},
+		{IAMAPIKey{Org: "myorg", Key: "mykey"}, "Basic " + base64.StdEncoding.EncodeToString([]byte("myorg/iamapikey:mykey"))},
+		{BearerToken{Token: "xyz"}, "Bearer xyz"},
+	}
+	for _, tt := range tests {
+		te := newTestExchange(t, `{}`, 200)
+		ExchangeGet("x", tt.creds, nil, nil)
+		if got := te.lastRequest.Header.Get("Authorization"); got != tt.want {
+			t.Errorf("GET with %s: Authorization = %q, want %q", tt.creds, got, tt.want)
+		}
+
+		te = newTestExchange(t, `{}`, 201)
+		ExchangeP(http.MethodPost, "x", tt.creds, nil, `{}`, nil, true)
+		if got := te.lastRequest.Header.Get("Authorization"); got != tt.want {
+			t.Errorf("POST with %s: Authorization = %q, want %q", tt.creds, got, tt.want)
+		}
+
+		te = newTestExchange(t, ``, 204)
+		ExchangeDelete("x", tt.creds, nil)
+		if got := te.lastRequest.Header.Get("Authorization"); got != tt.want {
+			t.Errorf("DELETE with %s: Authorization = %q, want %q", tt.creds, got, tt.want)
+		}
+	}
+}
+
+func TestAnonymousCalls(t *testing.T) {
+	te := newTestExchange(t, `"2.1.0"`, 200)
+	ExchangeGet("admin/version", nil, nil, nil)
+	if _, ok := te.lastRequest.Header["Authorization"]; ok {
+		t.Errorf("anonymous GET sent an Authorization header")
+	}
+
+	te = newTestExchange(t, `{}`, 201)
+	ExchangeP(http.MethodPost, "admin/version", nil, nil, nil, nil, true)
+	if _, ok := te.lastRequest.Header["Authorization"]; ok {
+		t.Errorf("anonymous POST sent an Authorization header")
+	}
+	if te.lastBody != "" {
+		t.Errorf("POST with a nil body sent %q", te.lastBody)
+	}
+}
+
+func TestErrorWithoutReportFile(t *testing.T) {
+	saved := EX_PERF_REPORT_FILE
+	EX_PERF_REPORT_FILE = ""
+	defer func() { EX_PERF_REPORT_FILE = saved }()
+	// This used to recurse forever, because Append2File("") calls Fatal(), which calls Error()
+	if numErrors := errorsDuring(func() { Error("no report file yet") }); numErrors != 1 {
+		t.Errorf("errors = %d, want 1", numErrors)
+	}
+}
+
+func TestErrorWritesReportFile(t *testing.T) {
+	Error("something %s happened", "bad")
+	content, err := ioutil.ReadFile(EX_PERF_REPORT_FILE)
+	if err != nil {
+		t.Fatalf("could not read report file: %v", err)
+	}
+	if !strings.Contains(string(content), "something bad happened") {
+		t.Errorf("report file does not contain the error: %s", content)
+	}
+}
+
+func BenchmarkExchangeGet(b *testing.B) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"nodes":{"myorg/n1":{"name":"n1","pattern":"myorg/p1","lastHeartbeat":"2020-01-01T00:00:00Z"}}}`))
+	}))
+	defer ts.Close()
+	os.Setenv("HZN_EXCHANGE_URL", ts.URL+"/v1")
+	var resp struct {
+		Nodes map[string]interface{} `json:"nodes"`
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ExchangeGet("orgs/myorg/nodes/n1", NodeToken{Org: "myorg", NodeId: "n1", Token: "abc"}, nil, &resp)
+	}
+}
+
+func BenchmarkExchangeP(b *testing.B) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(201)
+		w.Write([]byte(`{"code":"ok","msg":"node updated"}`))
+	}))
+	defer ts.Close()
+	os.Setenv("HZN_EXCHANGE_URL", ts.URL+"/v1")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ExchangeP(http.MethodPost, "orgs/myorg/nodes/n1/heartbeat", NodeToken{Org: "myorg", NodeId: "n1", Token: "abc"}, nil, nil, nil, true)
+	}
+}
+
+func BenchmarkIsRetryableError(b *testing.B) {
+	err := errors.New("Post http://exchange/v1/orgs/myorg/nodes/n1/heartbeat: dial tcp 10.0.0.1:8080: connect: connection refused")
+	for i := 0; i < b.N; i++ {
+		IsRetryableError(err)
+	}
+}
@@ -0,0 +1,214 @@
+// Per-route latency stats, with a breakdown of each request into its phases, so we can tell network/ingress slowness (dns, connect,
+// TLS) apart from exchange slowness (the time to first byte after the request was sent, and reading the body).
+// A route is the method and the url with the ids replaced by placeholders, e.g. "GET orgs/{orgid}/nodes/{id}/msgs".
+package perfutils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The phases of a request
+const (
+	PHASE_DNS = iota
+	PHASE_CONNECT
+	PHASE_TLS
+	PHASE_TTFB // from when the request was written until the 1st byte of the response
+	PHASE_BODY // from when the response headers were read until the body was read
+	numPhases
+)
+
+var phaseNames = [numPhases]string{"dns", "connect", "tls", "ttfb", "body"}
+
+// requestTiming holds the phase times of 1 request. The trace callbacks can be called from other goroutines, so it is locked.
+type requestTiming struct {
+	lock          sync.Mutex
+	begin         time.Time
+	starts        [numPhases]time.Time
+	connectStarts map[string]time.Time // by address, because the dials to the IPv4 and IPv6 addresses of a dual-stack host run at the same time
+	phases        [numPhases]time.Duration
+	newConn       bool
+	recorded      bool
+}
+
+func newRequestTiming() *requestTiming {
+	return &requestTiming{begin: time.Now(), connectStarts: map[string]time.Time{}}
+}
+
+func (t *requestTiming) start(phase int) {
+	t.lock.Lock()
+	t.starts[phase] = time.Now()
+	t.lock.Unlock()
+}
+
+// end records the time since the start of the phase, and returns it (or 0 if the phase was not started)
+func (t *requestTiming) end(phase int) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.starts[phase].IsZero() {
+		return 0
+	}
+	d := time.Since(t.starts[phase])
+	t.phases[phase] += d
+	return d
+}
+
+func (t *requestTiming) startConnect(addr string) {
+	t.lock.Lock()
+	t.connectStarts[addr] = time.Now()
+	t.lock.Unlock()
+}
+
+// endConnect returns how long the connect to addr took (or 0 if it was not started). Only the successful connects are added to the connect
+// phase, because the request only waited for the dial that won, not the ones that failed or were cancelled when it did.
+func (t *requestTiming) endConnect(addr string, err error) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	start, ok := t.connectStarts[addr]
+	if !ok {
+		return 0
+	}
+	delete(t.connectStarts, addr)
+	d := time.Since(start)
+	if err == nil {
+		t.phases[PHASE_CONNECT] += d
+	}
+	return d
+}
+
+func (t *requestTiming) setNewConn(newConn bool) {
+	t.lock.Lock()
+	t.newConn = newConn
+	t.lock.Unlock()
+}
+
+// routeStats accumulates the timings of all of the requests of 1 route
+type routeStats struct {
+	latencies []time.Duration
+	phases    [numPhases]time.Duration
+	newConns  int
+}
+
+var routeStatsLock sync.Mutex
+var allRouteStats = map[string]*routeStats{}
+
+// recordRoute adds the timing of a request to the stats of its route, after the caller has read the response body.
+// Only the attempt that produced the response is recorded, not the ones that were retried.
+func recordRoute(method, urlSuffix string, t *requestTiming) {
+	if t == nil {
+		return
+	}
+	t.end(PHASE_BODY)
+	t.lock.Lock()
+	if t.recorded {
+		t.lock.Unlock()
+		return
+	}
+	t.recorded = true
+	latency := time.Since(t.begin)
+	phases, newConn := t.phases, t.newConn
+	t.lock.Unlock()
+
+	route := RouteOf(method, urlSuffix)
+	routeStatsLock.Lock()
+	defer routeStatsLock.Unlock()
+	rs := allRouteStats[route]
+	if rs == nil {
+		rs = &routeStats{}
+		allRouteStats[route] = rs
+	}
+	rs.latencies = append(rs.latencies, latency)
+	for i := range phases {
+		rs.phases[i] += phases[i]
+	}
+	if newConn {
+		rs.newConns++
+	}
+}
+
+// RouteOf returns the route of the api: the method and the url suffix with the ids replaced by placeholders. The exchange urls alternate
+// between a resource type and an id (e.g. orgs/{orgid}/nodes/{id}/msgs/{id}), except for the apis that are not under orgs (e.g. admin/version)
+// and the org-wide searches (e.g. orgs/{orgid}/search/nodes/error/all).
+func RouteOf(method, urlSuffix string) string {
+	urlSuffix = strings.SplitN(urlSuffix, "?", 2)[0]
+	segments := strings.Split(strings.Trim(urlSuffix, "/"), "/")
+	if segments[0] != "orgs" {
+		return method + " " + strings.Join(segments, "/")
+	}
+	var route []string
+	for i := 0; i < len(segments); i++ {
+		if segments[i] == "search" {
+			route = append(route, segments[i:]...)
+			break
+		}
+		route = append(route, segments[i])
+		if segments[i] == "business" && i+1 < len(segments) { // business/policies is 1 resource type
+			i++
+			route[len(route)-1] += "/" + segments[i]
+		}
+		if i+1 < len(segments) {
+			i++
+			if len(route) == 1 {
+				route = append(route, "{orgid}")
+			} else {
+				route = append(route, "{id}")
+			}
+		}
+	}
+	return method + " " + strings.Join(route, "/")
+}
+
+// RouteStats is a snapshot of the stats of 1 route
+type RouteStats struct {
+	Route    string                   `json:"route"`
+	Latency  LatencyStats             `json:"latency"`
+	Phases   map[string]time.Duration `json:"phases"` // the avg time of each phase (over all of the requests, so they add up to about the mean latency)
+	NewConns int                      `json:"newConns"`
+}
+
+// GetRouteStats returns the stats of every route called since the last ResetRouteStats(), sorted by route
+func GetRouteStats() []RouteStats {
+	routeStatsLock.Lock()
+	defer routeStatsLock.Unlock()
+	var stats []RouteStats
+	for route, rs := range allRouteStats {
+		s := RouteStats{Route: route, NewConns: rs.newConns, Phases: map[string]time.Duration{}}
+		latencies := append([]time.Duration(nil), rs.latencies...) // ComputeLatencyStats sorts it, so give it a copy
+		s.Latency = ComputeLatencyStats(latencies)
+		for i := range rs.phases {
+			s.Phases[phaseNames[i]] = rs.phases[i] / time.Duration(len(rs.latencies))
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Route < stats[j].Route })
+	return stats
+}
+
+// ResetRouteStats clears the route stats, usually right before starting the timed part of a test (with ResetTotalOps())
+func ResetRouteStats() {
+	routeStatsLock.Lock()
+	allRouteStats = map[string]*routeStats{}
+	routeStatsLock.Unlock()
+}
+
+// String returns the stats of the route in the form used in the summary files
+func (s RouteStats) String() string {
+	var phases []string
+	for _, name := range phaseNames {
+		phases = append(phases, fmt.Sprintf("%s=%.2f", name, Millis(s.Phases[name])))
+	}
+	return fmt.Sprintf("%s: n=%d new-conns=%d p50=%.2f p95=%.2f p99=%.2f max=%.2f ms, avg phases: %s ms", s.Route, s.Latency.Count, s.NewConns,
+		Millis(s.Latency.P50), Millis(s.Latency.P95), Millis(s.Latency.P99), Millis(s.Latency.Max), strings.Join(phases, " "))
+}
+
+// RouteReport returns the stats of all of the routes, 1 per line
+func RouteReport() string {
+	lines := []string{"Per-route latency:"}
+	for _, s := range GetRouteStats() {
+		lines = append(lines, "  "+s.String())
+	}
+	return strings.Join(lines, "\n")
+}
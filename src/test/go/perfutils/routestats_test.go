@@ -0,0 +1,67 @@
+package perfutils
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRouteOf(t *testing.T) {
+	tests := []struct {
+		method, urlSuffix, want string
+	}{
+		{http.MethodGet, "admin/version", "GET admin/version"},
+		{http.MethodGet, "orgs/myorg", "GET orgs/{orgid}"},
+		{http.MethodGet, "orgs/myorg/nodes", "GET orgs/{orgid}/nodes"},
+		{http.MethodPost, "orgs/myorg/nodes/n1/heartbeat", "POST orgs/{orgid}/nodes/{id}/heartbeat"},
+		{http.MethodGet, "orgs/myorg/nodes/n1/msgs", "GET orgs/{orgid}/nodes/{id}/msgs"},
+		{http.MethodDelete, "orgs/myorg/nodes/n1/msgs/42", "DELETE orgs/{orgid}/nodes/{id}/msgs/{id}"},
+		{http.MethodPut, "orgs/myorg/business/policies/bp1", "PUT orgs/{orgid}/business/policies/{id}"},
+		{http.MethodGet, "orgs/myorg/services?arch=amd64", "GET orgs/{orgid}/services"},
+		{http.MethodGet, "orgs/myorg/search/nodes/error/all", "GET orgs/{orgid}/search/nodes/error/all"},
+		{http.MethodPost, "orgs/myorg/patterns/p1/search", "POST orgs/{orgid}/patterns/{id}/search"},
+	}
+	for _, tt := range tests {
+		if got := RouteOf(tt.method, tt.urlSuffix); got != tt.want {
+			t.Errorf("RouteOf(%s, %s) = %s, want %s", tt.method, tt.urlSuffix, got, tt.want)
+		}
+	}
+}
+
+func TestRouteStats(t *testing.T) {
+	te := newTestExchange(t, `{}`, 200)
+	te.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond) // so the time to first byte is the biggest phase
+		w.Write([]byte(`{}`))
+	})
+	HttpClient = nil
+	ResetRouteStats()
+	for _, n := range []string{"n1", "n2", "n3"} {
+		ExchangeGet("orgs/myorg/nodes/"+n, testCreds, nil, nil)
+	}
+	ExchangeP(http.MethodPost, "orgs/myorg/nodes/n1/heartbeat", testCreds, []int{200}, nil, nil, true)
+
+	stats := GetRouteStats()
+	if len(stats) != 2 || stats[0].Route != "GET orgs/{orgid}/nodes/{id}" || stats[1].Route != "POST orgs/{orgid}/nodes/{id}/heartbeat" {
+		t.Fatalf("routes = %+v, want the node GET and heartbeat routes", stats)
+	}
+	get := stats[0]
+	if get.Latency.Count != 3 || get.NewConns != 1 {
+		t.Errorf("GET stats: count=%d new conns=%d, want 3 and 1", get.Latency.Count, get.NewConns)
+	}
+	if get.Phases["ttfb"] < 5*time.Millisecond || get.Phases["connect"] <= 0 || get.Phases["connect"] > get.Phases["ttfb"] {
+		t.Errorf("GET phases = %v, want ttfb >= 5ms and a smaller connect time", get.Phases)
+	}
+	var phaseSum time.Duration
+	for _, d := range get.Phases {
+		phaseSum += d
+	}
+	if phaseSum > get.Latency.Mean {
+		t.Errorf("the sum of the phases %v is more than the mean latency %v", phaseSum, get.Latency.Mean)
+	}
+
+	ResetRouteStats()
+	if stats := GetRouteStats(); len(stats) != 0 {
+		t.Errorf("after reset, route stats = %+v, want none", stats)
+	}
+}
@@ -0,0 +1,279 @@
+// Idempotent setup for the perf/scale drivers. Instead of blindly creating each resource and whitelisting the http codes that mean it
+// already existed, the drivers check whether each resource exists, create it if not, and verify that it matches what they expect.
+// Everything they set up is recorded in a manifest file, so a crashed run can be resumed (just run it again) or torn down (cleanup --manifest).
+package perfutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ExchangeResource describes 1 resource a driver needs during its setup
+type ExchangeResource struct {
+	Path         string      // the url suffix of the resource, used to get it and update it
+	CreateMethod string      // how to create it: http.MethodPost or http.MethodPut
+	CreatePath   string      // where to create it, if different from Path (e.g. services are posted to the services collection)
+	UpdateMethod string      // how to update it if it does not match, or "" if it can not be updated
+	Auth         Credentials // the credentials to use for all of the api calls
+	Body         string      // the json definition of the resource
+	Ignore       []string    // fields of Body the exchange does not return as is (e.g. token or password), so they are not verified
+	Shared       bool        // other driver instances use it too, so it must not be deleted when tearing down this instance
+	DoContinue   bool        // if false, a failure to set up this resource is fatal
+}
+
+// ManifestResource is 1 entry in the manifest
+type ManifestResource struct {
+	Path    string `json:"path"`
+	Created bool   `json:"created"` // true if this driver instance created it, false if it already existed
+	Shared  bool   `json:"shared"`
+}
+
+// SetupManifest records the resources 1 driver instance set up
+type SetupManifest struct {
+	Driver    string             `json:"driver"`
+	Namebase  string             `json:"namebase"` // everything the instance creates during its run starts with this
+	Org       string             `json:"org"`
+	Updated   time.Time          `json:"updated"`
+	SetupDone bool               `json:"setupDone"`
+	Resources []ManifestResource `json:"resources"`
+	file      string
+}
+
+// GetManifestDir returns where the manifests are kept. This is not under EX_PERF_REPORT_DIR, because wrapper.sh removes that at the beginning of each run.
+func GetManifestDir() string {
+	return ConfigString("EX_PERF_MANIFEST_DIR")
+}
+
+// LoadSetupManifest returns the manifest of this driver instance, reading it in if it was left behind by a previous run that did not finish
+func LoadSetupManifest(namebase, org string) *SetupManifest {
+	MakeDir(GetManifestDir())
+	file := filepath.Join(GetManifestDir(), namebase+".manifest")
+	m := ReadSetupManifest(file, false)
+	if m == nil {
+		m = &SetupManifest{file: file}
+	} else {
+		fmt.Printf("Resuming from manifest %s of a previous run that did not finish (setup done: %v)\n", file, m.SetupDone)
+	}
+	m.Driver = GetDriverName()
+	m.Namebase = namebase
+	m.Org = org
+	m.SetupDone = false
+	m.write()
+	return m
+}
+
+// ReadSetupManifest reads a manifest file. If it does not exist, it returns nil, unless required is true, in which case that is fatal.
+func ReadSetupManifest(file string, required bool) *SetupManifest {
+	manifestBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		Fatal(FILE_IO_ERROR, "could not read manifest %s: %v", file, err)
+	}
+	m := &SetupManifest{file: file}
+	Unmarshal(manifestBytes, m, file)
+	return m
+}
+
+// Ensure makes sure the resource exists and matches its definition, creating or updating it if necessary. Returns true if it succeeded.
+func (m *SetupManifest) Ensure(r ExchangeResource) bool {
+	exists, mismatch := r.check()
+	if !exists {
+		createMethod, createPath := r.CreateMethod, r.CreatePath
+		if createPath == "" {
+			createPath = r.Path
+		}
+		// The codes other than 201 mean someone else created it since we checked. In that case we check again to verify it.
+		httpCode := ExchangeP(createMethod, createPath, r.Auth, []int{400, 403, 409}, r.Body, nil, r.DoContinue)
+		if httpCode == 201 {
+			m.record(r, true)
+			return true
+		} else if httpCode != 400 && httpCode != 403 && httpCode != 409 {
+			return false
+		}
+		if exists, mismatch = r.check(); !exists {
+			MaybeFatal(r.DoContinue, HTTP_ERROR, "could not create %s, %s %s returned %d", r.Path, createMethod, createPath, httpCode)
+			return false
+		}
+	}
+
+	if mismatch != "" {
+		if r.UpdateMethod == "" {
+			MaybeFatal(r.DoContinue, CLI_GENERAL_ERROR, "%s already exists, but does not match what this test needs: %s", r.Path, mismatch)
+			return false
+		}
+		Debug("%s already exists, but %s, so updating it", r.Path, mismatch)
+		if httpCode := ExchangeP(r.UpdateMethod, r.Path, r.Auth, nil, r.Body, nil, r.DoContinue); httpCode != 201 {
+			return false
+		}
+	}
+	m.record(r, false)
+	return true
+}
+
+// MarkSetupDone records that all of the setup resources are there
+func (m *SetupManifest) MarkSetupDone() {
+	m.SetupDone = true
+	m.write()
+}
+
+// Remove deletes the manifest, because the driver finished and cleaned up after itself
+func (m *SetupManifest) Remove() {
+	RemoveFile(m.file)
+}
+
+// GetFile returns the path of the manifest file
+func (m *SetupManifest) GetFile() string {
+	return m.file
+}
+
+// record adds the resource to the manifest. If it is already there, it keeps the original created value.
+func (m *SetupManifest) record(r ExchangeResource, created bool) {
+	for i := range m.Resources {
+		if m.Resources[i].Path == r.Path {
+			m.Resources[i].Shared = r.Shared
+			m.write()
+			return
+		}
+	}
+	m.Resources = append(m.Resources, ManifestResource{Path: r.Path, Created: created, Shared: r.Shared})
+	m.write()
+}
+
+func (m *SetupManifest) write() {
+	m.Updated = time.Now()
+	if err := ioutil.WriteFile(m.file, []byte(MarshalIndent(m, "setup manifest")+"\n"), 0644); err != nil {
+		Fatal(FILE_IO_ERROR, "could not write manifest %s: %v", m.file, err)
+	}
+}
+
+// check gets the resource and returns whether it exists and, if it does not match the definition, a description of the difference
+func (r ExchangeResource) check() (bool, string) {
+	var resp map[string]json.RawMessage
+	httpCode := ExchangeGet(r.Path, r.Auth, []int{404}, &resp)
+	if httpCode != 200 {
+		return false, ""
+	}
+	actual := unwrapResource(resp)
+	if actual == nil {
+		return false, ""
+	}
+
+	var expected interface{}
+	Unmarshal([]byte(r.Body), &expected, "definition of "+r.Path)
+	ignore := map[string]bool{}
+	for _, field := range r.Ignore {
+		ignore[field] = true
+	}
+	return true, JsonMismatch(expected, actual, ignore, "")
+}
+
+// unwrapResource gets the resource out of the response of a GET. The exchange returns 1 resource as a map with 1 entry (whose key is
+// the id), inside a top level key (e.g. "services"). The other top level keys (e.g. lastIndex) are not maps.
+func unwrapResource(resp map[string]json.RawMessage) interface{} {
+	for _, raw := range resp {
+		var wrapper map[string]interface{}
+		if err := json.Unmarshal(raw, &wrapper); err != nil || len(wrapper) != 1 {
+			continue
+		}
+		for _, resource := range wrapper {
+			return resource
+		}
+	}
+	return nil
+}
+
+// JsonMismatch returns "" if every field in expected (other than the ignored ones) is in actual with the same value. Otherwise it describes
+// the 1st difference. Fields in actual that are not in expected are fine, because the exchange fills in defaults. Arrays must be the
+// same length, and each element is compared the same way.
+func JsonMismatch(expected, actual interface{}, ignore map[string]bool, path string) string {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return fmt.Sprintf("%s is not an object", displayPath(path))
+		}
+		keys := make([]string, 0, len(e))
+		for k := range e {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) // so the 1st difference reported is always the same one
+		for _, k := range keys {
+			if ignore[k] {
+				continue
+			}
+			if mismatch := JsonMismatch(e[k], a[k], ignore, path+"."+k); mismatch != "" {
+				return mismatch
+			}
+		}
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok || len(a) != len(e) {
+			return fmt.Sprintf("%s has %d elements instead of %d", displayPath(path), len(a), len(e))
+		}
+		for i := range e {
+			if mismatch := JsonMismatch(e[i], a[i], ignore, fmt.Sprintf("%s[%d]", path, i)); mismatch != "" {
+				return mismatch
+			}
+		}
+	default:
+		if !reflect.DeepEqual(expected, actual) {
+			return fmt.Sprintf("%s is %v instead of %v", displayPath(path), actual, expected)
+		}
+	}
+	return ""
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "the resource"
+	}
+	return path[1:]
+}
+
+// The resource definitions that both drivers need
+
+// PerfOrgResource returns the definition of the org that all of the driver instances share. Other instances (or a real user) may own it,
+// so it is only created if it does not exist. If it does, it is used as is: its fields are not verified and it is never updated.
+func PerfOrgResource(org, iamAccountId string, rootauth Credentials) ExchangeResource {
+	body := `{ "label": "perf test org", "description": "blah blah" }`
+	if iamAccountId != "" {
+		body = `{ "label": "perf test org", "description": "blah blah", "tags": { "ibmcloud_id": "` + iamAccountId + `" } }`
+	}
+	return ExchangeResource{Path: "orgs/" + org, CreateMethod: http.MethodPost, Auth: rootauth, Body: body, Ignore: []string{"label", "description", "tags"}, Shared: true}
+}
+
+// EnsurePerfOrgAndUser makes sure the org and exchange user that all of the driver instances share exist, and returns the id of the user
+// ("" for a bearer token). Setting EXCHANGE_IAM_ACCOUNT_ID (id of your cloud account) distinguishes this as an ibm public cloud environment,
+// instead of ICP. The user depends on the auth mode of userauth (see GetUserCredentials), so only that mode's env vars are needed:
+//
+//	iamapikey: normally the exchange would automatically create it the 1st time it is used. But until issue 176 is fixed we need to
+//	  explicitly create it, as user EXCHANGE_IAM_EMAIL.
+//	basic: the local exchange user of userauth (for ICP we can't play the game of associating our own org with another account)
+//	bearer: the identity provider manages the user, so it is not created
+func (m *SetupManifest) EnsurePerfOrgAndUser(org string, rootauth, userauth Credentials) string {
+	m.Ensure(PerfOrgResource(org, os.Getenv("EXCHANGE_IAM_ACCOUNT_ID"), rootauth))
+	switch c := userauth.(type) {
+	case IAMAPIKey:
+		m.Ensure(PerfUserResource(org, GetRequiredEnvVar("EXCHANGE_IAM_EMAIL"), "foobar", rootauth))
+		return "iamapikey"
+	case BasicAuth:
+		m.Ensure(PerfUserResource(org, c.User, c.Password, rootauth))
+		return c.User
+	}
+	return ""
+}
+
+// PerfUserResource returns the definition of the exchange user that all of the driver instances share
+func PerfUserResource(org, email, password string, rootauth Credentials) ExchangeResource {
+	return ExchangeResource{Path: "orgs/" + org + "/users/" + email, CreateMethod: http.MethodPost, UpdateMethod: http.MethodPut, Auth: rootauth,
+		Body: `{"password": "` + password + `", "admin": false, "email": "` + email + `"}`, Ignore: []string{"password"}, Shared: true}
+}
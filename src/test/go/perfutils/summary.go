@@ -0,0 +1,110 @@
+// Reading back the summary files the drivers write, for the report and compare commands of exchperf
+package perfutils
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Summary is the numbers read from 1 summary file
+type Summary struct {
+	File        string
+	Driver      string  // the driver that wrote it, e.g. node
+	ActiveTimeS float64 // the time spent calling the exchange (not sleeping)
+	Ops         int     // the number of api calls
+	Errors      int     // the error lines, or for the small test the failures of its benchmarks, if there are more of those
+	Routes      []RouteSummary
+}
+
+// RouteSummary is a route line of the summary file (see RouteStats.String()). The times are in ms.
+type RouteSummary struct {
+	Route              string
+	Count              int
+	P50, P95, P99, Max float64
+}
+
+var (
+	// The "Overall:" line of the node and agbot summaries
+	overallRegex = regexp.MustCompile(`^Overall: active time: ([0-9.]+) s, num ops=(\d+),`)
+	// A result line of the small test summary, e.g. "node-get        ops=100 failures=0 time=1.234 s ..."
+	benchmarkRegex = regexp.MustCompile(`^\S+\s+ops=(\d+) failures=(\d+) time=([0-9.]+) s`)
+	routeRegex     = regexp.MustCompile(`^  (\S+ \S+): n=(\d+) new-conns=\d+ p50=([0-9.]+) p95=([0-9.]+) p99=([0-9.]+) max=([0-9.]+) ms`)
+)
+
+// ReadSummary reads the numbers from the summary file. The driver is the name of the dir the file is in (e.g. <report dir>/node/x.summary
+// or <report dir>/<host>/node/x.summary), or for the summaries at the top of the report dir, the name of the file (e.g. small.summary).
+func ReadSummary(path, reportDir string) (*Summary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &Summary{File: path, Driver: filepath.Base(filepath.Dir(path))}
+	if filepath.Clean(filepath.Dir(path)) == filepath.Clean(reportDir) {
+		s.Driver = strings.TrimSuffix(filepath.Base(path), ".summary")
+	}
+	// A benchmark of the small test counts a failure even if no error was logged for it (e.g. for an unexpected 200), but most failures
+	// do log 1. So the failures are not added to the error lines: the larger of the 2 counts is used.
+	failures := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Error:==> ") {
+			s.Errors++
+		} else if m := overallRegex.FindStringSubmatch(line); m != nil {
+			s.ActiveTimeS, _ = strconv.ParseFloat(m[1], 64)
+			s.Ops, _ = strconv.Atoi(m[2])
+		} else if m := benchmarkRegex.FindStringSubmatch(line); m != nil {
+			ops, _ := strconv.Atoi(m[1])
+			benchFailures, _ := strconv.Atoi(m[2])
+			secs, _ := strconv.ParseFloat(m[3], 64)
+			s.Ops += ops
+			failures += benchFailures
+			s.ActiveTimeS += secs
+		} else if m := routeRegex.FindStringSubmatch(line); m != nil {
+			r := RouteSummary{Route: m[1]}
+			r.Count, _ = strconv.Atoi(m[2])
+			r.P50, _ = strconv.ParseFloat(m[3], 64)
+			r.P95, _ = strconv.ParseFloat(m[4], 64)
+			r.P99, _ = strconv.ParseFloat(m[5], 64)
+			r.Max, _ = strconv.ParseFloat(m[6], 64)
+			s.Routes = append(s.Routes, r)
+		}
+	}
+	s.Errors = MaxInt(s.Errors, failures)
+	return s, scanner.Err()
+}
+
+// ReadSummaries reads all of the summary files under reportDir, sorted by path
+func ReadSummaries(reportDir string) ([]*Summary, error) {
+	var paths []string
+	err := filepath.Walk(reportDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".summary") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var summaries []*Summary
+	for _, path := range paths {
+		s, err := ReadSummary(path, reportDir)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, nil
+}
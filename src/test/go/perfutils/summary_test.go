@@ -0,0 +1,63 @@
+package perfutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSummaries(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"host1/node/perf1-node.summary": `Simulated 3 nodes for 2 heartbeats
+Error:==> 2026.10.18 17:33:57 bad HTTP code 500 calling GET orgs/o/nodes/n1
+Overall: active time: 2.500000 s, num ops=100, avg=0.025000 s/op, avg iteration delta=59.997702 s
+Connections: requests=100, new=1, reused=99 (99.0%), http2 responses=0, avg connect=0.10 ms, TLS handshakes=0, avg TLS handshake=0.00 ms
+Per-route latency:
+  GET admin/version: n=3 new-conns=1 p50=0.20 p95=0.25 p99=0.26 max=0.30 ms, avg phases: dns=0.00 connect=0.03 tls=0.00 ttfb=0.13 body=0.01 ms
+  POST orgs/{orgid}/nodes/{id}/heartbeat: n=6 new-conns=0 p50=1.20 p95=2.50 p99=3.00 max=3.10 ms, avg phases: dns=0.00 connect=0.00 tls=0.00 ttfb=1.10 body=0.01 ms
+`,
+		"small.summary": `node-get        ops=200 failures=0 time=1.000 s throughput=200.0 ops/s latency: n=200 mean=5.00 ms
+  GET orgs/{orgid}/nodes/{id}: n=200 new-conns=0 p50=4.00 p95=9.00 p99=12.00 max=15.00 ms, avg phases: dns=0.00 connect=0.00 tls=0.00 ttfb=4.50 body=0.10 ms
+heartbeat       ops=100 failures=1 time=0.500 s throughput=200.0 ops/s latency: n=100 mean=5.00 ms
+`,
+		"small2.summary": `Error:==> 2024.01.02 03:04:05 bad HTTP code 500 from GET orgs/myorg/nodes/n1, output: {}
+node-get        ops=200 failures=1 time=1.000 s throughput=200.0 ops/s latency: n=200 mean=5.00 ms
+`,
+		"node/notes.txt": "not a summary",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summaries, err := ReadSummaries(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 3 {
+		t.Fatalf("read %d summaries, want 3", len(summaries))
+	}
+
+	node := summaries[0]
+	if node.Driver != "node" || node.Ops != 100 || node.ActiveTimeS != 2.5 || node.Errors != 1 || len(node.Routes) != 2 {
+		t.Errorf("node summary = %+v", node)
+	}
+	want := RouteSummary{Route: "POST orgs/{orgid}/nodes/{id}/heartbeat", Count: 6, P50: 1.2, P95: 2.5, P99: 3, Max: 3.1}
+	if len(node.Routes) == 2 && node.Routes[1] != want {
+		t.Errorf("route = %+v, want %+v", node.Routes[1], want)
+	}
+
+	small := summaries[1]
+	if small.Driver != "small" || small.Ops != 300 || small.ActiveTimeS != 1.5 || small.Errors != 1 || len(small.Routes) != 1 {
+		t.Errorf("small summary = %+v", small)
+	}
+	// The failure that logged an error is not counted twice
+	if small2 := summaries[2]; small2.Errors != 1 {
+		t.Errorf("small2 summary has %d errors, want 1", small2.Errors)
+	}
+}
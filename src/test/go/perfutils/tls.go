@@ -0,0 +1,105 @@
+// TLS settings of the perf HTTP client, so we can test exchange deployments behind hardened ingress
+package perfutils
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// NewTLSConfig returns the TLS config for the HTTP client, according to these env vars:
+//
+//	HZN_SSL_SKIP_VERIFY: do not verify the exchange's certificate. Should only be used in test environments or in an emergency.
+//	CURL_CA_BUNDLE: a PEM file of CA certs to trust instead of the system's
+//	EX_PERF_TLS_CA_FILE: a PEM file of CA certs to trust in addition to the system's (or CURL_CA_BUNDLE's)
+//	EX_PERF_TLS_CLIENT_CERT and EX_PERF_TLS_CLIENT_KEY: PEM files of the client cert and key, for mutual TLS
+//	EX_PERF_TLS_PIN_SHA256: comma-separated hex sha256 hashes of the DER encoded server cert. The exchange's cert must match 1 of them.
+//	EX_PERF_TLS_MIN_VERSION: the minimum TLS version to use: 1.0, 1.1, 1.2 (the default), or 1.3
+func NewTLSConfig() *tls.Config {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: os.Getenv("HZN_SSL_SKIP_VERIFY") != "",
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if minVersion := os.Getenv("EX_PERF_TLS_MIN_VERSION"); minVersion != "" {
+		versions := map[string]uint16{"1.0": tls.VersionTLS10, "1.1": tls.VersionTLS11, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+		if v, ok := versions[minVersion]; ok {
+			tlsConfig.MinVersion = v
+		} else {
+			Fatal(CLI_INPUT_ERROR, "invalid EX_PERF_TLS_MIN_VERSION '%s', must be 1.0, 1.1, 1.2, or 1.3", minVersion)
+		}
+	}
+
+	// CURL_CA_BUNDLE can be exported in our parent if a self-signed cert is needed. It replaces the system's CA certs.
+	if caBundle := os.Getenv("CURL_CA_BUNDLE"); caBundle != "" {
+		tlsConfig.RootCAs = AppendCACert(x509.NewCertPool(), caBundle)
+	}
+	if caFile := os.Getenv("EX_PERF_TLS_CA_FILE"); caFile != "" {
+		tlsConfig.RootCAs = AppendCACert(tlsConfig.RootCAs, caFile)
+	}
+
+	certFile, keyFile := os.Getenv("EX_PERF_TLS_CLIENT_CERT"), os.Getenv("EX_PERF_TLS_CLIENT_KEY")
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			Fatal(CLI_INPUT_ERROR, "EX_PERF_TLS_CLIENT_CERT and EX_PERF_TLS_CLIENT_KEY must both be set for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			Fatal(FILE_IO_ERROR, "could not load client cert %s and key %s: %v", certFile, keyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if pins := os.Getenv("EX_PERF_TLS_PIN_SHA256"); pins != "" {
+		tlsConfig.VerifyConnection = pinnedCertVerifier(strings.Split(pins, ","))
+	}
+	return tlsConfig
+}
+
+// AppendCACert adds the certs in the PEM file caFile to pool and returns it. If pool is nil, they are added to the system's CA certs.
+func AppendCACert(pool *x509.CertPool, caFile string) *x509.CertPool {
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		Fatal(FILE_IO_ERROR, "could not read CA file %s: %v", caFile, err)
+	}
+	if pool == nil {
+		if pool, err = x509.SystemCertPool(); err != nil {
+			Verbose("could not load the system cert pool, so only trusting %s: %v", caFile, err)
+			pool = x509.NewCertPool()
+		}
+	}
+	if !pool.AppendCertsFromPEM(caPEM) {
+		Fatal(CLI_INPUT_ERROR, "no PEM certs found in CA file %s", caFile)
+	}
+	return pool
+}
+
+// CertSHA256 returns the hex sha256 hash of the DER encoded cert, the form EX_PERF_TLS_PIN_SHA256 expects
+func CertSHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// pinnedCertVerifier returns a tls.Config.VerifyConnection function that fails the handshake unless the server's cert hash is 1 of pins.
+// This is checked in addition to the normal verification (and even if HZN_SSL_SKIP_VERIFY is set).
+func pinnedCertVerifier(pins []string) func(tls.ConnectionState) error {
+	pinned := map[string]bool{}
+	for _, pin := range pins {
+		pinned[strings.ToLower(strings.ReplaceAll(strings.TrimSpace(pin), ":", ""))] = true // also accept the colon-separated form openssl shows
+	}
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("the server did not present a cert to check against EX_PERF_TLS_PIN_SHA256")
+		}
+		if hash := CertSHA256(cs.PeerCertificates[0]); !pinned[hash] {
+			return fmt.Errorf("the sha256 hash %s of the cert of %s does not match EX_PERF_TLS_PIN_SHA256", hash, cs.ServerName)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,131 @@
+package perfutils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePEM writes the PEM block to a file in dir and returns its path
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newClientCert creates a self-signed client cert and key, writes them to dir, and returns their paths and the cert
+func newClientCert(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "perf-client"}, NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour), KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, dir, "client.crt", "CERTIFICATE", der), writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDer), cert
+}
+
+// setTLSEnv sets the env vars (unsetting all of the other TLS and transport ones), and makes the next api call create a new client that uses them
+func setTLSEnv(t *testing.T, env map[string]string) {
+	for _, name := range []string{"HZN_SSL_SKIP_VERIFY", "CURL_CA_BUNDLE", "EX_PERF_TLS_CA_FILE", "EX_PERF_TLS_CLIENT_CERT", "EX_PERF_TLS_CLIENT_KEY", "EX_PERF_TLS_PIN_SHA256", "EX_PERF_TLS_MIN_VERSION",
+		"EX_PERF_HTTP_DISABLE_KEEPALIVES", "EX_PERF_HTTP2"} {
+		os.Unsetenv(name)
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+	HttpClient = nil
+	t.Cleanup(func() {
+		for name := range env {
+			os.Unsetenv(name)
+		}
+		HttpClient = nil
+	})
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := newClientCert(t, dir)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// only respond with success if the client presented our client cert
+		if len(r.TLS.PeerCertificates) > 0 && r.TLS.PeerCertificates[0].Equal(clientCert) {
+			w.Write([]byte(`{}`))
+		} else {
+			w.WriteHeader(401)
+		}
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	ts.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+	os.Setenv("HZN_EXCHANGE_URL", ts.URL+"/v1")
+	caFile := writePEM(t, dir, "ca.crt", "CERTIFICATE", ts.Certificate().Raw)
+	serverPin := CertSHA256(ts.Certificate())
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantCode int
+	}{
+		{"untrusted server cert", map[string]string{}, HTTP_CLIENT_ERROR},
+		{"skip verify", map[string]string{"HZN_SSL_SKIP_VERIFY": "1"}, 401},
+		{"appended CA", map[string]string{"EX_PERF_TLS_CA_FILE": caFile}, 401},
+		{"mutual TLS", map[string]string{"EX_PERF_TLS_CA_FILE": caFile, "EX_PERF_TLS_CLIENT_CERT": certFile, "EX_PERF_TLS_CLIENT_KEY": keyFile}, 200},
+		{"matching pin", map[string]string{"HZN_SSL_SKIP_VERIFY": "1", "EX_PERF_TLS_PIN_SHA256": "0000," + serverPin}, 401},
+		{"mismatched pin", map[string]string{"EX_PERF_TLS_CA_FILE": caFile, "EX_PERF_TLS_PIN_SHA256": "0000"}, HTTP_CLIENT_ERROR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTLSEnv(t, tt.env)
+			var httpCode int
+			errorsDuring(func() { httpCode = ExchangeGet("admin/version", nil, []int{401}, nil) })
+			if httpCode != tt.wantCode {
+				t.Errorf("http code = %d, want %d", httpCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) }))
+	ts.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	ts.StartTLS()
+	defer ts.Close()
+	os.Setenv("HZN_EXCHANGE_URL", ts.URL+"/v1")
+
+	setTLSEnv(t, map[string]string{"HZN_SSL_SKIP_VERIFY": "1", "EX_PERF_TLS_MIN_VERSION": "1.2"})
+	if httpCode := ExchangeGet("admin/version", nil, nil, nil); httpCode != 200 {
+		t.Errorf("with min version 1.2: http code = %d, want 200", httpCode)
+	}
+
+	setTLSEnv(t, map[string]string{"HZN_SSL_SKIP_VERIFY": "1", "EX_PERF_TLS_MIN_VERSION": "1.3"})
+	var httpCode int
+	errorsDuring(func() { httpCode = ExchangeGet("admin/version", nil, nil, nil) })
+	if httpCode != HTTP_CLIENT_ERROR {
+		t.Errorf("with min version 1.3 and a TLS 1.2 server: http code = %d, want %d", httpCode, HTTP_CLIENT_ERROR)
+	}
+}
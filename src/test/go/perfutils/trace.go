@@ -0,0 +1,287 @@
+// Traces of real agent traffic, recorded by the record command of exchperf (a proxy in front of the exchange) and replayed at scale by the
+// replay driver. A trace file has 1 json TraceEntry per line. The ids in the urls and bodies are replaced by placeholders (e.g. {node1}),
+// so the replay can map them to its own ids, and the credentials are never written: the actors are identified by placeholders too, and
+// the token and password fields of the bodies are redacted.
+package perfutils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The actors of a trace entry that are not a placeholder of the form {orgN}/{id}
+const (
+	ACTOR_ANONYMOUS = ""
+	ACTOR_ROOT      = "root"
+	ACTOR_USER      = "user" // an exchange user authenticating with an IAM api key or a bearer token
+)
+
+// REDACTED replaces the value of the token and password fields in the recorded bodies
+const REDACTED = "{redacted}"
+
+// TraceEntry is 1 recorded api call
+type TraceEntry struct {
+	Actor     string          `json:"actor,omitempty"` // who made the call: {orgN}/{id} (the org and id of the basic auth), ACTOR_ROOT, ACTOR_USER, or ACTOR_ANONYMOUS
+	OffsetMs  int64           `json:"offsetMs"`        // when it was made, relative to the start of the recording
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`           // the url suffix, with the ids replaced by placeholders
+	Body      json.RawMessage `json:"body,omitempty"` // the json body with the ids replaced by placeholders, or empty if there was none (or it was not json)
+	Status    int             `json:"status"`
+	LatencyMs float64         `json:"latencyMs"`
+}
+
+// ActorId returns the placeholder name of the id the call was authenticated as (e.g. node1), or "" if it was not a basic auth id
+func (e TraceEntry) ActorId() string {
+	parts := strings.SplitN(e.Actor, "/", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	return strings.Trim(parts[1], "{}")
+}
+
+// TraceRecorder writes the api calls to a trace file
+type TraceRecorder struct {
+	lock    sync.Mutex
+	file    *os.File
+	start   time.Time
+	ids     map[string]string // the placeholder of each org, and of each id (keyed by <org>/<id>)
+	counts  map[string]int    // how many placeholders of each kind have been used
+	entries int
+}
+
+// NewTraceRecorder creates the trace file, replacing it if it exists
+func NewTraceRecorder(path string) (*TraceRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TraceRecorder{file: file, start: time.Now(), ids: map[string]string{}, counts: map[string]int{}}, nil
+}
+
+// Record writes the call to the trace. urlSuffix is the part of the url after the exchange url (e.g. orgs/myorg/nodes/mynode?x=y),
+// and body is the request body.
+func (r *TraceRecorder) Record(req *http.Request, urlSuffix string, body []byte, status int, latency time.Duration) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// Do the path before the actor, so the placeholders of the nodes and agbots are named after their kind
+	path, org := r.templatePath(urlSuffix)
+	e := TraceEntry{
+		Method:    req.Method,
+		Path:      path,
+		Actor:     r.templateActor(req),
+		OffsetMs:  time.Since(r.start).Milliseconds() - latency.Milliseconds(),
+		Body:      r.templateBody(body, org),
+		Status:    status,
+		LatencyMs: Millis(latency),
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	r.entries++
+	return nil
+}
+
+// Close closes the trace file, and returns how many calls were recorded
+func (r *TraceRecorder) Close() (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.entries, r.file.Close()
+}
+
+// placeholder returns the placeholder of the org or id, creating it if this is the 1st time it was seen
+func (r *TraceRecorder) placeholder(key, kind string) string {
+	if p, ok := r.ids[key]; ok {
+		return p
+	}
+	r.counts[kind]++
+	p := "{" + kind + strconv.Itoa(r.counts[kind]) + "}"
+	r.ids[key] = p
+	return p
+}
+
+// templatePath replaces the org and the ids in the url suffix with placeholders, and returns it and the org. The ids are named after
+// the resource type before them (e.g. orgs/{org1}/nodes/{node1}/agreements/{agreement1}). The values in the query string are templated
+// like the strings in the bodies.
+func (r *TraceRecorder) templatePath(urlSuffix string) (string, string) {
+	parts := strings.SplitN(urlSuffix, "?", 2)
+	segments := strings.Split(strings.Trim(parts[0], "/"), "/")
+	if segments[0] != "orgs" || len(segments) < 2 {
+		return urlSuffix, ""
+	}
+	org := segments[1]
+	segments[1] = r.placeholder(org, "org")
+	for i := 2; i < len(segments); i++ {
+		kind := segments[i]
+		if kind == "search" {
+			break // the rest of a search url is not ids
+		}
+		if (kind == "business" || kind == "deployment") && i+1 < len(segments) { // e.g. business/policies is 1 resource type
+			i++
+			kind += segments[i]
+		}
+		if i+1 < len(segments) {
+			i++
+			segments[i] = r.placeholder(org+"/"+segments[i], singular(kind))
+		}
+	}
+	parts[0] = strings.Join(segments, "/")
+	if len(parts) == 2 {
+		parts[1] = r.templateQuery(parts[1], org)
+	}
+	return strings.Join(parts, "?"), org
+}
+
+// templateQuery replaces the ids we have seen in the values of the query string with their placeholders, and redacts the secrets. The
+// placeholders are not escaped, so replay can find them.
+func (r *TraceRecorder) templateQuery(query, org string) string {
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value, err := url.QueryUnescape(kv[1])
+		if err != nil {
+			continue
+		}
+		if templated := r.templateValue(value, kv[0], org).(string); templated != value {
+			params[i] = kv[0] + "=" + templated
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// singular returns the singular of a resource type in the exchange urls, e.g. policy for policies
+func singular(kind string) string {
+	if strings.HasSuffix(kind, "ies") {
+		return strings.TrimSuffix(kind, "ies") + "y"
+	}
+	return strings.TrimSuffix(kind, "s")
+}
+
+// templateActor returns who made the call, without their credentials
+func (r *TraceRecorder) templateActor(req *http.Request) string {
+	user, _, ok := req.BasicAuth()
+	if !ok {
+		if req.Header.Get("Authorization") != "" {
+			return ACTOR_USER
+		}
+		return ACTOR_ANONYMOUS
+	}
+	parts := strings.SplitN(user, "/", 2)
+	if len(parts) != 2 || parts[1] == "iamapikey" {
+		return ACTOR_USER
+	}
+	if parts[0] == "root" && parts[1] == "root" {
+		return ACTOR_ROOT
+	}
+	return r.placeholder(parts[0], "org") + "/" + r.placeholder(parts[0]+"/"+parts[1], "actor")
+}
+
+// templateBody replaces the ids we have seen in the json body with their placeholders, and redacts the secrets. Bodies that are
+// not json are left out of the trace.
+func (r *TraceRecorder) templateBody(body []byte, org string) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil
+	}
+	templated, err := json.Marshal(r.templateValue(value, "", org))
+	if err != nil {
+		return nil
+	}
+	return templated
+}
+
+func (r *TraceRecorder) templateValue(value interface{}, key, org string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = r.templateValue(child, k, org)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = r.templateValue(child, key, org)
+		}
+	case string:
+		lowerKey := strings.ToLower(key)
+		if strings.Contains(lowerKey, "token") || strings.Contains(lowerKey, "password") {
+			return REDACTED
+		}
+		if i := strings.Index(v, "/"); i > 0 { // <org>/<id>
+			if orgPlaceholder, ok := r.ids[v[:i]]; ok {
+				if idPlaceholder, ok := r.ids[v]; ok {
+					return orgPlaceholder + "/" + idPlaceholder
+				}
+			}
+		} else if p, ok := r.ids[v]; ok { // an org
+			return p
+		} else if p, ok := r.ids[org+"/"+v]; ok { // an id in the org of the url
+			return p
+		}
+	}
+	return value
+}
+
+// ReadTrace reads all of the entries of a trace file
+func ReadTrace(path string) ([]TraceEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []TraceEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // some bodies are big
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e TraceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, lineNum, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+var placeholderRegex = regexp.MustCompile(`\{([a-z]+[0-9]+)\}`)
+
+// Placeholders returns the names (without the braces, e.g. node1) of the placeholders in s, in the order they appear
+func Placeholders(s string) []string {
+	var names []string
+	for _, m := range placeholderRegex.FindAllStringSubmatch(s, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// ReplacePlaceholders replaces the placeholders in s with what mapping returns for their names
+func ReplacePlaceholders(s string, mapping func(name string) string) string {
+	return placeholderRegex.ReplaceAllStringFunc(s, func(p string) string {
+		return mapping(strings.Trim(p, "{}"))
+	})
+}
+
+// PlaceholderKind returns the kind of a placeholder name, e.g. node for node1
+func PlaceholderKind(name string) string {
+	return strings.TrimRight(name, "0123456789")
+}
@@ -0,0 +1,83 @@
+package perfutils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	recorder, err := NewTraceRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// record makes a request with basic auth (unless user is empty) and records it
+	record := func(method, urlSuffix, user, body string, status int) {
+		req, _ := http.NewRequest(method, "http://proxy/"+urlSuffix, strings.NewReader(body))
+		if user != "" {
+			req.SetBasicAuth(user, "mysecret")
+		}
+		if err := recorder.Record(req, urlSuffix, []byte(body), status, time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record(http.MethodGet, "admin/version", "", "", 200)
+	record(http.MethodPost, "orgs/myorg/patterns/p1", "myorg/admin", `{"label": "pat"}`, 201)
+	record(http.MethodPut, "orgs/myorg/nodes/n1", "myorg/admin", `{"token": "mysecret", "pattern": "myorg/p1", "arch": "amd64"}`, 201)
+	record(http.MethodPost, "orgs/myorg/nodes/n1/heartbeat", "myorg/n1", "", 201)
+	record(http.MethodPut, "orgs/myorg/nodes/n1/agreements/a1", "myorg/n1", `{"agreementService": {"orgid": "myorg", "pattern": "p1"}}`, 201)
+	record(http.MethodGet, "orgs/otherorg/business/policies/bp1?x=n1&node=myorg%2Fn1", "root/root", "", 404)
+	record(http.MethodGet, "orgs/myorg/nodes?idfilter=n1&token=mysecret&arch=amd64", "myorg/admin", "", 200)
+	if n, err := recorder.Close(); n != 7 || err != nil {
+		t.Fatalf("Close() = %d, %v, want 7 entries", n, err)
+	}
+
+	traceBytes, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(traceBytes), "mysecret") {
+		t.Errorf("the trace contains a secret:\n%s", traceBytes)
+	}
+	entries, err := ReadTrace(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		actor, path, body string
+	}{
+		{ACTOR_ANONYMOUS, "admin/version", ""},
+		{"{org1}/{actor1}", "orgs/{org1}/patterns/{pattern1}", `{"label":"pat"}`},
+		{"{org1}/{actor1}", "orgs/{org1}/nodes/{node1}", `{"arch":"amd64","pattern":"{org1}/{pattern1}","token":"{redacted}"}`},
+		{"{org1}/{node1}", "orgs/{org1}/nodes/{node1}/heartbeat", ""},
+		{"{org1}/{node1}", "orgs/{org1}/nodes/{node1}/agreements/{agreement1}", `{"agreementService":{"orgid":"{org1}","pattern":"{pattern1}"}}`},
+		{ACTOR_ROOT, "orgs/{org2}/business/policies/{businesspolicy1}?x=n1&node={org1}/{node1}", ""}, // n1 is not an id in otherorg
+		{"{org1}/{actor1}", "orgs/{org1}/nodes?idfilter={node1}&token=" + REDACTED + "&arch=amd64", ""},
+	}
+	for i, e := range entries {
+		if e.Actor != want[i].actor || e.Path != want[i].path || string(e.Body) != want[i].body {
+			t.Errorf("entry %d = %s %s %s, want %s %s %s", i, e.Actor, e.Path, e.Body, want[i].actor, want[i].path, want[i].body)
+		}
+	}
+	if entries[3].ActorId() != "node1" || entries[5].Status != 404 {
+		t.Errorf("entry 3 actor id = %s, entry 5 status = %d", entries[3].ActorId(), entries[5].Status)
+	}
+}
+
+func TestReplacePlaceholders(t *testing.T) {
+	mapping := func(name string) string {
+		if PlaceholderKind(name) == "org" {
+			return "perforg"
+		}
+		return "r-" + name
+	}
+	got := ReplacePlaceholders(`orgs/{org1}/nodes/{node12}/agreements/{agreement3} {"token":"{redacted}"}`, mapping)
+	if want := `orgs/perforg/nodes/r-node12/agreements/r-agreement3 {"token":"{redacted}"}`; got != want {
+		t.Errorf("ReplacePlaceholders() = %s, want %s", got, want)
+	}
+	if names := strings.Join(Placeholders("orgs/{org1}/nodes/{node12}"), ","); names != "org1,node12" {
+		t.Errorf("Placeholders() = %s", names)
+	}
+}
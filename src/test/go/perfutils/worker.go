@@ -0,0 +1,173 @@
+// Worker side of the coordinator/worker mode of the perf/scale drivers. This replaces using pssh to launch the drivers on each scale node:
+// each driver instance is started as a worker listening on a port, and the coordinator (see coordinator/coordinator.go) connects to all
+// of the workers, assigns each a namebase and scenario, starts them on a synchronized clock, and gathers their metrics and results.
+package perfutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMetricsIntervalS = 10
+
+	// The types of msgs a worker sends back to the coordinator
+	WORKER_MSG_METRICS = "metrics"
+	WORKER_MSG_RESULT  = "result"
+)
+
+// WorkerAssignment is sent by the coordinator to a worker to tell it what to run and when
+type WorkerAssignment struct {
+	Namebase         string            `json:"namebase"`         // passed to the driver as its <name base> arg
+	Hostname         string            `json:"hostname"`         // passed to the driver as its [short-hostname] arg, so instances can share services and patterns
+	Scenario         string            `json:"scenario"`         // the name of the scenario, only used for reporting
+	Env              map[string]string `json:"env"`              // the EX_PERF_*, EX_NODE_*, EX_AGBOT_* settings that make up the scenario
+	SentAt           time.Time         `json:"sentAt"`           // the coordinator's clock when this was sent, used to correct for clock skew between the hosts
+	StartAt          time.Time         `json:"startAt"`          // the coordinator's clock when all of the workers should start
+	RunAt            time.Time         `json:"runAt"`            // optional: the coordinator's clock when all of the workers should start their timed phase
+	MetricsIntervalS int               `json:"metricsIntervalS"` // how often the worker should send its live metrics
+}
+
+// WorkerMetrics is a snapshot of a worker's progress
+type WorkerMetrics struct {
+	Phase    string  `json:"phase"`
+	ElapsedS float64 `json:"elapsedS"`
+	Ops      int     `json:"ops"`
+	Errors   int     `json:"errors"`
+}
+
+// WorkerMsg is what a worker streams back to the coordinator. The last msg a worker sends is always of type WORKER_MSG_RESULT.
+type WorkerMsg struct {
+	Type     string        `json:"type"`
+	Driver   string        `json:"driver"` // e.g. node or agbot
+	Namebase string        `json:"namebase"`
+	Metrics  WorkerMetrics `json:"metrics"`
+	Summary  string        `json:"summary,omitempty"`  // the contents of the driver's report file, only in the result msg
+	ExitCode int           `json:"exitCode,omitempty"` // non-zero if the driver exited with a fatal error, only in the result msg
+}
+
+var phaseLock sync.Mutex
+var phase string
+
+// SetPhase records which part of the test the driver is in (e.g. setup, run, cleanup), so it can be reported in the live metrics
+func SetPhase(p string) {
+	phaseLock.Lock()
+	phase = p
+	phaseLock.Unlock()
+}
+
+// GetPhase returns the phase most recently set by SetPhase()
+func GetPhase() string {
+	phaseLock.Lock()
+	defer phaseLock.Unlock()
+	return phase
+}
+
+// workerConn holds the connection back to the coordinator and serializes the msgs written to it
+type workerConn struct {
+	lock     sync.Mutex
+	encoder  *json.Encoder
+	driver   string
+	namebase string
+	start    time.Time
+	done     bool
+}
+
+func (w *workerConn) send(msgType string, summary string, exitCode int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.done {
+		return // the result was already sent, the coordinator is not listening anymore
+	}
+	msg := WorkerMsg{Type: msgType, Driver: w.driver, Namebase: w.namebase, Summary: summary, ExitCode: exitCode,
+		Metrics: WorkerMetrics{Phase: GetPhase(), ElapsedS: time.Since(w.start).Seconds(), Ops: GetTotalOps(), Errors: GetErrorCount()}}
+	if err := w.encoder.Encode(msg); err != nil {
+		// can not use Error() here, because that could be called from a Fatal() exit hook
+		fmt.Printf("could not send %s msg to the coordinator: %v\n", msgType, err)
+	}
+	if msgType == WORKER_MSG_RESULT {
+		w.done = true
+	}
+}
+
+func (w *workerConn) sendResult(exitCode int) {
+	var summary []byte
+	if EX_PERF_REPORT_FILE != "" {
+		summary, _ = ioutil.ReadFile(EX_PERF_REPORT_FILE) // if the driver failed before creating it, just send back an empty summary
+	}
+	w.send(WORKER_MSG_RESULT, string(summary), exitCode)
+}
+
+// RunWorker listens on listenAddr (e.g. ":8510") for the coordinator to connect, waits for its assignment, and then runs the driver's
+// test function (with the assigned namebase and hostname) at the assigned start time. It streams metrics back to the coordinator while the
+// test is running and sends the summary when it is done (or when the driver calls Fatal()). The worker handles just 1 assignment.
+func RunWorker(listenAddr string, runTest func(namebase, hostname string)) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		Fatal(CLI_INPUT_ERROR, "could not listen on %s: %v", listenAddr, err)
+	}
+	fmt.Printf("Worker %s waiting for the coordinator on %s...\n", GetDriverName(), listener.Addr().String())
+	conn, err := listener.Accept()
+	listener.Close() // we only take 1 assignment
+	if err != nil {
+		Fatal(HTTP_ERROR, "could not accept connection from the coordinator: %v", err)
+	}
+	defer conn.Close()
+
+	var assignment WorkerAssignment
+	if err := json.NewDecoder(conn).Decode(&assignment); err != nil {
+		Fatal(JSON_PARSING_ERROR, "could not read assignment from the coordinator: %v", err)
+	}
+	// The scenario is expressed as the same env vars the drivers already use, so just set them before running the test
+	for name, value := range assignment.Env {
+		os.Setenv(name, value)
+	}
+
+	// Convert the start time to our clock. Ignoring network latency, the coordinator's clock was at SentAt when we received the assignment
+	skew := time.Since(assignment.SentAt)
+	startAt := assignment.StartAt.Add(skew)
+	if !assignment.RunAt.IsZero() {
+		os.Setenv("EX_PERF_START_AT", assignment.RunAt.Add(skew).Format(time.RFC3339Nano)) // used by WaitForStartBarrier()
+	}
+	fmt.Printf("Worker assigned namebase %s, scenario '%s', starting at %s\n", assignment.Namebase, assignment.Scenario, startAt.Format("2006.01.02 15:04:05"))
+	SetPhase("waiting")
+	time.Sleep(time.Until(startAt))
+
+	w := &workerConn{encoder: json.NewEncoder(conn), driver: GetDriverName(), namebase: assignment.Namebase, start: time.Now()}
+	AddExitHook(w.sendResult)
+	// Check the scenario's settings now (after the exit hook is added, so the coordinator gets the error in our result)
+	if err := ValidateSettings(GetDriverName()); err != nil {
+		Fatal(CLI_INPUT_ERROR, "%v", err)
+	}
+	loadRetrySettings()
+
+	// Stream our metrics until the test is done
+	intervalS := assignment.MetricsIntervalS
+	if intervalS <= 0 {
+		intervalS = DefaultMetricsIntervalS
+	}
+	ticker := time.NewTicker(Seconds2Duration(intervalS))
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				w.send(WORKER_MSG_METRICS, "", 0)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	runTest(assignment.Namebase, assignment.Hostname)
+
+	ticker.Stop()
+	close(stop)
+	SetPhase("done")
+	w.sendResult(0)
+}
@@ -0,0 +1,114 @@
+// The record command of exchperf: a proxy in front of the exchange that records the api calls of real agents and agbots in a trace file,
+// so the replay driver can replay them at scale
+package record
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+func Usage(prog string, flags *flag.FlagSet) {
+	fmt.Printf(`Usage: %s [flags]
+
+Listens on EX_PERF_RECORD_LISTEN and forwards every api call to the exchange at HZN_EXCHANGE_URL, recording it in the trace file
+EX_PERF_TRACE_FILE (which is replaced). Point the agents and agbots at the proxy (e.g. HZN_EXCHANGE_URL=http://<this host>:8090/),
+and stop it with ctrl-c when you have recorded enough. The trace has the method, url, body, http code, and timing of each call, with
+the ids replaced by placeholders and without the credentials. Then replay it with "exchperf replay".
+`, prog)
+	perfutils.PrintSettingsUsage(perfutils.DRIVER_RECORD, flags)
+}
+
+// statusWriter remembers the http code of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets the proxy flush the response
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Main runs the recording proxy with the command line args. prog is how it was invoked (e.g. "exchperf record"), for the usage.
+func Main(prog string, args []string) {
+	flags := flag.NewFlagSet(prog, flag.ExitOnError)
+	flags.Usage = func() { Usage(prog, flags) }
+	args = perfutils.ParseConfig(perfutils.DRIVER_RECORD, flags, args)
+	if len(args) != 0 {
+		Usage(prog, flags)
+		os.Exit(1)
+	}
+
+	exchangeUrl, err := url.Parse(perfutils.GetExchangeUrl())
+	if err != nil || exchangeUrl.Host == "" {
+		perfutils.Fatal(perfutils.CLI_INPUT_ERROR, "invalid HZN_EXCHANGE_URL '%s'", perfutils.GetExchangeUrl())
+	}
+	traceFile := perfutils.ConfigString("EX_PERF_TRACE_FILE")
+	listenAddr := perfutils.ConfigString("EX_PERF_RECORD_LISTEN")
+	recorder, err := perfutils.NewTraceRecorder(traceFile)
+	if err != nil {
+		perfutils.Fatal(perfutils.FILE_IO_ERROR, "could not create trace file %s: %v", traceFile, err)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = exchangeUrl.Scheme
+			req.URL.Host = exchangeUrl.Host
+			req.URL.Path = strings.TrimSuffix(exchangeUrl.Path, "/") + "/" + strings.TrimPrefix(req.URL.Path, "/")
+			req.URL.RawPath = ""
+			req.Host = exchangeUrl.Host
+		},
+		Transport: perfutils.NewHTTPTransport(), // so the TLS settings apply to the connections to the exchange
+	}
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		urlSuffix := strings.TrimPrefix(req.URL.RequestURI(), "/")
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		proxy.ServeHTTP(sw, req)
+		if err := recorder.Record(req, urlSuffix, body, sw.status, time.Since(start)); err != nil {
+			perfutils.Error("could not record %s %s: %v", req.Method, urlSuffix, err)
+		}
+		perfutils.Verbose("%s %s: %d", req.Method, urlSuffix, sw.status)
+	}
+
+	// Close the trace when we are stopped
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		numCalls, err := recorder.Close()
+		if err != nil {
+			perfutils.Fatal(perfutils.FILE_IO_ERROR, "could not close trace file %s: %v", traceFile, err)
+		}
+		fmt.Printf("\nRecorded %d api calls in %s\n", numCalls, traceFile)
+		os.Exit(0)
+	}()
+
+	fmt.Printf("Recording the api calls to %s in %s. Listening on %s, stop with ctrl-c\n", exchangeUrl, traceFile, listenAddr)
+	err = http.ListenAndServe(listenAddr, http.HandlerFunc(handler))
+	perfutils.Fatal(perfutils.HTTP_ERROR, "the recording proxy stopped: %v", err)
+}
@@ -0,0 +1,327 @@
+// The replay driver of exchperf: replays a trace of real agent traffic (recorded with "exchperf record") against the exchange, scaled up
+// to many synthetic nodes and agbots, so the load reflects what the agents really do instead of what the node and agbot drivers guess.
+package replay
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
+)
+
+func Usage(prog string, flags *flag.FlagSet) {
+	fmt.Printf(`Usage: %s [flags] <name base>
+       %s [flags] --worker <listen-address>    (wait for the coordinator to assign the name base and settings)
+
+Replays the api calls in the trace file EX_PERF_TRACE_FILE as EX_PERF_REPLAY_ACTORS synthetic nodes and agbots. Each one makes the calls
+of 1 of the nodes or agbots of the trace (in turn), with the same timing (divided by EX_PERF_REPLAY_SPEEDUP). The ids of the trace are
+mapped to ids of our own: the ones the nodes and agbots of the trace used get a copy for each synthetic actor (e.g. <name base>-replay-2-node1),
+the other ones are shared (e.g. <name base>-replay-pattern1), and all of the orgs are mapped to EX_PERF_ORG. The calls the other actors
+of the trace made (e.g. a user creating services and patterns) are replayed once. A call is an error if its http code is not the one
+that was recorded. The ids the exchange derives from a body (services) or generates (msgs) can not be mapped, so the calls that use them
+may be errors. With EX_PERF_REPLAY_SPEEDUP=0 the calls of different actors are not kept in order either (e.g. a node may get a pattern
+before it is created). The synthetic nodes and agbots are deleted at the end. Run "exchperf cleanup <name base>" to delete the rest of
+what the calls created.
+`, prog, prog)
+	perfutils.PrintSettingsUsage(perfutils.DRIVER_REPLAY, flags)
+}
+
+// Main runs the replay driver with the command line args. prog is how it was invoked (e.g. "exchperf replay"), for the usage.
+func Main(prog string, args []string) {
+	flags := flag.NewFlagSet(prog, flag.ExitOnError)
+	flags.Usage = func() { Usage(prog, flags) }
+	workerAddress := flags.String("worker", "", "run in worker mode, listening on this address")
+	args = perfutils.ParseConfig(perfutils.DRIVER_REPLAY, flags, args)
+
+	// In worker mode the coordinator gives us our name base
+	if *workerAddress != "" {
+		perfutils.RunWorker(*workerAddress, runReplay)
+		return
+	}
+	if len(args) != 1 {
+		Usage(prog, flags)
+		os.Exit(1)
+	}
+	runReplay(args[0], "")
+}
+
+// The token of all of the synthetic nodes and agbots. It replaces the redacted tokens of the trace.
+const actorToken = "abc123"
+
+// sequence is the calls of 1 node or agbot of the trace (including the calls other actors made to its resources, e.g. registering it),
+// or the calls of all of the other actors
+type sequence struct {
+	agent   string // the placeholder name of the node or agbot, or "" for the other actors
+	kind    string // nodes or agbots
+	created bool   // the trace creates the node or agbot, so we should not create it first
+	entries []perfutils.TraceEntry
+}
+
+// trace is a trace file, divided into sequences
+type trace struct {
+	agents   []*sequence       // in the order they first appear in the trace
+	others   *sequence         // the calls of the other actors
+	kinds    map[string]string // nodes or agbots, for the placeholder name of each node and agbot
+	perAgent map[string]bool   // the placeholder names that were 1st used in the sequence of a node or agbot, so each copy gets its own id
+	startMs  int64             // the offset of the 1st entry
+	lengthMs int64             // the time from the 1st to the last entry
+}
+
+// The node or agbot whose resource a url is (e.g. orgs/{org1}/nodes/{node1}/heartbeat)
+var agentPathRegex = regexp.MustCompile(`^orgs/\{org[0-9]+\}/(nodes|agbots)/\{([a-z]+[0-9]+)\}`)
+
+// readTrace reads the trace file and divides it into sequences
+func readTrace(path string) *trace {
+	entries, err := perfutils.ReadTrace(path)
+	if err != nil {
+		perfutils.Fatal(perfutils.FILE_IO_ERROR, "could not read trace %s: %v", path, err)
+	}
+	if len(entries) == 0 {
+		perfutils.Fatal(perfutils.CLI_INPUT_ERROR, "trace %s is empty", path)
+	}
+
+	// The nodes and agbots are the ids used in their urls
+	kinds := map[string]string{}
+	for _, e := range entries {
+		if m := agentPathRegex.FindStringSubmatch(e.Path); m != nil {
+			kinds[m[2]] = m[1]
+		}
+	}
+
+	t := &trace{others: &sequence{}, kinds: kinds, perAgent: map[string]bool{}, startMs: entries[0].OffsetMs}
+	sequences := map[string]*sequence{}
+	for _, e := range entries {
+		agent := e.ActorId()
+		if kinds[agent] == "" {
+			agent = ""
+			if m := agentPathRegex.FindStringSubmatch(e.Path); m != nil {
+				agent = m[2]
+			}
+		}
+		seq := t.others
+		if agent != "" {
+			if seq = sequences[agent]; seq == nil {
+				seq = &sequence{agent: agent, kind: kinds[agent]}
+				sequences[agent] = seq
+				t.agents = append(t.agents, seq)
+			}
+			if e.Method == http.MethodPut && agentPathRegex.FindString(e.Path) == e.Path {
+				seq.created = true
+			}
+		}
+		seq.entries = append(seq.entries, e)
+
+		for _, name := range perfutils.Placeholders(e.Actor + " " + e.Path + " " + string(e.Body)) {
+			if _, seen := t.perAgent[name]; !seen {
+				t.perAgent[name] = agent != ""
+			}
+		}
+		t.lengthMs = e.OffsetMs - t.startMs
+	}
+	return t
+}
+
+// replayer holds what the synthetic actors share
+type replayer struct {
+	activeTime int64 // the total time spent in the api calls of all of the actors, in ns (1st, so it is aligned for atomic)
+	namebase   string
+	org        string
+	userId     string // the id of the exchange user, which all of the user ids of the trace are mapped to
+	userauth   perfutils.Credentials
+	rootauth   perfutils.Credentials
+	trace      *trace
+	speedup    int
+}
+
+// mapping returns the function that maps the placeholders of the trace to the ids of copy copyNum
+func (r *replayer) mapping(copyNum int) func(string) string {
+	return func(name string) string {
+		switch {
+		case perfutils.PlaceholderKind(name) == "org":
+			return r.org
+		case perfutils.PlaceholderKind(name) == "user":
+			return r.userId
+		case r.trace.perAgent[name]:
+			return r.namebase + "-" + strconv.Itoa(copyNum) + "-" + name
+		default:
+			return r.namebase + "-" + name
+		}
+	}
+}
+
+// credentials returns the credentials to make the call with
+func (r *replayer) credentials(e perfutils.TraceEntry, mapping func(string) string) perfutils.Credentials {
+	switch e.Actor {
+	case perfutils.ACTOR_ANONYMOUS:
+		return nil
+	case perfutils.ACTOR_ROOT:
+		return r.rootauth
+	case perfutils.ACTOR_USER:
+		return r.userauth
+	}
+	switch id := e.ActorId(); r.trace.kinds[id] {
+	case "nodes":
+		return perfutils.NodeToken{Org: r.org, NodeId: mapping(id), Token: actorToken}
+	case "agbots":
+		return perfutils.AgbotToken{Org: r.org, AgbotId: mapping(id), Token: actorToken}
+	default:
+		return r.userauth // an exchange user with a password
+	}
+}
+
+// call makes 1 api call of the trace. The http code that was recorded is the only good one.
+func (r *replayer) call(e perfutils.TraceEntry, mapping func(string) string) {
+	path := perfutils.ReplacePlaceholders(e.Path, mapping)
+	auth := r.credentials(e, mapping)
+	goodHttpCodes := []int{e.Status}
+	start := time.Now()
+	switch e.Method {
+	case http.MethodGet:
+		perfutils.ExchangeGet(path, auth, goodHttpCodes, nil)
+	case http.MethodDelete:
+		perfutils.ExchangeDelete(path, auth, goodHttpCodes)
+	default:
+		var body interface{}
+		if len(e.Body) > 0 {
+			body = strings.Replace(perfutils.ReplacePlaceholders(string(e.Body), mapping), `"`+perfutils.REDACTED+`"`, `"`+actorToken+`"`, -1)
+		}
+		perfutils.ExchangeP(e.Method, path, auth, goodHttpCodes, body, nil, true)
+	}
+	atomic.AddInt64(&r.activeTime, int64(time.Since(start)))
+}
+
+// replay makes the calls of the sequence at the times they were made in the trace (relative to start, and sped up), loops times
+func (r *replayer) replay(seq *sequence, copyNum int, start time.Time, loops int) {
+	mapping := r.mapping(copyNum)
+	for l := 0; l < loops; l++ {
+		loopStart := start.Add(time.Duration(int64(l)*r.trace.lengthMs) * time.Millisecond)
+		for _, e := range seq.entries {
+			if r.speedup > 0 {
+				due := loopStart.Add(time.Duration(e.OffsetMs-r.trace.startMs) * time.Millisecond / time.Duration(r.speedup))
+				if wait := time.Until(due); wait > 0 {
+					time.Sleep(wait)
+				}
+			}
+			r.call(e, mapping)
+		}
+	}
+}
+
+// runReplay runs the whole replay: setup, the replay of the trace, and clean up
+func runReplay(namebaseArg, hostname string) {
+	scriptName := perfutils.GetDriverName()
+	namebase := namebaseArg + "-replay"
+	traceFile := perfutils.ConfigString("EX_PERF_TRACE_FILE")
+
+	HZN_EXCHANGE_URL := perfutils.GetRequiredEnvVar("HZN_EXCHANGE_URL")
+
+	reportDir := perfutils.ConfigString("EX_PERF_REPORT_DIR") + "/" + scriptName
+	perfutils.EX_PERF_REPORT_FILE = perfutils.GetEnvVarWithDefault("EX_PERF_REPORT_FILE", reportDir+"/"+namebase+".summary")
+
+	r := &replayer{namebase: namebase, org: perfutils.ConfigString("EX_PERF_ORG"), rootauth: perfutils.GetRootCredentials(), trace: readTrace(traceFile),
+		speedup: perfutils.ConfigInt("EX_PERF_REPLAY_SPEEDUP")}
+	r.userauth = perfutils.GetUserCredentials(r.org)
+	numActors := perfutils.ConfigInt("EX_PERF_REPLAY_ACTORS")
+	if numActors == 0 {
+		numActors = len(r.trace.agents)
+	}
+	if numActors > 0 && len(r.trace.agents) == 0 {
+		perfutils.Fatal(perfutils.CLI_INPUT_ERROR, "trace %s has no node or agbot calls to replay", traceFile)
+	}
+	loops := perfutils.ConfigInt("EX_PERF_REPLAY_LOOPS")
+	ramp := perfutils.Seconds2Duration(perfutils.ConfigInt("EX_PERF_REPLAY_RAMP_S"))
+
+	// The synthetic actors take turns copying the nodes and agbots of the trace: actor i is copy i/len(agents)+1 of agent i%len(agents)
+	agentOf := func(i int) *sequence { return r.trace.agents[i%len(r.trace.agents)] }
+	copyOf := func(i int) int { return i/len(r.trace.agents) + 1 }
+
+	// =========== Initialization =================================================
+
+	perfutils.SetPhase("setup")
+	fmt.Printf("Initializing replay %s of trace %s (%d nodes and agbots, %d other calls, %.1f s long), as %d synthetic nodes and agbots:\n",
+		namebase, traceFile, len(r.trace.agents), len(r.trace.others.entries), float64(r.trace.lengthMs)/1000, numActors)
+	fmt.Println("Using exchange " + HZN_EXCHANGE_URL)
+
+	perfutils.MakeDir(reportDir)
+	perfutils.RemoveFile(perfutils.EX_PERF_REPORT_FILE)
+	perfutils.WriteEffectiveConfig(reportDir + "/" + namebase + ".config.json")
+
+	manifest := perfutils.LoadSetupManifest(namebase, r.org)
+	r.userId = manifest.EnsurePerfOrgAndUser(r.org, r.rootauth, r.userauth)
+	if r.userId == "" {
+		r.userId = perfutils.ConfigString("EXCHANGE_IAM_EMAIL") // the user of the bearer token, only needed if the trace has calls about users
+	}
+
+	// Create the nodes and agbots the trace does not create itself (because they were registered before the recording started)
+	for i := 0; i < numActors; i++ {
+		seq := agentOf(i)
+		if seq.created {
+			continue
+		}
+		id := r.mapping(copyOf(i))(seq.agent)
+		body := `{"token": "` + actorToken + `", "name": "` + id + `", "publicKey": ""}`
+		if seq.kind == "nodes" {
+			body = `{"token": "` + actorToken + `", "name": "` + id + `", "pattern": "", "arch": "amd64", "publicKey": ""}`
+		}
+		manifest.Ensure(perfutils.ExchangeResource{Path: "orgs/" + r.org + "/" + seq.kind + "/" + id, CreateMethod: http.MethodPut, UpdateMethod: http.MethodPut,
+			Auth: r.userauth, Ignore: []string{"token"}, Body: body, DoContinue: true})
+	}
+	manifest.MarkSetupDone()
+
+	// =========== Replay =================================================
+
+	perfutils.WaitForStartBarrier(namebase)
+
+	perfutils.SetPhase("run")
+	perfutils.ResetTotalOps()
+	perfutils.ResetConnStats()
+	perfutils.ResetRouteStats()
+	t1 := time.Now()
+
+	var wg sync.WaitGroup
+	if len(r.trace.others.entries) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.replay(r.trace.others, 1, t1, 1)
+		}()
+	}
+	for i := 0; i < numActors; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.replay(agentOf(i), copyOf(i), t1.Add(ramp*time.Duration(i)/time.Duration(numActors)), loops)
+		}(i)
+	}
+	wg.Wait()
+	t2 := time.Now()
+	numOps := perfutils.GetTotalOps()
+
+	// =========== Clean up ===========================================
+
+	perfutils.SetPhase("cleanup")
+	fmt.Println("\nDeleting the synthetic nodes and agbots:")
+	for i := 0; i < numActors; i++ {
+		seq := agentOf(i)
+		perfutils.ExchangeDelete("orgs/"+r.org+"/"+seq.kind+"/"+r.mapping(copyOf(i))(seq.agent), r.userauth, []int{404})
+	}
+	manifest.Remove()
+
+	tDelta := t2.Sub(t1)
+	activeTimeSecs := time.Duration(atomic.LoadInt64(&r.activeTime)).Seconds()
+	opsAvg := activeTimeSecs / float64(numOps)
+	sumMsg := fmt.Sprintf("Replayed trace %s as %d synthetic nodes and agbots, %d loops, speedup %d\nStart time: %s, End time: %s, wall clock duration=%f s\nOverall: active time: %f s, num ops=%d, avg=%f s/op, errors=%d",
+		traceFile, numActors, loops, r.speedup, t1.Format("2006.01.02 15:04:05"), t2.Format("2006.01.02 15:04:05"), tDelta.Seconds(), activeTimeSecs, numOps, opsAvg, perfutils.GetErrorCount())
+	sumMsg += "\n" + perfutils.GetConnStats().String() + "\n" + perfutils.RouteReport()
+
+	perfutils.Append2File(perfutils.EX_PERF_REPORT_FILE, sumMsg+"\n")
+	fmt.Println("\n" + sumMsg)
+}
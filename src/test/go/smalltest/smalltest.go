@@ -1,50 +1,285 @@
-// Very quick performance test of the exchange
-package main
+// The small command of exchperf: quick micro-benchmarks of individual exchange apis. Each benchmark calls 1 api over and over (optionally
+// from several goroutines at once) and reports the throughput and latency percentiles of that api.
+package smalltest
 
 import (
+	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/open-horizon/exchange-api/src/test/go/perfutils"
 )
 
-func Usage(exitCode int) {
-	fmt.Printf("Usage: %s <num-times>\n", os.Args[0])
-	os.Exit(exitCode)
+func Usage(prog string, flags *flag.FlagSet) {
+	fmt.Printf(`Usage: %s [flags] <num-times>|<duration>s [<benchmark> ...]
+
+Runs each benchmark either <num-times> times, or for <duration> seconds (e.g. 30s). If no benchmarks are specified, all of them are run.
+
+Benchmarks:
+%s`, prog, benchmarkList())
+	perfutils.PrintSettingsUsage(perfutils.DRIVER_SMALL, flags)
+}
+
+// benchmark is 1 api to measure. run calls the api once and returns true if it succeeded.
+type benchmark struct {
+	name        string
+	description string
+	setup       func(c *config) string // returns the reason it can not be run with this config, or ""
+	run         func(c *config) bool
+}
+
+// config holds the settings and credentials the benchmarks need
+type config struct {
+	org        string
+	nodeid     string
+	nodeauth   perfutils.Credentials
+	agbotauth  perfutils.Credentials
+	pattern    string
+	serviceUrl string
 }
 
-func main() {
-	if len(os.Args) <= 1 {
-		Usage(1)
+// The response from the exchange for GET orgs/{orgid}/patterns/{pattern}. We only need the service urls.
+type exchangePattern struct {
+	Patterns map[string]struct {
+		Services []struct {
+			ServiceUrl   string `json:"serviceUrl"`
+			ServiceOrgid string `json:"serviceOrgid"`
+		} `json:"services"`
+	} `json:"patterns"`
+}
+
+var benchmarks = []benchmark{
+	{name: "node-get", description: "GET the node (as the node)",
+		run: func(c *config) bool {
+			return perfutils.ExchangeGet("orgs/"+c.org+"/nodes/"+c.nodeid, c.nodeauth, nil, nil) == 200
+		}},
+	{name: "heartbeat", description: "POST a node heartbeat",
+		run: func(c *config) bool {
+			return perfutils.ExchangeP(http.MethodPost, "orgs/"+c.org+"/nodes/"+c.nodeid+"/heartbeat", c.nodeauth, nil, nil, nil, true) == 201
+		}},
+	{name: "msgs-get", description: "GET the node's msgs (as the node)",
+		run: func(c *config) bool {
+			httpCode := perfutils.ExchangeGet("orgs/"+c.org+"/nodes/"+c.nodeid+"/msgs", c.nodeauth, []int{404}, nil)
+			return httpCode == 200 || httpCode == 404 // 404 just means the node has no msgs
+		}},
+	{name: "pattern-search", description: "POST a search for the nodes using the pattern (as the agbot)",
+		setup: func(c *config) string {
+			if c.agbotauth == nil || c.pattern == "" {
+				return "EXCHANGE_AGBOTAUTH and EX_SMALLTEST_PATTERN must be set"
+			}
+			if c.serviceUrl == "" {
+				var resp exchangePattern
+				perfutils.ExchangeGet("orgs/"+c.org+"/patterns/"+c.pattern, c.agbotauth, nil, &resp)
+				for _, pat := range resp.Patterns {
+					if len(pat.Services) > 0 {
+						c.serviceUrl = pat.Services[0].ServiceOrgid + "/" + pat.Services[0].ServiceUrl
+					}
+				}
+				if c.serviceUrl == "" {
+					return "could not get the service url from pattern " + c.pattern + ", set EX_SMALLTEST_SERVICE_URL"
+				}
+			}
+			return ""
+		},
+		run: func(c *config) bool {
+			httpCode := perfutils.ExchangeP(http.MethodPost, "orgs/"+c.org+"/patterns/"+c.pattern+"/search", c.agbotauth, []int{404}, `{ "serviceUrl": "`+c.serviceUrl+`", "secondsStale": 0, "startIndex": 0, "numEntries": 0 }`, nil, true)
+			return httpCode == 201 || httpCode == 404 // 404 just means no nodes were found
+		}},
+	{name: "service-list", description: "GET the services in the org (as the node)",
+		run: func(c *config) bool {
+			httpCode := perfutils.ExchangeGet("orgs/"+c.org+"/services", c.nodeauth, []int{404}, nil)
+			return httpCode == 200 || httpCode == 404
+		}},
+	{name: "admin-version", description: "GET admin/version (anonymously)",
+		run: func(c *config) bool {
+			var resp []byte // the version is not json
+			return perfutils.ExchangeGet("admin/version", nil, nil, &resp) == 200
+		}},
+}
+
+func benchmarkList() string {
+	var list string
+	for _, b := range benchmarks {
+		list += fmt.Sprintf("  %-15s %s\n", b.name, b.description)
 	}
+	return list
+}
 
-	numTimes := perfutils.Str2int(os.Args[1])
+// result holds the measurements of 1 benchmark
+type result struct {
+	name      string
+	ops       int
+	failures  int
+	elapsed   time.Duration
+	latencies perfutils.LatencyStats
+}
 
-	org := "IBM"
-	nodeid := "n1"
-	//userauth := org + "/" + perfutils.GetRequiredEnvVar("HZN_EXCHANGE_USER_AUTH")
-	nodeauth := org + "/" + perfutils.GetRequiredEnvVar("HZN_EXCHANGE_NODE_AUTH")
-	//agbotauth := org + "/" + perfutils.GetRequiredEnvVar("EXCHANGE_AGBOTAUTH")
+func (r result) String() string {
+	return fmt.Sprintf("%-15s ops=%d failures=%d time=%.3f s throughput=%.1f ops/s latency: %s", r.name, r.ops, r.failures, r.elapsed.Seconds(), float64(r.ops)/r.elapsed.Seconds(), r.latencies)
+}
 
-	httpClient := perfutils.GetHTTPClient()
+// Main runs the benchmarks with the command line args. prog is how it was invoked (e.g. "exchperf small"), for the usage.
+func Main(prog string, args []string) {
+	flags := flag.NewFlagSet(prog, flag.ExitOnError)
+	flags.Usage = func() { Usage(prog, flags) }
+	args = perfutils.ParseConfig(perfutils.DRIVER_SMALL, flags, args)
+	if len(args) == 0 {
+		Usage(prog, flags)
+		os.Exit(1)
+	}
+
+	// The 1st arg is either a number of times or a duration
+	numTimes, duration, err := parseRunLength(args[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		Usage(prog, flags)
+		os.Exit(1)
+	}
 
-	fmt.Println("Starting smalltest...")
-	t1 := time.Now()
+	// Determine which benchmarks to run
+	var toRun []benchmark
+	for _, name := range args[1:] {
+		found := false
+		for _, b := range benchmarks {
+			if b.name == name {
+				toRun = append(toRun, b)
+				found = true
+			}
+		}
+		if !found {
+			fmt.Printf("Error: unknown benchmark '%s'\n", name)
+			Usage(prog, flags)
+			os.Exit(1)
+		}
+	}
+	if len(toRun) == 0 {
+		toRun = benchmarks
+	}
 
-	for i := 1; i <= numTimes; i++ {
-		var resp []byte
-		httpCode := perfutils.ExchangeGet(httpClient, "orgs/"+org+"/nodes/"+nodeid, perfutils.AddOrg(nodeauth), []int{200}, &resp)
-		if perfutils.IsVerbose() {
-			fmt.Printf("httpCode=%d\n", httpCode)
+	c := &config{org: perfutils.ConfigString("EX_SMALLTEST_ORG")}
+	nodeid, nodetoken := perfutils.ParseIdToken(perfutils.ConfigString("HZN_EXCHANGE_NODE_AUTH"))
+	c.nodeid = nodeid
+	c.nodeauth = perfutils.NodeToken{Org: c.org, NodeId: nodeid, Token: nodetoken}
+	if agbotauth := perfutils.ConfigString("EXCHANGE_AGBOTAUTH"); agbotauth != "" {
+		agbotid, agbottoken := perfutils.ParseIdToken(agbotauth)
+		c.agbotauth = perfutils.AgbotToken{Org: c.org, AgbotId: agbotid, Token: agbottoken}
+	}
+	c.pattern = perfutils.ConfigString("EX_SMALLTEST_PATTERN")
+	c.serviceUrl = perfutils.ConfigString("EX_SMALLTEST_SERVICE_URL")
+	concurrency := perfutils.ConfigInt("EX_SMALLTEST_CONCURRENCY")
+	warmup := perfutils.ConfigInt("EX_SMALLTEST_WARMUP")
+
+	// this file holds the results, and any errors that may have occurred along the way
+	reportDir := perfutils.ConfigString("EX_PERF_REPORT_DIR")
+	perfutils.MakeDir(reportDir)
+	perfutils.EX_PERF_REPORT_FILE = reportDir + "/" + perfutils.GetDriverName() + ".summary"
+	perfutils.RemoveFile(perfutils.EX_PERF_REPORT_FILE)
+
+	if duration > 0 {
+		fmt.Printf("Running %d benchmarks for %.0f s each, with concurrency %d, against %s\n", len(toRun), duration.Seconds(), concurrency, perfutils.GetExchangeUrl())
+	} else {
+		fmt.Printf("Running %d benchmarks %d times each, with concurrency %d, against %s\n", len(toRun), numTimes, concurrency, perfutils.GetExchangeUrl())
+	}
+	perfutils.GetHTTPClient() // create the global client before the goroutines use it
+
+	var results []string
+	for _, b := range toRun {
+		if b.setup != nil {
+			if reason := b.setup(c); reason != "" {
+				msg := fmt.Sprintf("%-15s skipped: %s", b.name, reason)
+				fmt.Println(msg)
+				results = append(results, msg)
+				continue
+			}
+		}
+		runBenchmark(b, c, warmup, 1) // warm up the connections and the exchange's caches, without measuring
+		perfutils.ResetRouteStats()
+		var r result
+		if duration > 0 {
+			r = runBenchmarkFor(b, c, duration, concurrency)
 		} else {
-			fmt.Printf(".")
+			r = runBenchmark(b, c, numTimes, concurrency)
+		}
+		fmt.Println(r)
+		results = append(results, r.String())
+		// The breakdown of the latency into the phases of the requests, to tell network slowness apart from exchange slowness
+		for _, rs := range perfutils.GetRouteStats() {
+			fmt.Println("  " + rs.String())
+			results = append(results, "  "+rs.String())
+		}
+	}
+
+	connStats := perfutils.GetConnStats().String()
+	fmt.Println(connStats)
+	results = append(results, connStats)
+	perfutils.Append2File(perfutils.EX_PERF_REPORT_FILE, strings.Join(results, "\n")+"\n")
+	if perfutils.GetErrorCount() > 0 {
+		fmt.Printf("%d errors occurred, see %s\n", perfutils.GetErrorCount(), perfutils.EX_PERF_REPORT_FILE)
+		os.Exit(perfutils.HTTP_ERROR)
+	}
+}
+
+// parseRunLength parses the <num-times>|<duration>s arg, returning either the number of times or the duration
+func parseRunLength(arg string) (int, time.Duration, error) {
+	if strings.HasSuffix(arg, "s") {
+		if secs, err := strconv.Atoi(strings.TrimSuffix(arg, "s")); err == nil && secs > 0 {
+			return 0, perfutils.Seconds2Duration(secs), nil
 		}
+	} else if numTimes, err := strconv.Atoi(arg); err == nil && numTimes > 0 {
+		return numTimes, 0, nil
+	}
+	return 0, 0, fmt.Errorf("'%s' is not a positive number of times or a duration in seconds (e.g. 30s)", arg)
+}
+
+// runBenchmark runs the benchmark numTimes in total, spread across concurrency goroutines
+func runBenchmark(b benchmark, c *config, numTimes, concurrency int) result {
+	var remaining int64 = int64(numTimes)
+	return measure(b, c, concurrency, func() bool { return atomic.AddInt64(&remaining, -1) >= 0 })
+}
+
+// runBenchmarkFor runs the benchmark in concurrency goroutines until duration has passed
+func runBenchmarkFor(b benchmark, c *config, duration time.Duration, concurrency int) result {
+	end := time.Now().Add(duration)
+	return measure(b, c, concurrency, func() bool { return time.Now().Before(end) })
+}
+
+// measure runs the benchmark in concurrency goroutines, each of which keeps going while more() returns true, and gathers the results
+func measure(b benchmark, c *config, concurrency int, more func() bool) result {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	r := result{name: b.name}
+	var latencies []time.Duration
+
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var myLatencies []time.Duration
+			myFailures := 0
+			for more() {
+				t := time.Now()
+				if !b.run(c) {
+					myFailures++
+				}
+				myLatencies = append(myLatencies, time.Since(t))
+			}
+			lock.Lock()
+			latencies = append(latencies, myLatencies...)
+			r.failures += myFailures
+			lock.Unlock()
+		}()
 	}
+	wg.Wait()
+	r.elapsed = time.Since(start)
 
-	t2 := time.Now()
-	tDelta := t2.Sub(t1)
-	numOps := numTimes
-	opsAvg := tDelta.Seconds() / float64(numOps)
-	fmt.Printf("\nTotal time: %f s, num ops=%d, avg=%f s/op\n", tDelta.Seconds(), numOps, opsAvg)
+	r.ops = len(latencies)
+	r.latencies = perfutils.ComputeLatencyStats(latencies)
+	return r
 }